@@ -2,6 +2,7 @@ package cli
 
 import (
 	"bytes"
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -9,6 +10,7 @@ import (
 	"strings"
 
 	"github.com/rifchzschki/Audio-Steganografi/backend/models"
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/flac"
 	"github.com/rifchzschki/Audio-Steganografi/backend/service"
 	"github.com/rifchzschki/Audio-Steganografi/backend/service/decoder"
 	"github.com/rifchzschki/Audio-Steganografi/backend/service/encoder"
@@ -24,64 +26,224 @@ func Run(args []string) {
 	case "stegano-audio":
 		SteganoAudioExample()
 	case "en-x":
-		EncodeX()
+		EncodeX(args[1:])
 	case "dec-x":
-		DecodeX()
+		DecodeX(args[1:])
+	case "capacity":
+		CapacityX(args[1:])
+	case "flac-roundtrip":
+		FlacRoundTrip(args[1:])
+	case "keygen":
+		KeygenX(args[1:])
+	case "pubkey":
+		PubkeyX(args[1:])
 	default:
 		fmt.Println("Unknown command")
 	}
 }
 
-func DecodeX(){
-    inputFile := "stego.mp3"     
-    outputDir:= "output.png"  
-    key := "STEGANO"            
-    random := true              
-    debug := false              
-    
-	if outputDir != "" {
-        if err := os.MkdirAll(outputDir, 0755); err != nil {
-            fmt.Errorf("failed to create output directory: %v", err)
+func DecodeX(args []string) {
+	fs := flag.NewFlagSet("dec-x", flag.ExitOnError)
+	inputFile := fs.String("in", "stego.mp3", "stego audio file (MP3, WAV, FLAC or AIFF)")
+	outputName := fs.String("name", "", "output file name (default: embedded name)")
+	key := fs.String("key", "STEGANO", "stego key")
+	random := fs.Bool("random", true, "try random positions first in files of format version 1")
+	debug := fs.Bool("debug", false, "print debug information")
+	identity := fs.String("identity", "", "key file with the secret keys of payloads encrypted to recipients")
+	trusted := fs.String("trusted", "", "file of trusted signing public keys, one per line, each optionally followed by its owner's name")
+	fs.Parse(args)
+
+	opts := decoder.Options{Key: *key, Random: *random, Debug: *debug}
+	if *identity != "" {
+		ids, err := service.LoadIdentities(*identity)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
 			os.Exit(1)
-        }
-    }
-
-	extractedFile, err := decoder.DecodeFile(inputFile, key,outputDir, random, debug)
-    if err != nil {
-		fmt.Errorf("failed to get extracted file info: %v", err)
-        os.Exit(1)
-    }
-
-    // Get file info
-    fileInfo, err := os.Stat(extractedFile)
-    if err != nil {
-        fmt.Errorf("failed to get extracted file info: %v", err)
+		}
+		opts.Identities = ids
+	}
+	if *trusted != "" {
+		keys, err := service.LoadTrusted(*trusted)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		opts.Trusted = keys
+	}
+	res, err := decoder.DecodeFile(*inputFile, *outputName, opts)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
-    }
+	}
+	extractedFile := res.Output
 
-    ext := strings.ToLower(filepath.Ext(extractedFile))
-    fmt.Printf("Successfully extracted %s file: %s (%d bytes)\n", ext, extractedFile, fileInfo.Size())
+	// Get file info
+	fileInfo, err := os.Stat(extractedFile)
+	if err != nil {
+		fmt.Printf("Error: failed to get extracted file info: %v\n", err)
+		os.Exit(1)
+	}
 
-    return
+	ext := strings.ToLower(filepath.Ext(extractedFile))
+	fmt.Printf("Successfully extracted %s file: %s (%d bytes)\n", ext, extractedFile, fileInfo.Size())
+	if s := res.Signature.Status; s == service.SignatureInvalid || s == service.SignatureUntrusted {
+		fmt.Printf("Warning: signature %s\n", res.Signature)
+	}
 }
 
-func EncodeX(){
-    inputMP3 := "cover.mp3"      
-    secretFile := "graph.png"   
-    outputMP3 := "stego.mp3"     
-    key := "STEGANO"             // Encryption key/seed
-    width := 4                   // LSB width (1, 2, 3, or 4)
-    encrypt := false             
-    random := true               
-	outputName, psnrVal, audioQuality, err := encoder.EncodeFile(inputMP3, secretFile, outputMP3, key, width, encrypt, random)
-    if err != nil {
-        fmt.Printf("Error: %v\n", err)
-        os.Exit(1)
-    }
-	fmt.Printf("Output File: %s\n", outputName)
-	fmt.Printf("Psnr Value: %f\n", psnrVal)
-	fmt.Printf("Audio Quality: %s\n", audioQuality)
+func EncodeX(args []string) {
+	fs := flag.NewFlagSet("en-x", flag.ExitOnError)
+	inputMP3 := fs.String("cover", "cover.mp3", "cover audio file (MP3, WAV, FLAC or AIFF)")
+	secretFile := fs.String("secret", "graph.png", "secret file to embed")
+	outputMP3 := fs.String("out", "", "stego output file (default: stego_<cover>)")
+	key := fs.String("key", "STEGANO", "encryption key/seed")
+	width := fs.Int("width", 4, "LSB width (1, 2, 3, or 4)")
+	algorithm := fs.String("algo", encoder.AlgorithmLSB, "embedding algorithm: lsb, huffman (MP3 coefficient parity), ancillary (MP3 ignored bits) or id3-priv, id3-geob, id3-padding (MP3 ID3v2 tag); all but lsb need width 1")
+	crcMode := fs.String("crc", encoder.CRCRecompute, "CRC of protected MP3 frames: recompute, or exclude the bits it covers")
+	encrypt := fs.Bool("encrypt", false, "encrypt payload with Extended Vigenere")
+	encryption := fs.String("encryption", "", "payload cipher: none, vigenere, aes-256-gcm or chacha20-poly1305 (default: vigenere with -encrypt, none without)")
+	random := fs.Bool("random", true, "use key-derived random positions")
+	kdf := fs.String("kdf", "argon2id", "key derivation function stretching the key: argon2id or scrypt")
+	checksum := fs.String("checksum", "crc32c", "digest of the secret the decoder checks: crc32c, sha256 or none")
+	compression := fs.String("compression", "none", "compress the secret before encryption: none, deflate or zstd")
+	matrix := fs.Int("matrix", 0, "matrix embedding: k bits per block of 2^k-1 units with a Hamming code, changing at most one, k 2 to 8; needs -width 1 (0: one bit per unit)")
+	ecc := fs.Int("ecc", 0, "Reed-Solomon parity bytes per 255 byte codeword, 2 to 127, correcting half as many wrong bytes (0: no error correction)")
+	interleave := fs.Int("interleave", 0, "codewords interleaved against bursts of errors, 1 to 255 (default 16 with -ecc)")
+	var recipients listFlag
+	fs.Var(&recipients, "recipient", "public key to encrypt the payload to instead of with the key, repeat for more recipients")
+	sign := fs.String("sign", "", "key file with the Ed25519 key to sign the payload with")
+	quality := fs.Bool("quality", true, "decode cover and stego to compare the audio; off lets sequential LSB stream MP3 and WAV covers in bounded memory")
+	fs.Parse(args)
 
+	if *outputMP3 == "" {
+		*outputMP3 = filepath.Join(filepath.Dir(*inputMP3), "stego_"+filepath.Base(*inputMP3))
+	}
+	var signer *service.SigningKey
+	if *sign != "" {
+		var err error
+		if signer, err = service.LoadSigningKey(*sign); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	res, err := encoder.EncodeFile(*inputMP3, *secretFile, *outputMP3, encoder.Options{
+		Key:         *key,
+		Width:       *width,
+		Matrix:      *matrix,
+		Algorithm:   *algorithm,
+		CRC:         *crcMode,
+		Encrypt:     *encrypt,
+		Encryption:  *encryption,
+		Recipients:  recipients,
+		Signer:      signer,
+		Random:      *random,
+		KDF:         *kdf,
+		Checksum:    *checksum,
+		Compression: *compression,
+		ECC:         *ecc,
+		Interleave:  *interleave,
+		Quality:     *quality,
+	})
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Output File: %s (%s)\n", res.Output, res.Format)
+	if res.Ratio < 1 {
+		fmt.Printf("Compression: %s, ratio %.3f\n", res.Compression, res.Ratio)
+	}
+	if res.ECC != "" {
+		fmt.Printf("Error correction: %s\n", res.ECC)
+	}
+	if res.Matrix != "" {
+		fmt.Printf("Matrix embedding: %s, %.2f bits per change, PSNR gain %.2f dB over plain LSB\n", res.Matrix, res.Efficiency, res.PSNRGain)
+	}
+	fmt.Printf("Psnr Value (%s): %f\n", res.Domain, res.PSNR)
+	if res.Audio != nil {
+		fmt.Printf("Psnr Value (decoded audio): %s\n", res.Audio)
+	}
+	if res.Metrics != nil {
+		fmt.Printf("Quality Metrics:\n%s\n", res.Metrics)
+	}
+	fmt.Printf("Audio Quality: %s\n", res.Quality)
+}
+
+// CapacityX prints how large a secret a cover can hold in every mode.
+func CapacityX(args []string) {
+	fs := flag.NewFlagSet("capacity", flag.ExitOnError)
+	cover := fs.String("cover", "cover.mp3", "cover audio file (MP3, WAV, FLAC or AIFF)")
+	name := fs.String("name", "", "secret file name (overrides -name-len and -ext-len)")
+	nameLength := fs.Int("name-len", 16, "secret file name length in bytes")
+	extLength := fs.Int("ext-len", 4, "secret file extension length in bytes, dot included")
+	ratio := fs.Float64("ratio", 1, "compressed over original size expected of the secret, below 1 when it is compressed")
+	ecc := fs.Int("ecc", 0, "Reed-Solomon parity bytes per codeword the secret is to be coded with (0: none)")
+	matrix := fs.Int("matrix", 0, "also list the modes matrix embedding with this k (0: none)")
+	fs.Parse(args)
+
+	if *name != "" {
+		*nameLength, *extLength = len(filepath.Base(*name)), len(filepath.Ext(*name))
+	}
+	report, err := encoder.Capacity(*cover, *nameLength, *extLength, *ratio, *ecc, *matrix)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Format: %s\n", report.Format)
+	fmt.Printf("Overhead: %d bytes (name %d bytes, extension %d bytes)\n", report.Overhead, report.NameLength, report.ExtLength)
+	if report.Ratio < 1 {
+		fmt.Printf("Secrets compressed to %.0f%% of their size\n", report.Ratio*100)
+	}
+	if report.ECC != "" {
+		fmt.Printf("Error correction: %s\n", report.ECC)
+	}
+	for _, m := range report.Modes {
+		fmt.Println(m)
+	}
+}
+
+// FlacRoundTrip re-encodes a FLAC file and checks that decoding the result
+// gives back exactly the same samples.
+func FlacRoundTrip(args []string) {
+	fs := flag.NewFlagSet("flac-roundtrip", flag.ExitOnError)
+	inputFile := fs.String("in", "cover.flac", "FLAC file to re-encode")
+	outputFile := fs.String("out", "", "optional path to write the re-encoded FLAC")
+	fs.Parse(args)
+
+	data, err := os.ReadFile(*inputFile)
+	if err != nil {
+		log.Fatalf("Read error: %v", err)
+	}
+	f, err := flac.Parse(data)
+	if err != nil {
+		log.Fatalf("Decode error: %v", err)
+	}
+	original := f.PCM.Clone()
+
+	out := flac.Serialize(f)
+	g, err := flac.Parse(out)
+	if err != nil {
+		log.Fatalf("Decode of re-encoded stream failed: %v", err)
+	}
+
+	same := len(g.PCM.Samples) == len(original.Samples)
+	for i := 0; same && i < len(original.Samples); i++ {
+		same = g.PCM.Samples[i] == original.Samples[i]
+	}
+
+	fmt.Printf("Frames: %d, samples: %d, %d Hz, %d ch, %d bit\n", len(f.Sizes), original.Frames(), f.Info.SampleRate, f.Info.Channels, f.Info.BitsPerSample)
+	fmt.Printf("Size: %d -> %d bytes\n", len(data), len(out))
+	fmt.Printf("Samples identical: %v\n", same)
+	fmt.Printf("MD5 matches STREAMINFO: %v\n", g.Info.MD5 == f.Info.MD5)
+
+	if *outputFile != "" {
+		if err := os.WriteFile(*outputFile, out, 0644); err != nil {
+			log.Fatalf("Write error: %v", err)
+		}
+	}
+	if !same {
+		os.Exit(1)
+	}
 }
 
 func ExtendedVignereExample() {
@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rifchzschki/Audio-Steganografi/backend/service"
+)
+
+// listFlag collects the values of a flag given more than once.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// KeygenX creates an X25519 key pair payloads can be encrypted to, or
+// an Ed25519 key pair to sign them with.
+func KeygenX(args []string) {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	out := fs.String("out", "stego.key", "key file to create, keep it secret")
+	sign := fs.Bool("sign", false, "create a signing key instead of an encryption key")
+	fs.Parse(args)
+
+	var public string
+	if *sign {
+		k, err := service.GenerateSigningKey()
+		if err == nil {
+			err = service.WriteSigningKey(*out, k)
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		public = service.FormatSigner(k.Public())
+	} else {
+		id, err := service.GenerateIdentity()
+		if err == nil {
+			err = service.WriteIdentity(*out, id)
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		public = id.Recipient()
+	}
+	fmt.Printf("Secret key written to %s\n", *out)
+	fmt.Printf("Public key: %s\n", public)
+}
+
+// PubkeyX loads a key file and prints the public keys to publish.
+func PubkeyX(args []string) {
+	fs := flag.NewFlagSet("pubkey", flag.ExitOnError)
+	in := fs.String("identity", "stego.key", "key file created by keygen")
+	fs.Parse(args)
+
+	ids, err := service.LoadIdentities(*in)
+	if err != nil {
+		// Not encryption keys, maybe a signing key
+		if k, serr := service.LoadSigningKey(*in); serr == nil {
+			fmt.Println(service.FormatSigner(k.Public()))
+			return
+		}
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	for _, id := range ids {
+		fmt.Println(id.Recipient())
+	}
+}
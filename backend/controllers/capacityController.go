@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rifchzschki/Audio-Steganografi/backend/models"
+	"github.com/rifchzschki/Audio-Steganografi/backend/service/encoder"
+)
+
+// HandleCapacity reports how large a secret an uploaded cover can hold,
+// before the secret itself is uploaded. The overhead depends on the secret
+// file name: pass secretFilename, or nameLength and extLength.
+func HandleCapacity(c *gin.Context) {
+	if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
+		c.JSON(http.StatusBadRequest, models.NewCapacityResponse(false, "Failed to parse form"))
+		return
+	}
+	audioFile, audioHeader, err := c.Request.FormFile("audioFile")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.NewCapacityResponse(false, "No audio file uploaded"))
+		return
+	}
+	defer audioFile.Close()
+
+	if _, err := detectUpload(audioFile); err != nil {
+		c.JSON(http.StatusUnsupportedMediaType, models.NewCapacityResponse(false, "Audio file rejected: "+err.Error()))
+		return
+	}
+
+	nameLength, err := strconv.Atoi(c.DefaultPostForm("nameLength", "16"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.NewCapacityResponse(false, "Invalid name length"))
+		return
+	}
+	extLength, err := strconv.Atoi(c.DefaultPostForm("extLength", "4"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.NewCapacityResponse(false, "Invalid extension length"))
+		return
+	}
+	// Expected compressed over original size of the secret, 1 when it is
+	// not compressed
+	ratio, err := strconv.ParseFloat(c.DefaultPostForm("compressionRatio", "1"), 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.NewCapacityResponse(false, "Invalid compression ratio"))
+		return
+	}
+	// Reed-Solomon parity bytes per codeword, 0 when not coded
+	parity, err := strconv.Atoi(c.DefaultPostForm("ecc", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.NewCapacityResponse(false, "Invalid ecc"))
+		return
+	}
+	// k of matrix embedding to list the modes of too, 0 for none
+	matrix, err := strconv.Atoi(c.DefaultPostForm("matrix", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.NewCapacityResponse(false, "Invalid matrix"))
+		return
+	}
+	if name := filepath.Base(c.PostForm("secretFilename")); name != "." && name != "/" {
+		nameLength, extLength = len(name), len(filepath.Ext(name))
+	}
+
+	tempDir := "./tmp"
+	os.MkdirAll(tempDir, 0755)
+	audioPath := filepath.Join(tempDir, audioHeader.Filename)
+	if err := c.SaveUploadedFile(audioHeader, audioPath); err != nil {
+		c.JSON(http.StatusInternalServerError, models.NewCapacityResponse(false, "Failed to save audio file"))
+		return
+	}
+	defer os.Remove(audioPath)
+
+	report, err := encoder.Capacity(audioPath, nameLength, extLength, ratio, parity, matrix)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.NewCapacityResponse(false, err.Error()))
+		return
+	}
+
+	resp := models.NewCapacityResponse(true, "Capacity computed")
+	resp.Format = report.Format
+	resp.NameLength = report.NameLength
+	resp.ExtLength = report.ExtLength
+	resp.CompressionRatio = report.Ratio
+	resp.Overhead = report.Overhead
+	resp.ECC = report.ECC
+	for _, m := range report.Modes {
+		resp.Modes = append(resp.Modes, models.CapacityMode{
+			Algorithm: m.Algorithm,
+			CRC:       m.CRC,
+			Width:     m.Width,
+			Matrix:    m.Matrix,
+			Unit:      m.Unit,
+			Eligible:  m.Eligible,
+			Total:     m.Total,
+			Bytes:     m.Bytes,
+			MaxSecret: m.MaxSecret,
+			MaxSealed: m.MaxSealed,
+			Unlimited: m.Unlimited,
+		})
+	}
+	c.JSON(http.StatusOK, resp)
+}
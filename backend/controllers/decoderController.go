@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
     "net/http"
     "os"
@@ -8,9 +9,14 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/rifchzschki/Audio-Steganografi/backend/models"
+	"github.com/rifchzschki/Audio-Steganografi/backend/service"
 	"github.com/rifchzschki/Audio-Steganografi/backend/service/decoder"
 )
 
+// trustedKeysEnv names the file of public keys whose signatures are
+// trusted, in the format of service.ParseTrusted.
+const trustedKeysEnv = "STEGO_TRUSTED_KEYS"
+
 func HandleDecode(c *gin.Context){
 	debug := false
 
@@ -28,9 +34,35 @@ func HandleDecode(c *gin.Context){
     }
 	defer stegoFile.Close()
 
+    if _, err := detectUpload(stegoFile); err != nil {
+        resp := models.NewExtractResponse(false, "Stego file rejected: "+err.Error(), "", "")
+        c.JSON(http.StatusUnsupportedMediaType, resp)
+        return
+    }
+
 	key := c.PostForm("key")
     useRandomStart := c.PostForm("useRandomStart") == "true"
     outputFileName := c.PostForm("outputFileName")
+    // Secret keys of payloads encrypted to recipients, one per line
+    identities, err := service.ParseIdentities(c.PostForm("identity"))
+    if err != nil {
+        resp := models.NewExtractResponse(false, "Invalid identity: "+err.Error(), "", "")
+        c.JSON(http.StatusBadRequest, resp)
+        return
+    }
+    // Signatures are verified against the keys of the file named by
+    // STEGO_TRUSTED_KEYS and those sent along
+    trusted, err := service.ParseTrusted(c.PostForm("trustedKeys"))
+    if err == nil && os.Getenv(trustedKeysEnv) != "" {
+        var configured []service.TrustedKey
+        configured, err = service.LoadTrusted(os.Getenv(trustedKeysEnv))
+        trusted = append(configured, trusted...)
+    }
+    if err != nil {
+        resp := models.NewExtractResponse(false, "Invalid trusted keys: "+err.Error(), "", "")
+        c.JSON(http.StatusBadRequest, resp)
+        return
+    }
 
 	if key == "" {
         resp := models.NewExtractResponse(false, "Key is required", "", "")
@@ -52,12 +84,28 @@ func HandleDecode(c *gin.Context){
 	outputDir := "./output"
     os.MkdirAll(outputDir, 0755)
     
-	extractedFile, err := decoder.DecodeFile(stegoPath, key, outputFileName, useRandomStart, debug)
+	res, err := decoder.DecodeFile(stegoPath, outputFileName, decoder.Options{
+        Key:        key,
+        Random:     useRandomStart,
+        Debug:      debug,
+        Identities: identities,
+        Trusted:    trusted,
+    })
     if err != nil {
         resp := models.NewExtractResponse(false, err.Error(), "", "")
+        // Found but corrupted or cut short, unlike a wrong key or no
+        // payload: service.ErrWrongKey and decoder.ErrNotFound are both
+        // bad requests
+        var ie *decoder.IntegrityError
+        if errors.As(err, &ie) {
+            resp.Integrity = "failed: " + ie.Reason
+            c.JSON(http.StatusUnprocessableEntity, resp)
+            return
+        }
         c.JSON(http.StatusBadRequest, resp)
         return
     }
+    extractedFile := res.Output
 
 	finalPath := filepath.Join(outputDir, filepath.Base(extractedFile))
     if extractedFile != finalPath {
@@ -71,6 +119,13 @@ func HandleDecode(c *gin.Context){
     }
 
     resp := models.NewExtractResponse(true, "Decode Success", extractedFile, filepath.Base(extractedFile))
+    resp.Integrity = res.Integrity + " verified"
+    resp.ECC, resp.Corrected = res.ECC, res.Corrected
+    resp.Signature = &models.SignatureStatus{
+        Status:    res.Signature.Status,
+        Signer:    res.Signature.Signer,
+        PublicKey: res.Signature.Key,
+    }
     c.JSON(http.StatusOK, resp)
 
 }
@@ -2,16 +2,24 @@ package controllers
 
 import (
 	"fmt"
+    "math"
     "net/http"
     "os"
     "path/filepath"
     "strconv"
+    "strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rifchzschki/Audio-Steganografi/backend/models"
+	"github.com/rifchzschki/Audio-Steganografi/backend/service"
 	"github.com/rifchzschki/Audio-Steganografi/backend/service/encoder"
+	"github.com/rifchzschki/Audio-Steganografi/backend/utils/metrics"
 )
 
+// qualityLimit is the largest cover whose decoded audio is compared by
+// default.
+const qualityLimit = 32 << 20
+
 func HandleEncode(c *gin.Context){
 	err := c.Request.ParseMultipartForm(32 << 20) // 32 MB max
     if err != nil {
@@ -28,6 +36,13 @@ func HandleEncode(c *gin.Context){
     }
     defer audioFile.Close()
 
+    format, err := detectUpload(audioFile)
+    if err != nil {
+        resp := models.NewStegoResponse(false, "Audio file rejected: "+err.Error(), 0.0, "")
+        c.JSON(http.StatusUnsupportedMediaType, resp)
+        return
+    }
+
 	secretFile, secretHeader, err := c.Request.FormFile("secretFile")
     if err != nil {
         resp := models.NewStegoResponse(false, "No secret file uploaded", 0.0, "")
@@ -39,7 +54,54 @@ func HandleEncode(c *gin.Context){
 	key := c.PostForm("key")
     lsbBitsStr := c.PostForm("lsbBits")
     useEncryption := c.PostForm("useEncryption") == "true"
+    encryption := c.PostForm("encryption")
+    var signer *service.SigningKey
+    if v := strings.TrimSpace(c.PostForm("signingKey")); v != "" {
+        if signer, err = service.ParseSigningKey(v); err != nil {
+            resp := models.NewStegoResponse(false, "Invalid signing key: "+err.Error(), 0.0, "")
+            c.JSON(http.StatusBadRequest, resp)
+            return
+        }
+    }
+    // Public keys to encrypt to, in several fields or separated by commas
+    // or white space
+    var recipients []string
+    for _, v := range c.PostFormArray("recipients") {
+        recipients = append(recipients, strings.Fields(strings.ReplaceAll(v, ",", " "))...)
+    }
     useRandomStart := c.PostForm("useRandomStart") == "true"
+    algorithm := c.DefaultPostForm("algorithm", encoder.AlgorithmLSB)
+    crcMode := c.DefaultPostForm("crc", encoder.CRCRecompute)
+    kdf := c.PostForm("kdf")
+    checksum := c.PostForm("checksum")
+    compression := c.PostForm("compression")
+    // Reed-Solomon parity bytes per codeword and interleaving depth, 0
+    // for no error correction and the default depth
+    ecc, err := strconv.Atoi(c.DefaultPostForm("ecc", "0"))
+    if err != nil {
+        resp := models.NewStegoResponse(false, "Invalid ecc (must be a number)", 0.0, "")
+        c.JSON(http.StatusBadRequest, resp)
+        return
+    }
+    interleave, err := strconv.Atoi(c.DefaultPostForm("interleave", "0"))
+    if err != nil {
+        resp := models.NewStegoResponse(false, "Invalid interleave (must be a number)", 0.0, "")
+        c.JSON(http.StatusBadRequest, resp)
+        return
+    }
+    // k of matrix embedding, 0 for one bit per unit
+    matrix, err := strconv.Atoi(c.DefaultPostForm("matrix", "0"))
+    if err != nil {
+        resp := models.NewStegoResponse(false, "Invalid matrix (must be a number)", 0.0, "")
+        c.JSON(http.StatusBadRequest, resp)
+        return
+    }
+    // Comparing the decoded audio holds cover and stego in memory, large
+    // covers are streamed without it unless asked for
+    quality := audioHeader.Size <= qualityLimit
+    if v := c.PostForm("quality"); v != "" {
+        quality = v == "true"
+    }
 
 	if key == "" {
         resp := models.NewStegoResponse(false, "Key is required", 0.0, "")
@@ -77,19 +139,95 @@ func HandleEncode(c *gin.Context){
 	outputDir := "./output"
     os.MkdirAll(outputDir, 0755)
 
-	outputMP3 := filepath.Join(outputDir, "stego_"+audioHeader.Filename)
+	// The output keeps the detected format's extension, whatever the upload was named
+	base := filepath.Base(audioHeader.Filename)
+	outputMP3 := filepath.Join(outputDir, "stego_"+strings.TrimSuffix(base, filepath.Ext(base))+format.Extension())
 
-	outputName, psnrVal, _, err := encoder.EncodeFile(audioPath, secretPath, outputMP3, key, lsbBits, useEncryption, useRandomStart)
+	res, err := encoder.EncodeFile(audioPath, secretPath, outputMP3, encoder.Options{
+        Key:         key,
+        Width:       lsbBits,
+        Matrix:      matrix,
+        Algorithm:   algorithm,
+        CRC:         crcMode,
+        Encrypt:     useEncryption,
+        Encryption:  encryption,
+        Recipients:  recipients,
+        Signer:      signer,
+        Random:      useRandomStart,
+        KDF:         kdf,
+        Checksum:    checksum,
+        Compression: compression,
+        ECC:         ecc,
+        Interleave:  interleave,
+        Quality:     quality,
+    })
     if err != nil {
         resp := models.NewStegoResponse(false, err.Error(), 0.0, "")
         c.JSON(http.StatusBadRequest, resp)
         return
     }
 
-	resp := models.NewStegoResponse(true, "Encode Success", psnrVal, filepath.Base(outputName))
+    // JSON has no infinity, an unchanged signal is reported in the message
+    message := "Encode Success"
+    psnrVal := finite(res.PSNR)
+    if res.Audio != nil && math.IsInf(res.Audio.PSNR, 1) || res.Audio == nil && math.IsInf(res.PSNR, 1) {
+        message = "Encode Success (decoded audio unchanged)"
+    }
+	resp := models.NewStegoResponse(true, message, psnrVal, filepath.Base(res.Output))
+    resp.PSNRDomain = res.Domain
+    resp.Capacity = res.Capacity
+    if a := res.Audio; a != nil {
+        resp.AudioPSNR = &models.AudioPSNR{
+            PSNR:              finite(a.PSNR),
+            MSE:               a.MSE,
+            Identical:         math.IsInf(a.PSNR, 1),
+            Samples:           a.Samples,
+            CoverFailedFrames: a.CoverFailed,
+            StegoFailedFrames: a.StegoFailed,
+            UnmatchedFrames:   a.Skipped,
+        }
+        for _, ch := range a.Channels {
+            resp.AudioPSNR.Channels = append(resp.AudioPSNR.Channels, models.ChannelPSNR{
+                PSNR:      finite(ch.PSNR),
+                MSE:       ch.MSE,
+                Identical: math.IsInf(ch.PSNR, 1),
+            })
+        }
+    }
+    if m := res.Metrics; m != nil {
+        resp.Metrics = &models.QualityReport{
+            SNR:            finite(m.SNR),
+            SegmentalSNR:   m.SegmentalSNR,
+            LSD:            m.LSD,
+            FlatnessCover:  m.FlatnessCover,
+            FlatnessStego:  m.FlatnessStego,
+            FlatnessChange: m.FlatnessChange,
+            NMR:            finite(m.NMR),
+            ODG:            m.ODG,
+            Grade:          metrics.Grade(m.ODG),
+            MaxDeviation:   m.MaxDeviation,
+            Samples:        m.Samples,
+            Identical:      math.IsInf(m.SNR, 1),
+        }
+    }
+    resp.CRC = res.CRC
+    resp.Compression = res.Compression
+    resp.CompressionRatio = res.Ratio
+    resp.ECC = res.ECC
+    resp.Matrix = res.Matrix
+    resp.Efficiency = finite(res.Efficiency)
+    resp.PSNRGain = finite(res.PSNRGain)
     c.JSON(http.StatusOK, resp)
 }
 
+// finite maps the infinite figures of identical signals to 0 for JSON.
+func finite(v float64) float64 {
+    if math.IsInf(v, 0) {
+        return 0
+    }
+    return v
+}
+
 func HandleDownloadStego(c *gin.Context) {
     filename := c.Param("filename")
     if filename == "" {
@@ -108,7 +246,7 @@ func HandleDownloadStego(c *gin.Context) {
     // Set headers for download
     c.Header("Content-Description", "File Transfer")
     c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
-    c.Header("Content-Type", "audio/mpeg")
+    c.Header("Content-Type", audioContentType(filePath))
     c.File(filePath)
 }
 
@@ -128,7 +266,7 @@ func HandlePlayStego(c *gin.Context) {
     }
 
     // Set headers for audio streaming
-    c.Header("Content-Type", "audio/mpeg")
+    c.Header("Content-Type", audioContentType(filePath))
     c.Header("Accept-Ranges", "bytes")
     c.File(filePath)
 }
\ No newline at end of file
@@ -1,8 +1,46 @@
 package controllers
 
-import "github.com/gin-gonic/gin"
+import (
+	"bufio"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
 
-func HandleFileUploadAudio(c *gin.Context, ) {
-	// Implement file upload handling logic here
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/cover"
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/mp3"
+)
 
-}
\ No newline at end of file
+// detectUpload sniffs the format of an uploaded audio file, so unsupported
+// uploads are rejected before anything is saved or processed. Only the
+// start of the file is read, as much as the streaming decoder peeks.
+func detectUpload(file multipart.File) (cover.CoverFormat, error) {
+	format, err := cover.DetectStream(bufio.NewReaderSize(file, mp3.StreamBuffer))
+	if _, serr := file.Seek(0, io.SeekStart); serr != nil {
+		return nil, serr
+	}
+	return format, err
+}
+
+// audioContentType picks the MIME type of a stego file from the start of
+// its content, falling back to its extension.
+func audioContentType(path string) string {
+	if in, err := os.Open(path); err == nil {
+		format, err := cover.DetectStream(bufio.NewReaderSize(in, mp3.StreamBuffer))
+		in.Close()
+		if err == nil {
+			return format.MIME()
+		}
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".wav", ".wave":
+		return "audio/wav"
+	case ".flac":
+		return "audio/flac"
+	case ".aif", ".aiff", ".aifc":
+		return "audio/aiff"
+	default:
+		return "audio/mpeg"
+	}
+}
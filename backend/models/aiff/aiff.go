@@ -0,0 +1,225 @@
+package aiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/pcm"
+)
+
+type Chunk struct {
+	ID   string
+	Data []byte
+}
+
+type File struct {
+	AIFC        bool
+	Compression string // AIFF-C compression type, "NONE" for plain AIFF
+	Channels    int
+	Frames      int
+	SampleSize  int
+	SampleRate  float64
+	Chunks      []*Chunk // every chunk in file order (COMM, SSND, MARK, ANNO, ...)
+	PCM         *pcm.Buffer
+	ssndHead    []byte // offset/blockSize fields and the offset padding before the samples
+	tail        []byte // trailing SSND bytes that do not form a whole frame
+}
+
+// IsAIFF reports whether data starts with a FORM/AIFF or FORM/AIFC header.
+func IsAIFF(data []byte) bool {
+	if len(data) < 12 || !bytes.Equal(data[0:4], []byte("FORM")) {
+		return false
+	}
+	form := string(data[8:12])
+	return form == "AIFF" || form == "AIFC"
+}
+
+func Parse(data []byte) (*File, error) {
+	if !IsAIFF(data) {
+		return nil, errors.New("not an AIFF/AIFF-C file")
+	}
+
+	f := &File{AIFC: string(data[8:12]) == "AIFC", Compression: "NONE"}
+	end := 8 + int(binary.BigEndian.Uint32(data[4:8]))
+	if end > len(data) || end < 12 {
+		end = len(data)
+	}
+
+	var comm, ssnd *Chunk
+	i := 12
+	for i+8 <= end {
+		id := string(data[i : i+4])
+		size := int(binary.BigEndian.Uint32(data[i+4 : i+8]))
+		i += 8
+		if size < 0 || i+size > end {
+			size = end - i
+		}
+		c := &Chunk{ID: id, Data: append([]byte(nil), data[i:i+size]...)}
+		f.Chunks = append(f.Chunks, c)
+		switch id {
+		case "COMM":
+			comm = c
+		case "SSND":
+			ssnd = c
+		}
+		i += size + size&1
+	}
+
+	if comm == nil {
+		return nil, errors.New("missing COMM chunk")
+	}
+	if ssnd == nil {
+		return nil, errors.New("missing SSND chunk")
+	}
+	if err := f.parseComm(comm.Data); err != nil {
+		return nil, err
+	}
+	if len(ssnd.Data) < 8 {
+		return nil, errors.New("SSND chunk too short")
+	}
+	offset := int(binary.BigEndian.Uint32(ssnd.Data[0:4]))
+	if 8+offset > len(ssnd.Data) {
+		return nil, errors.New("invalid SSND offset")
+	}
+	f.ssndHead = append([]byte(nil), ssnd.Data[:8+offset]...)
+	f.decodeSamples(ssnd.Data[8+offset:])
+	return f, nil
+}
+
+func (f *File) parseComm(b []byte) error {
+	if len(b) < 18 {
+		return errors.New("COMM chunk too short")
+	}
+	f.Channels = int(binary.BigEndian.Uint16(b[0:2]))
+	f.Frames = int(binary.BigEndian.Uint32(b[2:6]))
+	f.SampleSize = int(binary.BigEndian.Uint16(b[6:8]))
+	f.SampleRate = extendedToFloat(b[8:18])
+
+	if f.AIFC {
+		if len(b) < 22 {
+			return errors.New("AIFF-C COMM chunk too short")
+		}
+		f.Compression = string(b[18:22])
+	}
+	switch f.Compression {
+	case "NONE", "twos", "sowt":
+	default:
+		return fmt.Errorf("unsupported AIFF-C compression %q", f.Compression)
+	}
+	if f.Channels == 0 {
+		return errors.New("invalid channel count")
+	}
+	// The low byte of a sample is embedded in, so it must not reach into
+	// the padding
+	if f.SampleSize < 8 || f.SampleSize > 32 {
+		return fmt.Errorf("unsupported sample size: %d bits", f.SampleSize)
+	}
+	return nil
+}
+
+// decodeSamples decodes the samples of the whole frames in b. Samples are
+// left justified in their bytes, they are shifted down so that their lowest
+// bit is bit 0.
+func (f *File) decodeSamples(b []byte) {
+	width := (f.SampleSize + 7) / 8
+	n := len(b) / (width * f.Channels) * f.Channels
+	if f.Frames*f.Channels < n {
+		n = f.Frames * f.Channels
+	}
+	little := f.Compression == "sowt"
+
+	f.PCM = &pcm.Buffer{
+		SampleRate: int(math.Round(f.SampleRate)),
+		Channels:   f.Channels,
+		BitDepth:   f.SampleSize,
+		Samples:    make([]int32, n),
+	}
+	p := make([]byte, 4)
+	for k := 0; k < n; k++ {
+		s := b[k*width : (k+1)*width]
+		// Left-justify big-endian bytes into a 32-bit word
+		for j := range p {
+			p[j] = 0
+		}
+		for j := 0; j < width; j++ {
+			if little {
+				p[j] = s[width-1-j]
+			} else {
+				p[j] = s[j]
+			}
+		}
+		f.PCM.Samples[k] = int32(binary.BigEndian.Uint32(p)) >> uint(32-f.SampleSize)
+	}
+	f.tail = append([]byte(nil), b[n*width:]...)
+}
+
+// encodeSamples is the inverse of decodeSamples, the padding bits are
+// written as zero.
+func (f *File) encodeSamples() []byte {
+	width := (f.SampleSize + 7) / 8
+	shift := uint(width*8 - f.SampleSize)
+	little := f.Compression == "sowt"
+	out := make([]byte, len(f.PCM.Samples)*width)
+	for k, v := range f.PCM.Samples {
+		v <<= shift
+		p := out[k*width : (k+1)*width]
+		for j := 0; j < width; j++ {
+			b := byte(v >> uint(8*(width-1-j)))
+			if little {
+				p[width-1-j] = b
+			} else {
+				p[j] = b
+			}
+		}
+	}
+	return out
+}
+
+func Serialize(f *File) []byte {
+	var body []byte
+	if f.AIFC {
+		body = append(body, []byte("AIFC")...)
+	} else {
+		body = append(body, []byte("AIFF")...)
+	}
+
+	tmp := make([]byte, 4)
+	for _, c := range f.Chunks {
+		data := c.Data
+		if c.ID == "SSND" && f.PCM != nil {
+			data = append(append(append([]byte(nil), f.ssndHead...), f.encodeSamples()...), f.tail...)
+		}
+		body = append(body, []byte(c.ID)...)
+		binary.BigEndian.PutUint32(tmp, uint32(len(data)))
+		body = append(body, tmp...)
+		body = append(body, data...)
+		if len(data)%2 == 1 {
+			body = append(body, 0)
+		}
+	}
+
+	out := make([]byte, 0, 8+len(body))
+	out = append(out, []byte("FORM")...)
+	binary.BigEndian.PutUint32(tmp, uint32(len(body)))
+	out = append(out, tmp...)
+	return append(out, body...)
+}
+
+// extendedToFloat decodes the 80-bit IEEE 754 extended precision sample
+// rate stored in the COMM chunk.
+func extendedToFloat(b []byte) float64 {
+	exp := int(binary.BigEndian.Uint16(b[0:2]))
+	mant := binary.BigEndian.Uint64(b[2:10])
+	sign := 1.0
+	if exp&0x8000 != 0 {
+		sign = -1
+		exp &= 0x7FFF
+	}
+	if exp == 0 && mant == 0 {
+		return 0
+	}
+	return sign * math.Ldexp(float64(mant), exp-16383-63)
+}
@@ -0,0 +1,137 @@
+package aiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"slices"
+	"testing"
+)
+
+// fixture returns an AIFF, or AIFF-C with compression comp, file of
+// sampleSize bit mono samples, left justified in their bytes with the
+// padding zero as the format requires, and the samples.
+func fixture(sampleSize int, comp string) ([]byte, []int32) {
+	const frames = 3000
+	width := (sampleSize + 7) / 8
+	shift := uint(width*8 - sampleSize)
+	peak := float64(int64(1)<<uint(sampleSize-1) - 1)
+	samples := make([]int32, frames)
+	var data []byte
+	for k := range samples {
+		samples[k] = int32(math.Round(0.7 * peak * math.Sin(2*math.Pi*440*float64(k)/44100)))
+		p := make([]byte, width)
+		v := uint32(samples[k]) << shift
+		for j := 0; j < width; j++ {
+			p[j] = byte(v >> uint(8*(width-1-j)))
+		}
+		if comp == "sowt" {
+			slices.Reverse(p)
+		}
+		data = append(data, p...)
+	}
+
+	comm := binary.BigEndian.AppendUint16(nil, 1)
+	comm = binary.BigEndian.AppendUint32(comm, frames)
+	comm = binary.BigEndian.AppendUint16(comm, uint16(sampleSize))
+	// 44100 as an 80-bit extended float
+	comm = append(comm, 0x40, 0x0E, 0xAC, 0x44, 0, 0, 0, 0, 0, 0)
+	form := "AIFF"
+	if comp != "" {
+		form = "AIFC"
+		comm = append(comm, comp...)
+		comm = append(comm, 0) // empty compression name
+		comm = append(comm, 0)
+	}
+	ssnd := append(make([]byte, 8), data...)
+
+	body := []byte(form)
+	for _, c := range []struct {
+		id   string
+		data []byte
+	}{{"COMM", comm}, {"SSND", ssnd}} {
+		body = append(body, c.id...)
+		body = binary.BigEndian.AppendUint32(body, uint32(len(c.data)))
+		body = append(body, c.data...)
+		if len(c.data)%2 == 1 {
+			body = append(body, 0)
+		}
+	}
+	out := append([]byte("FORM"), binary.BigEndian.AppendUint32(nil, uint32(len(body)))...)
+	return append(out, body...), samples
+}
+
+func TestRoundTripSampleSizes(t *testing.T) {
+	tests := []struct {
+		name       string
+		sampleSize int
+		comp       string
+	}{
+		{"12-bit", 12, ""},
+		{"20-bit", 20, ""},
+		{"12-bit sowt", 12, "sowt"},
+		{"16-bit", 16, ""},
+		{"24-bit", 24, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, samples := fixture(tt.sampleSize, tt.comp)
+			f, err := Parse(data)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if f.PCM.BitDepth != tt.sampleSize {
+				t.Fatalf("bit depth %d, want %d", f.PCM.BitDepth, tt.sampleSize)
+			}
+			if !slices.Equal(f.PCM.Samples, samples) {
+				t.Fatal("decoded samples differ from the fixture")
+			}
+			if out := Serialize(f); !bytes.Equal(out, data) {
+				t.Fatal("serializing the unchanged file changed it")
+			}
+
+			// Embed in the lowest bit of every sample
+			lo := f.PCM.LowBytes()
+			for i := range lo {
+				lo[i] ^= 1
+			}
+			f.PCM.SetLowBytes(lo)
+			changed := slices.Clone(f.PCM.Samples)
+			out := Serialize(f)
+
+			g, err := Parse(out)
+			if err != nil {
+				t.Fatalf("parse of the changed file: %v", err)
+			}
+			if !slices.Equal(g.PCM.Samples, changed) {
+				t.Fatal("changed samples did not survive the round trip")
+			}
+			for k, s := range changed {
+				if s == samples[k] || s^samples[k] != 1 {
+					t.Fatalf("sample %d changed from %d to %d, not in its lowest bit", k, samples[k], s)
+				}
+			}
+			width := (tt.sampleSize + 7) / 8
+			pad := byte(1)<<uint(width*8-tt.sampleSize) - 1
+			sound := out[len(out)-len(samples)*width:]
+			for k := range samples {
+				low := sound[(k+1)*width-1]
+				if tt.comp == "sowt" {
+					low = sound[k*width]
+				}
+				if low&pad != 0 {
+					t.Fatalf("sample %d has padding bits set: %08b", k, low)
+				}
+			}
+		})
+	}
+}
+
+func TestRejectSampleSize(t *testing.T) {
+	data, _ := fixture(12, "")
+	// COMM sample size field of the fixture
+	binary.BigEndian.PutUint16(data[26:28], 4)
+	if _, err := Parse(data); err == nil {
+		t.Fatal("4-bit samples were accepted")
+	}
+}
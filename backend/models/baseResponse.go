@@ -44,8 +44,58 @@ type BaseStegoRequest struct {
 
 type StegoResponse struct {
 	BaseResponse
-	PSNR         float64 `json:"psnr,omitempty"`
-	StegoFileURL string  `json:"stego_file_url,omitempty"`
+	PSNR         float64        `json:"psnr,omitempty"`        // byte domain, see PSNRDomain
+	PSNRDomain   string         `json:"psnr_domain,omitempty"` // what PSNR was computed on
+	AudioPSNR    *AudioPSNR     `json:"audio_psnr,omitempty"`  // decoded MP3 samples
+	Metrics      *QualityReport `json:"metrics,omitempty"`     // decoded samples
+	Capacity     string         `json:"capacity,omitempty"`
+	CRC          string         `json:"crc,omitempty"`
+	StegoFileURL string         `json:"stego_file_url,omitempty"`
+	// Compression of the secret and its compressed over original size
+	Compression      string  `json:"compression,omitempty"`
+	CompressionRatio float64 `json:"compression_ratio,omitempty"`
+	ECC              string  `json:"ecc,omitempty"` // Reed-Solomon layout of the bit stream
+	// Matrix embedding code, bits embedded per unit changed and PSNR gain
+	// in dB over embedding one bit per unit
+	Matrix     string  `json:"matrix,omitempty"`
+	Efficiency float64 `json:"embedding_efficiency,omitempty"`
+	PSNRGain   float64 `json:"psnr_gain,omitempty"`
+}
+
+// AudioPSNR compares the cover and stego MP3 decoded to PCM. JSON has no
+// infinity, identical signals have PSNR 0 and Identical set.
+type AudioPSNR struct {
+	PSNR              float64       `json:"psnr"`
+	MSE               float64       `json:"mse"`
+	Identical         bool          `json:"identical"`
+	Channels          []ChannelPSNR `json:"channels"`
+	Samples           int           `json:"samples"`
+	CoverFailedFrames []int         `json:"cover_failed_frames,omitempty"`
+	StegoFailedFrames []int         `json:"stego_failed_frames,omitempty"`
+	UnmatchedFrames   int           `json:"unmatched_frames,omitempty"`
+}
+
+// QualityReport holds the objective quality figures of the decoded stego
+// audio against the cover. Infinite values of identical signals are 0.
+type QualityReport struct {
+	SNR            float64 `json:"snr"`
+	SegmentalSNR   float64 `json:"segmental_snr"`
+	LSD            float64 `json:"lsd"`
+	FlatnessCover  float64 `json:"flatness_cover"`
+	FlatnessStego  float64 `json:"flatness_stego"`
+	FlatnessChange float64 `json:"flatness_change"`
+	NMR            float64 `json:"nmr"`
+	ODG            float64 `json:"odg"`
+	Grade          string  `json:"grade"`
+	MaxDeviation   float64 `json:"max_deviation"`
+	Samples        int     `json:"samples"`
+	Identical      bool    `json:"identical"`
+}
+
+type ChannelPSNR struct {
+	PSNR      float64 `json:"psnr"`
+	MSE       float64 `json:"mse"`
+	Identical bool    `json:"identical"`
 }
 
 func NewStegoResponse(success bool, message string, psnr float64, url string) *StegoResponse {
@@ -68,8 +118,20 @@ type ExtractRequest struct {
 
 type ExtractResponse struct {
 	BaseResponse
-	SecretFileURL  string `json:"secret_file_url,omitempty"`
-	SecretFilename string `json:"secret_filename,omitempty"`
+	SecretFileURL  string           `json:"secret_file_url,omitempty"`
+	SecretFilename string           `json:"secret_filename,omitempty"`
+	Signature      *SignatureStatus `json:"signature,omitempty"`
+	Integrity      string           `json:"integrity,omitempty"` // checks the payload passed, or why it failed
+	ECC            string           `json:"ecc,omitempty"`       // Reed-Solomon layout of the bit stream
+	Corrected      int              `json:"corrected,omitempty"` // bytes it corrected
+}
+
+// SignatureStatus tells whether the payload was signed and by whom.
+// Status is unsigned, verified (by a trusted key), untrusted or invalid.
+type SignatureStatus struct {
+	Status    string `json:"status"`
+	Signer    string `json:"signer,omitempty"` // name of the trusted key
+	PublicKey string `json:"public_key,omitempty"`
 }
 
 func NewExtractResponse(success bool, message, fileURL, filename string) *ExtractResponse {
@@ -82,3 +144,39 @@ func NewExtractResponse(success bool, message, fileURL, filename string) *Extrac
 		SecretFilename: filename,
 	}
 }
+
+// CapacityResponse lists how large a secret a cover can hold per mode.
+type CapacityResponse struct {
+	BaseResponse
+	Format     string         `json:"format,omitempty"`
+	NameLength int            `json:"name_length,omitempty"`
+	ExtLength  int            `json:"ext_length,omitempty"`
+	Overhead   int            `json:"overhead,omitempty"` // bytes around the secret
+	Modes      []CapacityMode `json:"modes,omitempty"`
+	// Compressed over original size of the secret the maxima assume
+	CompressionRatio float64 `json:"compression_ratio,omitempty"`
+	ECC              string  `json:"ecc,omitempty"` // error correction they assume
+}
+
+type CapacityMode struct {
+	Algorithm string `json:"algorithm"`
+	CRC       string `json:"crc,omitempty"`
+	Width     int    `json:"width"`
+	Matrix    int    `json:"matrix,omitempty"` // k of matrix embedding
+	Unit      string `json:"unit"`
+	Eligible  int    `json:"eligible"`
+	Total     int    `json:"total"`
+	Bytes     int    `json:"bytes"`
+	MaxSecret int    `json:"max_secret"`
+	MaxSealed int    `json:"max_secret_aead"` // encrypted with an AEAD cipher
+	Unlimited bool   `json:"unlimited,omitempty"`
+}
+
+func NewCapacityResponse(success bool, message string) *CapacityResponse {
+	return &CapacityResponse{
+		BaseResponse: BaseResponse{
+			Success: success,
+			Message: message,
+		},
+	}
+}
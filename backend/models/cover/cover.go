@@ -0,0 +1,74 @@
+// Package cover picks the container backend of a cover file by its
+// content. Every supported format registers a CoverFormat; Detect and
+// Parse try them in registration order.
+package cover
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// CoverFormat is a container backend.
+type CoverFormat interface {
+	Name() string      // "MP3", "WAV", ...
+	Extension() string // file extension of the format, dot included
+	MIME() string
+	Detect(data []byte) bool // checks the magic bytes
+	Parse(data []byte) (Cover, error)
+}
+
+// Cover is a parsed cover file.
+type Cover interface {
+	// Units returns the embeddable units: the low byte of every sample or
+	// the data bytes of the MP3 audio frames.
+	Units() []byte
+	// Eligible returns the positions of Units that may carry data, nil when
+	// all of them may, and a summary of the capacity.
+	Eligible() ([]int, string)
+	// SetUnits writes modified units back.
+	SetUnits(units []byte)
+	Serialize() []byte
+}
+
+var formats []CoverFormat
+
+// Register adds a format. Formats registered first are detected first.
+func Register(f CoverFormat) {
+	formats = append(formats, f)
+}
+
+// Formats returns the registered formats.
+func Formats() []CoverFormat {
+	return formats
+}
+
+// ErrUnsupported is returned for data no registered format recognises.
+var ErrUnsupported = errors.New("unsupported audio format")
+
+// Detect returns the format of data.
+func Detect(data []byte) (CoverFormat, error) {
+	for _, f := range formats {
+		if f.Detect(data) {
+			return f, nil
+		}
+	}
+	names := make([]string, len(formats))
+	for i, f := range formats {
+		names[i] = f.Name()
+	}
+	return nil, fmt.Errorf("%w (expected %s)", ErrUnsupported, strings.Join(names, ", "))
+}
+
+// Parse detects the format of data and parses it.
+func Parse(data []byte) (Cover, CoverFormat, error) {
+	f, err := Detect(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := f.Parse(data)
+	if err != nil {
+		return nil, f, fmt.Errorf("failed to parse %s: %v", f.Name(), err)
+	}
+	return c, f, nil
+}
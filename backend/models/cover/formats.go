@@ -0,0 +1,122 @@
+package cover
+
+import (
+	"fmt"
+
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/aiff"
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/flac"
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/mp3"
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/pcm"
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/wav"
+)
+
+// Formats are detected in this order. MP3 comes last, its frame sync is
+// the least specific magic.
+func init() {
+	Register(streamPCMFormat{pcmFormat{"WAV", ".wav", "audio/wav", wav.IsWAV, func(data []byte) (*pcm.Buffer, func() []byte, error) {
+		w, err := wav.Parse(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		return w.PCM, func() []byte { return wav.Serialize(w) }, nil
+	}}, wav.Rewrite})
+	Register(pcmFormat{"AIFF", ".aiff", "audio/aiff", aiff.IsAIFF, func(data []byte) (*pcm.Buffer, func() []byte, error) {
+		af, err := aiff.Parse(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		return af.PCM, func() []byte { return aiff.Serialize(af) }, nil
+	}})
+	Register(pcmFormat{"FLAC", ".flac", "audio/flac", flac.IsFLAC, func(data []byte) (*pcm.Buffer, func() []byte, error) {
+		fl, err := flac.Parse(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		return fl.PCM, func() []byte { return flac.Serialize(fl) }, nil
+	}})
+	Register(mp3Format{})
+}
+
+// pcmFormat is a lossless container; its units are the low bytes of the
+// samples.
+type pcmFormat struct {
+	name, ext, mime string
+	detect          func([]byte) bool
+	parse           func([]byte) (*pcm.Buffer, func() []byte, error)
+}
+
+func (f pcmFormat) Name() string            { return f.name }
+func (f pcmFormat) Extension() string       { return f.ext }
+func (f pcmFormat) MIME() string            { return f.mime }
+func (f pcmFormat) Detect(data []byte) bool { return f.detect(data) }
+
+func (f pcmFormat) Parse(data []byte) (Cover, error) {
+	b, serialize, err := f.parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return &PCM{Buffer: b, serialize: serialize}, nil
+}
+
+// PCM is a parsed lossless cover.
+type PCM struct {
+	Buffer    *pcm.Buffer
+	serialize func() []byte
+}
+
+func (c *PCM) Units() []byte { return c.Buffer.LowBytes() }
+
+func (c *PCM) Eligible() ([]int, string) {
+	return nil, fmt.Sprintf("%d samples usable", len(c.Buffer.Samples))
+}
+
+func (c *PCM) SetUnits(units []byte) { c.Buffer.SetLowBytes(units) }
+func (c *PCM) Serialize() []byte     { return c.serialize() }
+
+type mp3Format struct{}
+
+func (mp3Format) Name() string            { return "MP3" }
+func (mp3Format) Extension() string       { return ".mp3" }
+func (mp3Format) MIME() string            { return "audio/mpeg" }
+func (mp3Format) Detect(data []byte) bool { return mp3.IsMP3(data) }
+
+func (mp3Format) Parse(data []byte) (Cover, error) {
+	f, err := mp3.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return &MP3{File: f}, nil
+}
+
+// MP3 is a parsed MP3 cover. Its units are the data bytes of the audio
+// frames, of which only the Layer III main data is eligible. The MP3
+// specific algorithms work on File directly.
+type MP3 struct {
+	File   *mp3.File
+	Strict bool // leave the CRC-protected bytes out of Eligible
+}
+
+func (c *MP3) Units() []byte {
+	var units []byte
+	for _, fr := range c.File.AudioFrames() {
+		units = append(units, fr.Data...)
+	}
+	return units
+}
+
+func (c *MP3) Eligible() ([]int, string) {
+	el := c.File.Eligibility()
+	if c.Strict {
+		el.ExcludeProtected()
+	}
+	return el.Positions(), el.String()
+}
+
+func (c *MP3) SetUnits(units []byte) {
+	i := 0
+	for _, fr := range c.File.AudioFrames() {
+		i += copy(fr.Data, units[min(i, len(units)):])
+	}
+}
+
+func (c *MP3) Serialize() []byte { return mp3.Serialize(c.File) }
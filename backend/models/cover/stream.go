@@ -0,0 +1,125 @@
+package cover
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/id3"
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/mp3"
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/pcm"
+)
+
+// StreamFormat is implemented by the formats that can be embedded in one
+// pass over the file, holding one frame or block of samples at a time
+// instead of the whole file.
+type StreamFormat interface {
+	CoverFormat
+	// Stream copies the cover from r to w, calling visit with consecutive
+	// runs of units in the order of Cover.Units and which of them are
+	// eligible, nil when all are. visit may change the units in place; an
+	// error from it stops the copy. In strict mode the CRC-protected MP3
+	// bytes are left out and no CRC is recomputed.
+	Stream(w io.Writer, r io.Reader, strict bool, visit func(units []byte, eligible []bool) error) (*StreamInfo, error)
+}
+
+// StreamInfo describes a streamed cover.
+type StreamInfo struct {
+	Capacity  string      // summary of the eligible units, as Cover.Eligible gives
+	Layout    *pcm.Buffer // sample format of a lossless cover, without samples
+	CRCBefore *mp3.CRCReport
+	CRCAfter  *mp3.CRCReport
+	Warning   string
+}
+
+// DetectStream detects the format of the stream behind br from the bytes
+// it can peek. An ID3v2 tag too large to look past is taken for MP3.
+func DetectStream(br *bufio.Reader) (CoverFormat, error) {
+	head, err := br.Peek(br.Size())
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if n := id3.HeaderSize(head); n > 0 && n+4096 > len(head) && len(head) == br.Size() {
+		return mp3Format{}, nil
+	}
+	return Detect(head)
+}
+
+// streamPCMFormat is a lossless container that can be rewritten block by
+// block.
+type streamPCMFormat struct {
+	pcmFormat
+	rewrite func(w io.Writer, r io.Reader, edit func(b *pcm.Buffer) error) error
+}
+
+func (f streamPCMFormat) Stream(w io.Writer, r io.Reader, strict bool, visit func([]byte, []bool) error) (*StreamInfo, error) {
+	info := &StreamInfo{}
+	samples := 0
+	err := f.rewrite(w, r, func(b *pcm.Buffer) error {
+		if info.Layout == nil {
+			layout := *b
+			layout.Samples = nil
+			info.Layout = &layout
+		}
+		units := b.LowBytes()
+		if err := visit(units, nil); err != nil {
+			return err
+		}
+		b.SetLowBytes(units)
+		samples += len(b.Samples)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	info.Capacity = fmt.Sprintf("%d samples usable", samples)
+	return info, nil
+}
+
+func (mp3Format) Stream(w io.Writer, r io.Reader, strict bool, visit func([]byte, []bool) error) (*StreamInfo, error) {
+	rd, err := mp3.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	wr, err := mp3.NewWriter(w, rd.ID3v2)
+	if err != nil {
+		return nil, err
+	}
+	scan := mp3.NewEligibilityScanner(strict)
+	info := &StreamInfo{CRCBefore: &mp3.CRCReport{}, CRCAfter: &mp3.CRCReport{}}
+	for i := 0; ; {
+		fr, err := rd.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if rd.TagFrame() {
+			scan.TagFrame(fr)
+		} else {
+			failed := info.CRCBefore.Check(i, fr)
+			mark, _ := scan.Frame(fr)
+			if err := visit(fr.Data, mark); err != nil {
+				return nil, err
+			}
+			// As File.UpdateCRC, frames that failed before are left alone
+			if !strict && !failed && fr.Header.Protection {
+				fr.UpdateCRC()
+			}
+			info.CRCAfter.Check(i, fr)
+			i++
+		}
+		if err := wr.WriteFrame(fr); err != nil {
+			return nil, err
+		}
+	}
+	if err := wr.Close(rd.Tag, rd.ID3v1); err != nil {
+		return nil, err
+	}
+	if wr.Stale {
+		info.Warning = "the output cannot be rewound, the LAME tag CRCs were left as they were"
+	}
+	info.Capacity = scan.Close().String()
+	return info, nil
+}
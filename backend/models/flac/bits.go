@@ -0,0 +1,110 @@
+package flac
+
+import "errors"
+
+var errUnexpectedEnd = errors.New("unexpected end of frame data")
+
+type bitReader struct {
+	b   []byte
+	pos int // bit position
+}
+
+func (r *bitReader) read(n uint) (uint64, error) {
+	if r.pos+int(n) > len(r.b)*8 {
+		return 0, errUnexpectedEnd
+	}
+	var v uint64
+	for n > 0 {
+		off := uint(r.pos & 7)
+		avail := 8 - off
+		take := avail
+		if take > n {
+			take = n
+		}
+		bits := (uint64(r.b[r.pos>>3]) >> (avail - take)) & (1<<take - 1)
+		v = v<<take | bits
+		n -= take
+		r.pos += int(take)
+	}
+	return v, nil
+}
+
+func (r *bitReader) readSigned(n uint) (int64, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	v, err := r.read(n)
+	if err != nil {
+		return 0, err
+	}
+	return int64(v<<(64-n)) >> (64 - n), nil
+}
+
+// readUnary counts zero bits up to the next one bit.
+func (r *bitReader) readUnary() (uint64, error) {
+	var q uint64
+	for {
+		if r.pos>>3 >= len(r.b) {
+			return 0, errUnexpectedEnd
+		}
+		if r.pos&7 == 0 && r.b[r.pos>>3] == 0 {
+			q += 8
+			r.pos += 8
+			continue
+		}
+		bit, err := r.read(1)
+		if err != nil {
+			return 0, err
+		}
+		if bit == 1 {
+			return q, nil
+		}
+		q++
+	}
+}
+
+func (r *bitReader) align() {
+	r.pos = (r.pos + 7) &^ 7
+}
+
+type bitWriter struct {
+	buf []byte
+	acc uint64
+	n   uint // bits pending in acc, always < 8 between calls
+}
+
+func (w *bitWriter) write(v uint64, n uint) {
+	if n > 32 {
+		w.write(v>>32, n-32)
+		n = 32
+	}
+	w.acc = w.acc<<n | v&(1<<n-1)
+	w.n += n
+	for w.n >= 8 {
+		w.buf = append(w.buf, byte(w.acc>>(w.n-8)))
+		w.n -= 8
+	}
+	w.acc &= 1<<w.n - 1
+}
+
+func (w *bitWriter) writeSigned(v int64, n uint) {
+	w.write(uint64(v), n)
+}
+
+func (w *bitWriter) writeUnary(q uint64) {
+	for q >= 32 {
+		w.write(0, 32)
+		q -= 32
+	}
+	w.write(1, uint(q)+1)
+}
+
+func (w *bitWriter) align() {
+	if w.n > 0 {
+		w.write(0, 8-w.n)
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	return w.buf
+}
@@ -0,0 +1,45 @@
+package flac
+
+var (
+	crc8Table  [256]uint8
+	crc16Table [256]uint16
+)
+
+func init() {
+	for i := 0; i < 256; i++ {
+		c8 := uint8(i)
+		c16 := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if c8&0x80 != 0 {
+				c8 = c8<<1 ^ 0x07
+			} else {
+				c8 <<= 1
+			}
+			if c16&0x8000 != 0 {
+				c16 = c16<<1 ^ 0x8005
+			} else {
+				c16 <<= 1
+			}
+		}
+		crc8Table[i] = c8
+		crc16Table[i] = c16
+	}
+}
+
+// crc8 is the frame header checksum (polynomial x^8 + x^2 + x + 1).
+func crc8(b []byte) uint8 {
+	var c uint8
+	for _, v := range b {
+		c = crc8Table[c^v]
+	}
+	return c
+}
+
+// crc16 is the frame footer checksum (polynomial x^16 + x^15 + x^2 + 1).
+func crc16(b []byte) uint16 {
+	var c uint16
+	for _, v := range b {
+		c = c<<8 ^ crc16Table[byte(c>>8)^v]
+	}
+	return c
+}
@@ -0,0 +1,443 @@
+package flac
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/pcm"
+)
+
+const (
+	chanIndependent = 0 // 0..7: channels-1 independent channels
+	chanLeftSide    = 8
+	chanSideRight   = 9
+	chanMidSide     = 10
+)
+
+var sampleRateTable = [12]int{0, 88200, 176400, 192000, 8000, 16000, 22050, 24000, 32000, 44100, 48000, 96000}
+
+var sampleSizeTable = [8]int{0, 8, 12, 0, 16, 20, 24, 32}
+
+// fixedCoeffs are the predictor coefficients of the fixed subframe orders.
+var fixedCoeffs = [5][]int64{
+	{},
+	{1},
+	{2, -1},
+	{3, -3, 1},
+	{4, -6, 4, -1},
+}
+
+type frameHeader struct {
+	blockSize  int
+	sampleRate int
+	channels   int
+	assignment int
+	bps        int
+	variable   bool
+}
+
+func (f *File) decodeFrames(data []byte) error {
+	buf := &pcm.Buffer{
+		SampleRate: f.Info.SampleRate,
+		Channels:   f.Info.Channels,
+		BitDepth:   f.Info.BitsPerSample,
+	}
+	if f.Info.TotalSamples > 0 {
+		buf.Samples = make([]int32, 0, int(f.Info.TotalSamples)*f.Info.Channels)
+	}
+
+	i := 0
+	for i+2 <= len(data) {
+		if data[i] != 0xFF || data[i+1]&0xFE != 0xF8 {
+			// Trailing tags (ID3v1/APE) or garbage after the last frame
+			if len(f.Sizes) > 0 {
+				break
+			}
+			i++
+			continue
+		}
+		n, hdr, chans, err := f.decodeFrame(data[i:])
+		if errors.Is(err, errUnexpectedEnd) && len(f.Sizes) > 0 {
+			// Truncated final frame, keep it with the trailer
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("frame %d: %v", len(f.Sizes), err)
+		}
+		if hdr.channels != f.Info.Channels {
+			return fmt.Errorf("frame %d: channel count changed mid-stream", len(f.Sizes))
+		}
+		f.Variable = hdr.variable
+		f.Sizes = append(f.Sizes, hdr.blockSize)
+		for s := 0; s < hdr.blockSize; s++ {
+			for c := range chans {
+				buf.Samples = append(buf.Samples, int32(chans[c][s]))
+			}
+		}
+		i += n
+	}
+
+	if len(f.Sizes) == 0 {
+		return errors.New("no frames")
+	}
+	f.Trailer = append([]byte(nil), data[i:]...)
+	f.PCM = buf
+	return nil
+}
+
+func (f *File) parseFrameHeader(r *bitReader) (frameHeader, error) {
+	var h frameHeader
+	sync, err := r.read(15)
+	if err != nil {
+		return h, err
+	}
+	if sync != 0x7FFC {
+		return h, errors.New("bad frame sync")
+	}
+	strategy, _ := r.read(1)
+	h.variable = strategy == 1
+
+	bsCode, _ := r.read(4)
+	srCode, _ := r.read(4)
+	chCode, _ := r.read(4)
+	ssCode, _ := r.read(3)
+	if _, err := r.read(1); err != nil {
+		return h, err
+	}
+
+	// UTF-8 style coded frame or sample number, only skipped
+	lead, err := r.read(8)
+	if err != nil {
+		return h, err
+	}
+	extra := 0
+	for m := uint64(0x80); m > 0 && lead&m != 0; m >>= 1 {
+		extra++
+	}
+	if extra == 1 || extra > 7 {
+		return h, errors.New("invalid coded frame number")
+	}
+	if extra > 0 {
+		if _, err := r.read(uint(8 * (extra - 1))); err != nil {
+			return h, err
+		}
+	}
+
+	switch {
+	case bsCode == 0:
+		return h, errors.New("reserved block size")
+	case bsCode == 1:
+		h.blockSize = 192
+	case bsCode <= 5:
+		h.blockSize = 576 << (bsCode - 2)
+	case bsCode == 6:
+		v, err := r.read(8)
+		if err != nil {
+			return h, err
+		}
+		h.blockSize = int(v) + 1
+	case bsCode == 7:
+		v, err := r.read(16)
+		if err != nil {
+			return h, err
+		}
+		h.blockSize = int(v) + 1
+	default:
+		h.blockSize = 256 << (bsCode - 8)
+	}
+
+	switch {
+	case srCode == 0:
+		h.sampleRate = f.Info.SampleRate
+	case srCode < 12:
+		h.sampleRate = sampleRateTable[srCode]
+	case srCode == 12:
+		v, err := r.read(8)
+		if err != nil {
+			return h, err
+		}
+		h.sampleRate = int(v) * 1000
+	case srCode == 13:
+		v, err := r.read(16)
+		if err != nil {
+			return h, err
+		}
+		h.sampleRate = int(v)
+	case srCode == 14:
+		v, err := r.read(16)
+		if err != nil {
+			return h, err
+		}
+		h.sampleRate = int(v) * 10
+	default:
+		return h, errors.New("invalid sample rate code")
+	}
+
+	h.assignment = int(chCode)
+	switch {
+	case chCode < 8:
+		h.channels = int(chCode) + 1
+	case chCode <= chanMidSide:
+		h.channels = 2
+	default:
+		return h, errors.New("reserved channel assignment")
+	}
+
+	if ssCode == 0 {
+		h.bps = f.Info.BitsPerSample
+	} else {
+		h.bps = sampleSizeTable[ssCode]
+		if h.bps == 0 {
+			return h, errors.New("reserved sample size")
+		}
+	}
+
+	end := r.pos / 8
+	crc, err := r.read(8)
+	if err != nil {
+		return h, err
+	}
+	if uint8(crc) != crc8(r.b[:end]) {
+		return h, errors.New("frame header CRC-8 mismatch")
+	}
+	return h, nil
+}
+
+// decodeFrame decodes a single frame and returns its length in bytes.
+func (f *File) decodeFrame(data []byte) (int, frameHeader, [][]int64, error) {
+	r := &bitReader{b: data}
+	h, err := f.parseFrameHeader(r)
+	if err != nil {
+		return 0, h, nil, err
+	}
+
+	chans := make([][]int64, h.channels)
+	for c := range chans {
+		bps := h.bps
+		if (h.assignment == chanLeftSide && c == 1) ||
+			(h.assignment == chanSideRight && c == 0) ||
+			(h.assignment == chanMidSide && c == 1) {
+			bps++ // side channel carries one extra bit
+		}
+		chans[c], err = decodeSubframe(r, h.blockSize, bps)
+		if err != nil {
+			return 0, h, nil, fmt.Errorf("subframe %d: %w", c, err)
+		}
+	}
+
+	switch h.assignment {
+	case chanLeftSide:
+		for s := range chans[0] {
+			chans[1][s] = chans[0][s] - chans[1][s]
+		}
+	case chanSideRight:
+		for s := range chans[0] {
+			chans[0][s] += chans[1][s]
+		}
+	case chanMidSide:
+		for s := range chans[0] {
+			side := chans[1][s]
+			mid := chans[0][s]<<1 | side&1
+			chans[0][s] = (mid + side) >> 1
+			chans[1][s] = (mid - side) >> 1
+		}
+	}
+
+	r.align()
+	end := r.pos / 8
+	crc, err := r.read(16)
+	if err != nil {
+		return 0, h, nil, err
+	}
+	if uint16(crc) != crc16(data[:end]) {
+		return 0, h, nil, errors.New("frame CRC-16 mismatch")
+	}
+	return end + 2, h, chans, nil
+}
+
+func decodeSubframe(r *bitReader, n, bps int) ([]int64, error) {
+	hdr, err := r.read(8)
+	if err != nil {
+		return nil, err
+	}
+	if hdr&0x80 != 0 {
+		return nil, errors.New("subframe padding bit set")
+	}
+	typ := int(hdr>>1) & 0x3F
+
+	wasted := 0
+	if hdr&1 != 0 {
+		k, err := r.readUnary()
+		if err != nil {
+			return nil, err
+		}
+		wasted = int(k) + 1
+		bps -= wasted
+	}
+
+	out := make([]int64, n)
+	switch {
+	case typ == 0:
+		v, err := r.readSigned(uint(bps))
+		if err != nil {
+			return nil, err
+		}
+		for s := range out {
+			out[s] = v
+		}
+	case typ == 1:
+		for s := range out {
+			if out[s], err = r.readSigned(uint(bps)); err != nil {
+				return nil, err
+			}
+		}
+	case typ >= 8 && typ <= 12:
+		order := typ - 8
+		if err := decodePredicted(r, out, bps, fixedCoeffs[order], 0); err != nil {
+			return nil, err
+		}
+	case typ >= 32:
+		order := typ - 31
+		if err := decodeLPC(r, out, bps, order); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("reserved subframe type %d", typ)
+	}
+
+	if wasted > 0 {
+		for s := range out {
+			out[s] <<= uint(wasted)
+		}
+	}
+	return out, nil
+}
+
+func decodeLPC(r *bitReader, out []int64, bps, order int) error {
+	if order > len(out) {
+		return errors.New("LPC order exceeds block size")
+	}
+	warm := make([]int64, order)
+	for k := range warm {
+		v, err := r.readSigned(uint(bps))
+		if err != nil {
+			return err
+		}
+		warm[k] = v
+	}
+	p, err := r.read(4)
+	if err != nil {
+		return err
+	}
+	if p == 15 {
+		return errors.New("invalid LPC precision")
+	}
+	prec := uint(p) + 1
+	shift, err := r.readSigned(5)
+	if err != nil {
+		return err
+	}
+	if shift < 0 {
+		return errors.New("negative LPC shift")
+	}
+	coeffs := make([]int64, order)
+	for k := range coeffs {
+		if coeffs[k], err = r.readSigned(prec); err != nil {
+			return err
+		}
+	}
+	copy(out, warm)
+	return restore(r, out, order, coeffs, uint(shift))
+}
+
+func decodePredicted(r *bitReader, out []int64, bps int, coeffs []int64, shift uint) error {
+	order := len(coeffs)
+	if order > len(out) {
+		return errors.New("predictor order exceeds block size")
+	}
+	for k := 0; k < order; k++ {
+		v, err := r.readSigned(uint(bps))
+		if err != nil {
+			return err
+		}
+		out[k] = v
+	}
+	return restore(r, out, order, coeffs, shift)
+}
+
+// restore decodes the residual into out[order:] and adds the prediction.
+func restore(r *bitReader, out []int64, order int, coeffs []int64, shift uint) error {
+	if err := decodeResidual(r, out, order); err != nil {
+		return err
+	}
+	for s := order; s < len(out); s++ {
+		var sum int64
+		for k, c := range coeffs {
+			sum += c * out[s-1-k]
+		}
+		out[s] += sum >> shift
+	}
+	return nil
+}
+
+// decodeResidual reads a partitioned Rice coded residual into out[order:].
+func decodeResidual(r *bitReader, out []int64, order int) error {
+	method, err := r.read(2)
+	if err != nil {
+		return err
+	}
+	if method > 1 {
+		return errors.New("reserved residual coding method")
+	}
+	paramBits := uint(4 + method)
+	escape := uint64(1)<<paramBits - 1
+
+	po, err := r.read(4)
+	if err != nil {
+		return err
+	}
+	parts := 1 << po
+	n := len(out)
+	if n%parts != 0 || n>>po < order {
+		return errors.New("invalid partition order")
+	}
+
+	s := order
+	for p := 0; p < parts; p++ {
+		count := n >> po
+		if p == 0 {
+			count -= order
+		}
+		k, err := r.read(paramBits)
+		if err != nil {
+			return err
+		}
+		if k == escape {
+			bits, err := r.read(5)
+			if err != nil {
+				return err
+			}
+			for j := 0; j < count; j++ {
+				if out[s], err = r.readSigned(uint(bits)); err != nil {
+					return err
+				}
+				s++
+			}
+			continue
+		}
+		for j := 0; j < count; j++ {
+			q, err := r.readUnary()
+			if err != nil {
+				return err
+			}
+			lo, err := r.read(uint(k))
+			if err != nil {
+				return err
+			}
+			u := q<<k | lo
+			out[s] = int64(u>>1) ^ -int64(u&1)
+			s++
+		}
+	}
+	return nil
+}
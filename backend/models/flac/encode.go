@@ -0,0 +1,347 @@
+package flac
+
+import "math/bits"
+
+const maxPartitionOrder = 8
+
+type subframe struct {
+	kind      int // 0 constant, 1 verbatim, 8..12 fixed order 0..4
+	residual  []uint64
+	partOrder uint
+	params    []uint
+	bits      int
+}
+
+// encodeFrames re-encodes the PCM buffer with the original frame sizes and
+// returns the frame bytes plus the offset of every frame (and the end).
+func (f *File) encodeFrames() ([]byte, []int) {
+	var out []byte
+	offsets := make([]int, 0, len(f.Sizes)+1)
+
+	ch := f.PCM.Channels
+	pos := 0
+	for k, n := range f.Sizes {
+		offsets = append(offsets, len(out))
+		if pos+n > f.PCM.Frames() {
+			n = f.PCM.Frames() - pos
+			f.Sizes[k] = n
+		}
+
+		chans := make([][]int64, ch)
+		for c := range chans {
+			chans[c] = make([]int64, n)
+			for s := 0; s < n; s++ {
+				chans[c][s] = int64(f.PCM.Samples[(pos+s)*ch+c])
+			}
+		}
+
+		number := uint64(k)
+		if f.Variable {
+			number = uint64(pos)
+		}
+		out = append(out, f.encodeFrame(chans, number)...)
+		pos += n
+	}
+	offsets = append(offsets, len(out))
+	return out, offsets
+}
+
+func (f *File) encodeFrame(chans [][]int64, number uint64) []byte {
+	bps := f.Info.BitsPerSample
+	n := len(chans[0])
+
+	assignment := len(chans) - 1
+	plans := make([]subframe, len(chans))
+	for c := range chans {
+		plans[c] = planSubframe(chans[c], bps)
+	}
+
+	// Try stereo decorrelation and keep whichever costs the fewest bits
+	if len(chans) == 2 {
+		left, right := chans[0], chans[1]
+		side := make([]int64, n)
+		mid := make([]int64, n)
+		for s := 0; s < n; s++ {
+			side[s] = left[s] - right[s]
+			mid[s] = (left[s] + right[s]) >> 1
+		}
+		sp := planSubframe(side, bps+1)
+		mp := planSubframe(mid, bps)
+
+		best := plans[0].bits + plans[1].bits
+		if c := plans[0].bits + sp.bits; c < best {
+			best, assignment = c, chanLeftSide
+		}
+		if c := sp.bits + plans[1].bits; c < best {
+			best, assignment = c, chanSideRight
+		}
+		if c := mp.bits + sp.bits; c < best {
+			assignment = chanMidSide
+		}
+
+		switch assignment {
+		case chanLeftSide:
+			chans = [][]int64{left, side}
+			plans = []subframe{plans[0], sp}
+		case chanSideRight:
+			chans = [][]int64{side, right}
+			plans = []subframe{sp, plans[1]}
+		case chanMidSide:
+			chans = [][]int64{mid, side}
+			plans = []subframe{mp, sp}
+		}
+	}
+
+	w := &bitWriter{}
+	f.writeFrameHeader(w, n, assignment, number)
+
+	for c := range chans {
+		cb := bps
+		if (assignment == chanLeftSide && c == 1) ||
+			(assignment == chanSideRight && c == 0) ||
+			(assignment == chanMidSide && c == 1) {
+			cb++
+		}
+		plans[c].write(w, chans[c], cb)
+	}
+
+	w.align()
+	crc := crc16(w.bytes())
+	w.write(uint64(crc), 16)
+	return w.bytes()
+}
+
+func (f *File) writeFrameHeader(w *bitWriter, n, assignment int, number uint64) {
+	w.write(0x3FFE, 14)
+	w.write(0, 1)
+	if f.Variable {
+		w.write(1, 1)
+	} else {
+		w.write(0, 1)
+	}
+
+	bsCode := blockSizeCode(n)
+	w.write(bsCode, 4)
+
+	var srCode uint64
+	for k := 1; k < len(sampleRateTable); k++ {
+		if sampleRateTable[k] == f.Info.SampleRate {
+			srCode = uint64(k)
+			break
+		}
+	}
+	w.write(srCode, 4)
+	w.write(uint64(assignment), 4)
+
+	var ssCode uint64
+	for k := 1; k < len(sampleSizeTable); k++ {
+		if sampleSizeTable[k] == f.Info.BitsPerSample {
+			ssCode = uint64(k)
+			break
+		}
+	}
+	w.write(ssCode, 3)
+	w.write(0, 1)
+
+	writeCodedNumber(w, number)
+	switch bsCode {
+	case 6:
+		w.write(uint64(n-1), 8)
+	case 7:
+		w.write(uint64(n-1), 16)
+	}
+
+	w.write(uint64(crc8(w.bytes())), 8)
+}
+
+func blockSizeCode(n int) uint64 {
+	switch n {
+	case 192:
+		return 1
+	case 576, 1152, 2304, 4608:
+		return uint64(2 + bits.TrailingZeros(uint(n/576)))
+	case 256, 512, 1024, 2048, 4096, 8192, 16384, 32768:
+		return uint64(8 + bits.TrailingZeros(uint(n/256)))
+	}
+	if n <= 256 {
+		return 6
+	}
+	return 7
+}
+
+// writeCodedNumber writes v using the UTF-8 like variable length coding of
+// frame and sample numbers.
+func writeCodedNumber(w *bitWriter, v uint64) {
+	if v < 0x80 {
+		w.write(v, 8)
+		return
+	}
+	n := 2
+	for v >= 1<<uint(5*n+1) {
+		n++
+	}
+	lead := uint64(0xFF00>>uint(n)) & 0xFF
+	w.write(lead|v>>uint(6*(n-1)), 8)
+	for k := n - 2; k >= 0; k-- {
+		w.write(0x80|(v>>uint(6*k))&0x3F, 8)
+	}
+}
+
+// planSubframe picks the cheapest of constant, verbatim and fixed order
+// 0..4 prediction for one channel of a block.
+func planSubframe(x []int64, bps int) subframe {
+	n := len(x)
+
+	constant := true
+	for _, v := range x[1:] {
+		if v != x[0] {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		return subframe{kind: 0, bits: 8 + bps}
+	}
+
+	best := subframe{kind: 1, bits: 8 + n*bps}
+	limit := int64(1) << 30
+	for order := 0; order <= 4 && order < n; order++ {
+		res := make([]uint64, n-order)
+		ok := true
+		for s := order; s < n; s++ {
+			var pred int64
+			for k, c := range fixedCoeffs[order] {
+				pred += c * x[s-1-k]
+			}
+			r := x[s] - pred
+			if r >= limit || r <= -limit {
+				ok = false
+				break
+			}
+			res[s-order] = uint64(r<<1) ^ uint64(r>>63)
+		}
+		if !ok {
+			continue
+		}
+		po, params, rb := planRice(res, n, order)
+		cost := 8 + order*bps + rb
+		if cost < best.bits {
+			best = subframe{kind: 8 + order, residual: res, partOrder: po, params: params, bits: cost}
+		}
+	}
+	return best
+}
+
+// planRice chooses the partition order and Rice parameters for a zigzag
+// coded residual. The returned size includes the coding method and
+// partition order fields.
+func planRice(u []uint64, n, order int) (uint, []uint, int) {
+	bestBits := -1
+	var bestPO uint
+	var bestParams []uint
+
+	for po := uint(0); po <= maxPartitionOrder; po++ {
+		parts := 1 << po
+		if n%parts != 0 || (po > 0 && n>>po <= order) {
+			break
+		}
+		params := make([]uint, parts)
+		total := 2 + 4
+		wide := false
+		s := 0
+		for p := 0; p < parts; p++ {
+			count := n >> po
+			if p == 0 {
+				count -= order
+			}
+			seg := u[s : s+count]
+			s += count
+
+			var sum uint64
+			for _, v := range seg {
+				sum += v
+			}
+			k0 := uint(0)
+			if count > 0 && sum > uint64(count) {
+				k0 = uint(bits.Len64(sum/uint64(count))) - 1
+			}
+			bk, bc := uint(0), -1
+			for k := k0; k <= k0+1 && k <= 30; k++ {
+				c := count * int(k+1)
+				for _, v := range seg {
+					c += int(v >> k)
+				}
+				if bc < 0 || c < bc {
+					bk, bc = k, c
+				}
+			}
+			if k0 > 0 {
+				c := count * int(k0)
+				for _, v := range seg {
+					c += int(v >> (k0 - 1))
+				}
+				if c < bc {
+					bk, bc = k0-1, c
+				}
+			}
+			params[p] = bk
+			if bk > 14 {
+				wide = true
+			}
+			total += bc
+		}
+		if wide {
+			total += parts * 5
+		} else {
+			total += parts * 4
+		}
+		if bestBits < 0 || total < bestBits {
+			bestBits, bestPO, bestParams = total, po, params
+		}
+	}
+	return bestPO, bestParams, bestBits
+}
+
+func (sf subframe) write(w *bitWriter, x []int64, bps int) {
+	w.write(0, 1)
+	w.write(uint64(sf.kind), 6)
+	w.write(0, 1) // no wasted bits
+
+	switch {
+	case sf.kind == 0:
+		w.writeSigned(x[0], uint(bps))
+	case sf.kind == 1:
+		for _, v := range x {
+			w.writeSigned(v, uint(bps))
+		}
+	default:
+		order := sf.kind - 8
+		for s := 0; s < order; s++ {
+			w.writeSigned(x[s], uint(bps))
+		}
+
+		paramBits := uint(4)
+		for _, k := range sf.params {
+			if k > 14 {
+				paramBits = 5
+			}
+		}
+		w.write(uint64(paramBits-4), 2)
+		w.write(uint64(sf.partOrder), 4)
+
+		n := len(x)
+		s := 0
+		for p, k := range sf.params {
+			count := n >> sf.partOrder
+			if p == 0 {
+				count -= order
+			}
+			w.write(uint64(k), paramBits)
+			for _, v := range sf.residual[s : s+count] {
+				w.writeUnary(v >> k)
+				w.write(v, k)
+			}
+			s += count
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package flac
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/pcm"
+)
+
+const (
+	BlockStreamInfo    = 0
+	BlockPadding       = 1
+	BlockApplication   = 2
+	BlockSeekTable     = 3
+	BlockVorbisComment = 4
+	BlockCueSheet      = 5
+	BlockPicture       = 6
+)
+
+type StreamInfo struct {
+	MinBlockSize  int
+	MaxBlockSize  int
+	MinFrameSize  int
+	MaxFrameSize  int
+	SampleRate    int
+	Channels      int
+	BitsPerSample int
+	TotalSamples  uint64
+	MD5           [16]byte
+}
+
+type MetadataBlock struct {
+	Type uint8
+	Data []byte
+}
+
+type File struct {
+	ID3v2    []byte // ID3v2 tag found before the fLaC marker, if any
+	Info     StreamInfo
+	Blocks   []*MetadataBlock // all metadata blocks in order, kept byte-for-byte except STREAMINFO and SEEKTABLE
+	PCM      *pcm.Buffer
+	Variable bool   // variable blocking strategy
+	Sizes    []int  // block size of every frame, reused when re-encoding
+	Trailer  []byte // bytes after the last frame (ID3v1/APE tags), kept as-is
+}
+
+// IsFLAC reports whether data starts with a fLaC stream marker, optionally
+// preceded by an ID3v2 tag.
+func IsFLAC(data []byte) bool {
+	return bytes.HasPrefix(data[skipID3v2(data):], []byte("fLaC"))
+}
+
+func skipID3v2(data []byte) int {
+	if len(data) < 10 || !bytes.Equal(data[0:3], []byte("ID3")) {
+		return 0
+	}
+	size := 0
+	for j := 0; j < 4; j++ {
+		size = (size << 7) | int(data[6+j]&0x7F)
+	}
+	if data[5]&0x10 != 0 {
+		size += 10 // footer
+	}
+	if 10+size > len(data) {
+		return 0
+	}
+	return 10 + size
+}
+
+func Parse(data []byte) (*File, error) {
+	f := &File{}
+	i := skipID3v2(data)
+	if i > 0 {
+		f.ID3v2 = append([]byte(nil), data[:i]...)
+	}
+	if !bytes.HasPrefix(data[i:], []byte("fLaC")) {
+		return nil, errors.New("missing fLaC marker")
+	}
+	i += 4
+
+	// Metadata blocks
+	for {
+		if i+4 > len(data) {
+			return nil, errors.New("truncated metadata block header")
+		}
+		last := data[i]&0x80 != 0
+		typ := data[i] & 0x7F
+		size := int(data[i+1])<<16 | int(data[i+2])<<8 | int(data[i+3])
+		i += 4
+		if i+size > len(data) {
+			return nil, errors.New("truncated metadata block")
+		}
+		f.Blocks = append(f.Blocks, &MetadataBlock{Type: typ, Data: append([]byte(nil), data[i:i+size]...)})
+		i += size
+		if last {
+			break
+		}
+	}
+
+	if len(f.Blocks) == 0 || f.Blocks[0].Type != BlockStreamInfo {
+		return nil, errors.New("first metadata block is not STREAMINFO")
+	}
+	if err := f.parseStreamInfo(f.Blocks[0].Data); err != nil {
+		return nil, err
+	}
+
+	if err := f.decodeFrames(data[i:]); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) parseStreamInfo(b []byte) error {
+	if len(b) < 34 {
+		return errors.New("STREAMINFO too short")
+	}
+	f.Info = StreamInfo{
+		MinBlockSize: int(binary.BigEndian.Uint16(b[0:2])),
+		MaxBlockSize: int(binary.BigEndian.Uint16(b[2:4])),
+		MinFrameSize: int(b[4])<<16 | int(b[5])<<8 | int(b[6]),
+		MaxFrameSize: int(b[7])<<16 | int(b[8])<<8 | int(b[9]),
+	}
+	x := binary.BigEndian.Uint64(b[10:18])
+	f.Info.SampleRate = int(x >> 44)
+	f.Info.Channels = int(x>>41&0x07) + 1
+	f.Info.BitsPerSample = int(x>>36&0x1F) + 1
+	f.Info.TotalSamples = x & (1<<36 - 1)
+	copy(f.Info.MD5[:], b[18:34])
+
+	if f.Info.SampleRate == 0 {
+		return errors.New("invalid sample rate in STREAMINFO")
+	}
+	if f.Info.BitsPerSample < 4 || f.Info.BitsPerSample > 32 {
+		return fmt.Errorf("unsupported bits per sample: %d", f.Info.BitsPerSample)
+	}
+	return nil
+}
+
+func (f *File) packStreamInfo() []byte {
+	b := make([]byte, 34)
+	binary.BigEndian.PutUint16(b[0:2], uint16(f.Info.MinBlockSize))
+	binary.BigEndian.PutUint16(b[2:4], uint16(f.Info.MaxBlockSize))
+	b[4], b[5], b[6] = byte(f.Info.MinFrameSize>>16), byte(f.Info.MinFrameSize>>8), byte(f.Info.MinFrameSize)
+	b[7], b[8], b[9] = byte(f.Info.MaxFrameSize>>16), byte(f.Info.MaxFrameSize>>8), byte(f.Info.MaxFrameSize)
+	x := uint64(f.Info.SampleRate)<<44 |
+		uint64(f.Info.Channels-1)<<41 |
+		uint64(f.Info.BitsPerSample-1)<<36 |
+		f.Info.TotalSamples&(1<<36-1)
+	binary.BigEndian.PutUint64(b[10:18], x)
+	copy(b[18:34], f.Info.MD5[:])
+	return b
+}
+
+// sumMD5 computes the STREAMINFO signature over the interleaved samples,
+// each stored little-endian in the smallest whole number of bytes.
+func sumMD5(buf *pcm.Buffer) [16]byte {
+	width := (buf.BitDepth + 7) / 8
+	h := md5.New()
+	chunk := make([]byte, 0, 4096*width)
+	for _, s := range buf.Samples {
+		for k := 0; k < width; k++ {
+			chunk = append(chunk, byte(s>>(8*k)))
+		}
+		if len(chunk) >= 4096*width {
+			h.Write(chunk)
+			chunk = chunk[:0]
+		}
+	}
+	h.Write(chunk)
+	var sum [16]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// Serialize re-encodes the samples and writes the stream back out. Metadata
+// blocks are copied unchanged apart from STREAMINFO (frame sizes and MD5)
+// and SEEKTABLE (byte offsets), which depend on the new frames.
+func Serialize(f *File) []byte {
+	frames, offsets := f.encodeFrames()
+
+	f.Info.MinFrameSize, f.Info.MaxFrameSize = 0, 0
+	for k := range f.Sizes {
+		n := offsets[k+1] - offsets[k]
+		if f.Info.MinFrameSize == 0 || n < f.Info.MinFrameSize {
+			f.Info.MinFrameSize = n
+		}
+		if n > f.Info.MaxFrameSize {
+			f.Info.MaxFrameSize = n
+		}
+	}
+	f.Info.TotalSamples = uint64(f.PCM.Frames())
+	f.Info.MD5 = sumMD5(f.PCM)
+
+	var out []byte
+	out = append(out, f.ID3v2...)
+	out = append(out, []byte("fLaC")...)
+	for k, blk := range f.Blocks {
+		data := blk.Data
+		switch blk.Type {
+		case BlockStreamInfo:
+			data = f.packStreamInfo()
+		case BlockSeekTable:
+			data = f.rebuildSeekTable(data, offsets)
+		}
+		hdr := blk.Type
+		if k == len(f.Blocks)-1 {
+			hdr |= 0x80
+		}
+		out = append(out, hdr, byte(len(data)>>16), byte(len(data)>>8), byte(len(data)))
+		out = append(out, data...)
+	}
+	out = append(out, frames...)
+	return append(out, f.Trailer...)
+}
+
+// rebuildSeekTable points every seek point at the frame that now holds its
+// target sample. Placeholder points are left as they are.
+func (f *File) rebuildSeekTable(b []byte, offsets []int) []byte {
+	out := append([]byte(nil), b...)
+	starts := make([]uint64, len(f.Sizes))
+	var s uint64
+	for k, n := range f.Sizes {
+		starts[k] = s
+		s += uint64(n)
+	}
+
+	for p := 0; p+18 <= len(out); p += 18 {
+		target := binary.BigEndian.Uint64(out[p : p+8])
+		if target == 0xFFFFFFFFFFFFFFFF || len(starts) == 0 {
+			continue
+		}
+		k := 0
+		for k+1 < len(starts) && starts[k+1] <= target {
+			k++
+		}
+		binary.BigEndian.PutUint64(out[p:p+8], starts[k])
+		binary.BigEndian.PutUint64(out[p+8:p+16], uint64(offsets[k]))
+		binary.BigEndian.PutUint16(out[p+16:p+18], uint16(f.Sizes[k]))
+	}
+	return out
+}
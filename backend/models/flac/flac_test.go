@@ -0,0 +1,154 @@
+package flac
+
+import (
+	"bytes"
+	"crypto/md5"
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// fixture returns a FLAC stream of a tone with some noise, its frames made
+// of verbatim subframes so that decoding it does not depend on the
+// encoder's choice of predictors, and the samples it holds.
+func fixture(t *testing.T, channels, bps, frames int) ([]byte, []int32) {
+	t.Helper()
+	rng := rand.New(rand.NewSource(int64(channels*100 + bps)))
+	peak := float64(int64(1)<<uint(bps-1) - 1)
+	samples := make([]int32, frames*channels)
+	for s := 0; s < frames; s++ {
+		for c := 0; c < channels; c++ {
+			x := 0.6*math.Sin(2*math.Pi*float64(440+110*c)*float64(s)/44100) + 0.05*(rng.Float64()*2-1)
+			samples[s*channels+c] = int32(math.Round(x * peak))
+		}
+	}
+
+	f := &File{
+		Info: StreamInfo{
+			MinBlockSize:  4096,
+			MaxBlockSize:  4096,
+			SampleRate:    44100,
+			Channels:      channels,
+			BitsPerSample: bps,
+			TotalSamples:  uint64(frames),
+			MD5:           wantMD5(samples, bps),
+		},
+	}
+	var out []byte
+	for k, pos := 0, 0; pos < frames; k++ {
+		n := min(4096, frames-pos)
+		w := &bitWriter{}
+		f.writeFrameHeader(w, n, channels-1, uint64(k))
+		for c := 0; c < channels; c++ {
+			w.write(1<<1, 8) // verbatim, no wasted bits
+			for s := pos; s < pos+n; s++ {
+				w.writeSigned(int64(samples[s*channels+c]), uint(bps))
+			}
+		}
+		w.align()
+		w.write(uint64(crc16(w.bytes())), 16)
+		out = append(out, w.bytes()...)
+		pos += n
+	}
+
+	info := f.packStreamInfo()
+	comment := []byte("\x09\x00\x00\x00flac_test\x00\x00\x00\x00")
+	head := []byte("fLaC")
+	head = append(head, BlockStreamInfo, 0, 0, byte(len(info)))
+	head = append(head, info...)
+	head = append(head, 0x80|BlockVorbisComment, 0, 0, byte(len(comment)))
+	head = append(head, comment...)
+	return append(head, out...), samples
+}
+
+// wantMD5 is the STREAMINFO signature of the samples, worked out here
+// rather than with sumMD5.
+func wantMD5(samples []int32, bps int) [16]byte {
+	var b []byte
+	for _, s := range samples {
+		for k := 0; k < (bps+7)/8; k++ {
+			b = append(b, byte(s>>(8*k)))
+		}
+	}
+	return md5.Sum(b)
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name          string
+		channels, bps int
+		frames        int
+	}{
+		{"16-bit stereo", 2, 16, 10000},
+		{"24-bit mono", 1, 24, 9000},
+		{"8-bit stereo", 2, 8, 5000},
+		{"12-bit 3 channels", 3, 12, 4096},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, samples := fixture(t, tt.channels, tt.bps, tt.frames)
+
+			f, err := Parse(data)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if !slices.Equal(f.PCM.Samples, samples) {
+				t.Fatal("decoded samples differ from the fixture")
+			}
+			if f.Info.MD5 != wantMD5(f.PCM.Samples, tt.bps) {
+				t.Fatal("fixture MD5 does not match its samples")
+			}
+
+			out := Serialize(f)
+			if len(out) >= len(data) {
+				t.Errorf("re-encoded stream is %d bytes, the verbatim fixture %d", len(out), len(data))
+			}
+			g, err := Parse(out)
+			if err != nil {
+				t.Fatalf("decode of re-encoded stream: %v", err)
+			}
+			if !slices.Equal(g.PCM.Samples, samples) {
+				t.Fatal("re-encoded samples differ from the fixture")
+			}
+			if g.Info.MD5 != wantMD5(samples, tt.bps) {
+				t.Fatal("STREAMINFO MD5 of the re-encoded stream does not match its samples")
+			}
+			if !slices.Equal(g.Sizes, f.Sizes) {
+				t.Errorf("block sizes changed from %v to %v", f.Sizes, g.Sizes)
+			}
+			if len(g.Blocks) != 2 || !bytes.Equal(g.Blocks[1].Data, f.Blocks[1].Data) {
+				t.Error("VORBIS_COMMENT block was not kept")
+			}
+			if again := Serialize(g); !bytes.Equal(again, out) {
+				t.Error("re-encoding the re-encoded stream changed it")
+			}
+		})
+	}
+}
+
+func TestRoundTripChangedSamples(t *testing.T) {
+	data, _ := fixture(t, 2, 16, 6000)
+	f, err := Parse(data)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	// Flip the lowest bit of every third sample the way embedding does
+	lo := f.PCM.LowBytes()
+	for i := 0; i < len(lo); i += 3 {
+		lo[i] ^= 1
+	}
+	f.PCM.SetLowBytes(lo)
+	changed := slices.Clone(f.PCM.Samples)
+
+	g, err := Parse(Serialize(f))
+	if err != nil {
+		t.Fatalf("decode of re-encoded stream: %v", err)
+	}
+	if !slices.Equal(g.PCM.Samples, changed) {
+		t.Fatal("re-encoded samples differ from the changed ones")
+	}
+	if g.Info.MD5 != wantMD5(changed, 16) {
+		t.Fatal("STREAMINFO MD5 was not updated for the changed samples")
+	}
+}
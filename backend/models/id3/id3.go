@@ -0,0 +1,272 @@
+package id3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Header flags.
+const (
+	FlagUnsync       = 0x80
+	FlagExtended     = 0x40 // compression in ID3v2.2
+	FlagExperimental = 0x20
+	FlagFooter       = 0x10 // ID3v2.4 only
+)
+
+// ID3v2.4 frame format flags.
+const (
+	frameUnsync    = 0x0002
+	frameDataLen   = 0x0001
+	frameGrouping  = 0x0040
+	frameCompress  = 0x0008
+	frameEncrypted = 0x0004
+)
+
+// Tag is an ID3v2.2, 2.3 or 2.4 tag. Frame data is stored without
+// unsynchronisation; Serialize applies it again when the flags ask for it.
+type Tag struct {
+	Major    byte // 2, 3 or 4
+	Revision byte
+	Flags    byte
+	Extended []byte // extended header, size field included
+	Frames   []*Frame
+	Padding  []byte // bytes after the last frame, normally zeros
+}
+
+// Frame is one ID3v2 frame. ID has three characters in ID3v2.2.
+type Frame struct {
+	ID    string
+	Flags uint16 // status and format flags, always 0 in ID3v2.2
+	Data  []byte
+}
+
+// IsID3 reports whether data starts with an ID3v2 tag.
+func IsID3(data []byte) bool {
+	return len(data) >= 10 && bytes.Equal(data[0:3], []byte("ID3")) && data[3] >= 2 && data[3] <= 4
+}
+
+// Size returns the length of the ID3v2 tag at the start of data, header and
+// footer included, or 0 if there is none.
+func Size(data []byte) int {
+	n := HeaderSize(data)
+	if n > len(data) {
+		return 0
+	}
+	return n
+}
+
+// HeaderSize returns the length the ID3v2 tag at the start of data claims
+// in its header, or 0 if there is none. Only the 10 header bytes are needed.
+func HeaderSize(data []byte) int {
+	if !IsID3(data) {
+		return 0
+	}
+	n := 10 + synchsafe(data[6:10])
+	if data[3] == 4 && data[5]&FlagFooter != 0 {
+		n += 10
+	}
+	return n
+}
+
+// New returns an empty tag of the given major version.
+func New(major byte) *Tag {
+	return &Tag{Major: major}
+}
+
+// Parse decodes the ID3v2 tag at the start of data.
+func Parse(data []byte) (*Tag, error) {
+	n := Size(data)
+	if n == 0 {
+		return nil, errors.New("no ID3v2 tag")
+	}
+	t := &Tag{Major: data[3], Revision: data[4], Flags: data[5]}
+	body := data[10 : 10+synchsafe(data[6:10])]
+
+	// Before ID3v2.4 unsynchronisation covers the whole tag body
+	if t.Flags&FlagUnsync != 0 && t.Major < 4 {
+		body = Resync(body)
+	}
+
+	if t.Flags&FlagExtended != 0 {
+		switch t.Major {
+		case 2:
+			return nil, errors.New("compressed ID3v2.2 tags are not supported")
+		case 3:
+			if len(body) < 4 {
+				return nil, errors.New("truncated extended header")
+			}
+			size := 4 + int(binary.BigEndian.Uint32(body[0:4]))
+			if size > len(body) {
+				return nil, errors.New("truncated extended header")
+			}
+			t.Extended = append([]byte(nil), body[:size]...)
+		case 4:
+			if len(body) < 4 {
+				return nil, errors.New("truncated extended header")
+			}
+			size := synchsafe(body[0:4])
+			if size < 6 || size > len(body) {
+				return nil, errors.New("invalid extended header size")
+			}
+			t.Extended = append([]byte(nil), body[:size]...)
+		}
+		body = body[len(t.Extended):]
+	}
+
+	head := 10
+	if t.Major == 2 {
+		head = 6
+	}
+	i := 0
+	for i+head <= len(body) && body[i] != 0 {
+		fr := &Frame{}
+		var size int
+		switch t.Major {
+		case 2:
+			fr.ID = string(body[i : i+3])
+			size = int(body[i+3])<<16 | int(body[i+4])<<8 | int(body[i+5])
+		case 3:
+			fr.ID = string(body[i : i+4])
+			size = int(binary.BigEndian.Uint32(body[i+4 : i+8]))
+			fr.Flags = binary.BigEndian.Uint16(body[i+8 : i+10])
+		default:
+			fr.ID = string(body[i : i+4])
+			size = frameSize4(body[i+4 : i+8])
+			fr.Flags = binary.BigEndian.Uint16(body[i+8 : i+10])
+		}
+		i += head
+		if size < 0 || i+size > len(body) {
+			return nil, fmt.Errorf("frame %q overruns the tag", fr.ID)
+		}
+		fr.Data = append([]byte(nil), body[i:i+size]...)
+		if t.Major == 4 && (fr.Flags&frameUnsync != 0 || t.Flags&FlagUnsync != 0) {
+			fr.Data = Resync(fr.Data)
+		}
+		t.Frames = append(t.Frames, fr)
+		i += size
+	}
+	t.Padding = append([]byte(nil), body[i:]...)
+	return t, nil
+}
+
+// frameSize4 reads an ID3v2.4 frame size. Some writers store plain 32-bit
+// sizes in ID3v2.4 tags; those are read as such.
+func frameSize4(b []byte) int {
+	for _, v := range b {
+		if v&0x80 != 0 {
+			return int(binary.BigEndian.Uint32(b))
+		}
+	}
+	return synchsafe(b)
+}
+
+// Serialize encodes the tag, including its footer when the flags ask for one.
+func Serialize(t *Tag) []byte {
+	var body []byte
+	body = append(body, t.Extended...)
+	for _, fr := range t.Frames {
+		data := fr.Data
+		if t.Major == 4 && (fr.Flags&frameUnsync != 0 || t.Flags&FlagUnsync != 0) {
+			data = Unsync(data)
+		}
+		switch t.Major {
+		case 2:
+			n := len(data)
+			body = append(body, fr.ID[:3]...)
+			body = append(body, byte(n>>16), byte(n>>8), byte(n))
+		case 3:
+			body = append(body, fr.ID[:4]...)
+			body = binary.BigEndian.AppendUint32(body, uint32(len(data)))
+			body = binary.BigEndian.AppendUint16(body, fr.Flags)
+		default:
+			body = append(body, fr.ID[:4]...)
+			body = append(body, putSynchsafe(len(data))...)
+			body = binary.BigEndian.AppendUint16(body, fr.Flags)
+		}
+		body = append(body, data...)
+	}
+	body = append(body, t.Padding...)
+	if t.Flags&FlagUnsync != 0 && t.Major < 4 {
+		body = Unsync(body)
+	}
+
+	flags := t.Flags
+	if t.Major != 4 {
+		flags &^= FlagFooter
+	}
+	// A footer replaces the padding in ID3v2.4
+	if flags&FlagFooter != 0 && len(t.Padding) > 0 {
+		flags &^= FlagFooter
+	}
+	head := []byte{'I', 'D', '3', t.Major, t.Revision, flags}
+	head = append(head, putSynchsafe(len(body))...)
+
+	out := append(head, body...)
+	if flags&FlagFooter != 0 {
+		foot := append([]byte("3DI"), head[3:]...)
+		out = append(out, foot...)
+	}
+	return out
+}
+
+// Find returns the frames with the given ID.
+func (t *Tag) Find(id string) []*Frame {
+	var frames []*Frame
+	for _, fr := range t.Frames {
+		if fr.ID == id {
+			frames = append(frames, fr)
+		}
+	}
+	return frames
+}
+
+// Remove deletes the frames for which drop returns true.
+func (t *Tag) Remove(drop func(fr *Frame) bool) {
+	frames := t.Frames[:0]
+	for _, fr := range t.Frames {
+		if !drop(fr) {
+			frames = append(frames, fr)
+		}
+	}
+	t.Frames = frames
+}
+
+// Unsync applies unsynchronisation: a zero byte is inserted after every
+// 0xFF followed by a byte that could be mistaken for a sync (0xE0 or more)
+// or by a zero, and after a trailing 0xFF.
+func Unsync(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i, v := range b {
+		out = append(out, v)
+		if v == 0xFF && (i+1 == len(b) || b[i+1] >= 0xE0 || b[i+1] == 0) {
+			out = append(out, 0)
+		}
+	}
+	return out
+}
+
+// Resync removes unsynchronisation, the inverse of Unsync.
+func Resync(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		out = append(out, b[i])
+		if b[i] == 0xFF && i+1 < len(b) && b[i+1] == 0 {
+			i++
+		}
+	}
+	return out
+}
+
+func synchsafe(b []byte) int {
+	n := 0
+	for _, v := range b {
+		n = n<<7 | int(v&0x7F)
+	}
+	return n
+}
+
+func putSynchsafe(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7F), byte(n >> 14 & 0x7F), byte(n >> 7 & 0x7F), byte(n & 0x7F)}
+}
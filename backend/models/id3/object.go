@@ -0,0 +1,117 @@
+package id3
+
+import "bytes"
+
+// ID3v2.3 frame format flags.
+const (
+	frameCompress3  = 0x0080
+	frameEncrypted3 = 0x0040
+	frameGrouping3  = 0x0020
+)
+
+// content returns the frame data after the bytes the format flags add in
+// front of it. Compressed and encrypted frames cannot be read.
+func (t *Tag) content(fr *Frame) ([]byte, bool) {
+	b := fr.Data
+	skip := 0
+	switch t.Major {
+	case 3:
+		if fr.Flags&(frameCompress3|frameEncrypted3) != 0 {
+			return nil, false
+		}
+		if fr.Flags&frameGrouping3 != 0 {
+			skip++
+		}
+	case 4:
+		if fr.Flags&(frameCompress|frameEncrypted) != 0 {
+			return nil, false
+		}
+		if fr.Flags&frameGrouping != 0 {
+			skip++
+		}
+		if fr.Flags&frameDataLen != 0 {
+			skip += 4
+		}
+	}
+	if skip > len(b) {
+		return nil, false
+	}
+	return b[skip:], true
+}
+
+// Object returns the owner or description and the binary content of a PRIV
+// or GEOB frame (PRV and GEO in ID3v2.2).
+func (t *Tag) Object(fr *Frame) (owner string, data []byte, ok bool) {
+	b, ok := t.content(fr)
+	if !ok {
+		return "", nil, false
+	}
+	switch fr.ID {
+	case "PRIV", "PRV":
+		owner, b, ok = cut(b, 0)
+		return owner, b, ok
+	case "GEOB", "GEO":
+		if len(b) < 1 {
+			return "", nil, false
+		}
+		enc := b[0]
+		b = b[1:]
+		// MIME type (a three letter format in ID3v2.2), file name, description
+		if fr.ID == "GEO" {
+			if len(b) < 3 {
+				return "", nil, false
+			}
+			b = b[3:]
+		} else if _, b, ok = cut(b, 0); !ok {
+			return "", nil, false
+		}
+		if _, b, ok = cut(b, enc); !ok {
+			return "", nil, false
+		}
+		owner, b, ok = cut(b, enc)
+		return owner, b, ok
+	}
+	return "", nil, false
+}
+
+// cut splits a string terminated as required by the text encoding off b.
+// UTF-16 descriptions are returned raw.
+func cut(b []byte, enc byte) (string, []byte, bool) {
+	if enc == 1 || enc == 2 {
+		for i := 0; i+1 < len(b); i += 2 {
+			if b[i] == 0 && b[i+1] == 0 {
+				return string(b[:i]), b[i+2:], true
+			}
+		}
+		return "", nil, false
+	}
+	i := bytes.IndexByte(b, 0)
+	if i < 0 {
+		return "", nil, false
+	}
+	return string(b[:i]), b[i+1:], true
+}
+
+// NewPRIV builds a private frame holding data for owner.
+func (t *Tag) NewPRIV(owner string, data []byte) *Frame {
+	id := "PRIV"
+	if t.Major == 2 {
+		id = "PRV"
+	}
+	b := append([]byte(owner), 0)
+	return &Frame{ID: id, Data: append(b, data...)}
+}
+
+// NewGEOB builds a general encapsulated object frame holding data, with a
+// Latin-1 description and no file name.
+func (t *Tag) NewGEOB(mime, description string, data []byte) *Frame {
+	id, b := "GEOB", []byte{0} // Latin-1
+	if t.Major == 2 {
+		id, b = "GEO", append(b, "BIN"...)
+	} else {
+		b = append(append(b, mime...), 0)
+	}
+	b = append(b, 0) // no file name
+	b = append(append(b, description...), 0)
+	return &Frame{ID: id, Data: append(b, data...)}
+}
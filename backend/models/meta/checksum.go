@@ -0,0 +1,55 @@
+package meta
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"hash/crc32"
+)
+
+// Checksum is the digest of the secret a header carries to tell an intact
+// payload from a truncated or false one.
+type Checksum uint8
+
+const (
+	ChecksumNone   Checksum = 0
+	ChecksumCRC32C Checksum = 1
+	ChecksumSHA256 Checksum = 2
+)
+
+// Checksums lists the digests a file can be written with.
+var Checksums = []Checksum{ChecksumCRC32C, ChecksumSHA256, ChecksumNone}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func (c Checksum) String() string {
+	switch c {
+	case ChecksumNone:
+		return "none"
+	case ChecksumCRC32C:
+		return "crc32c"
+	case ChecksumSHA256:
+		return "sha256"
+	}
+	return fmt.Sprintf("checksum %d", uint8(c))
+}
+
+// New returns the hash computing c, nil for none or one this version does
+// not know.
+func (c Checksum) New() hash.Hash {
+	switch c {
+	case ChecksumCRC32C:
+		return crc32.New(castagnoli)
+	case ChecksumSHA256:
+		return sha256.New()
+	}
+	return nil
+}
+
+// Size is the length of the digest.
+func (c Checksum) Size() int {
+	if h := c.New(); h != nil {
+		return h.Size()
+	}
+	return 0
+}
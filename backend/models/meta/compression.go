@@ -0,0 +1,146 @@
+package meta
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is the algorithm the secret is compressed with before it is
+// encrypted. A header with FlagCompressed set holds it in the byte after
+// the size.
+type Compression uint8
+
+const (
+	CompressionNone    Compression = 0
+	CompressionDeflate Compression = 1
+	CompressionZstd    Compression = 2
+)
+
+// Compressions lists the algorithms a file can be written with.
+var Compressions = []Compression{CompressionNone, CompressionDeflate, CompressionZstd}
+
+// ErrMalformed is wrapped by the errors of decompressing a payload that is
+// not a valid stream.
+var ErrMalformed = errors.New("malformed compressed payload")
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionDeflate:
+		return "deflate"
+	case CompressionZstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("compression %d", uint8(c))
+}
+
+// Compress compresses b at the best ratio c offers.
+func (c Compression) Compress(b []byte) ([]byte, error) {
+	switch c {
+	case CompressionNone:
+		return b, nil
+	case CompressionDeflate:
+		var out bytes.Buffer
+		zw, err := flate.NewWriter(&out, flate.BestCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := zw.Write(b); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	case CompressionZstd:
+		zw, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBestCompression), zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer zw.Close()
+		return zw.EncodeAll(b, nil), nil
+	}
+	return nil, fmt.Errorf("unknown %s", c)
+}
+
+// NewDecompressor returns a writer that decompresses what is written to it
+// into w. Close waits for the last of it and reports a malformed stream
+// with ErrMalformed, failures to write to w as they are.
+func (c Compression) NewDecompressor(w io.Writer) (io.WriteCloser, error) {
+	var open func(r io.Reader) (io.Reader, func(), error)
+	switch c {
+	case CompressionDeflate:
+		open = func(r io.Reader) (io.Reader, func(), error) {
+			zr := flate.NewReader(r)
+			return zr, func() { zr.Close() }, nil
+		}
+	case CompressionZstd:
+		open = func(r io.Reader) (io.Reader, func(), error) {
+			zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, nil, err
+			}
+			return zr, zr.Close, nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown %s", c)
+	}
+
+	// The decompressors read, so they run on the other end of a pipe
+	pr, pw := io.Pipe()
+	d := &decompressor{pw: pw, done: make(chan error, 1)}
+	go func() {
+		zr, closeReader, err := open(pr)
+		if err == nil {
+			out := &errWriter{w: w}
+			_, err = io.Copy(out, zr)
+			closeReader()
+			if err != nil && out.err == nil {
+				err = fmt.Errorf("%w: %v", ErrMalformed, err)
+			}
+		}
+		pr.CloseWithError(err)
+		d.done <- err
+	}()
+	return d, nil
+}
+
+type decompressor struct {
+	pw     *io.PipeWriter
+	done   chan error
+	err    error
+	closed bool
+}
+
+func (d *decompressor) Write(p []byte) (int, error) {
+	return d.pw.Write(p)
+}
+
+func (d *decompressor) Close() error {
+	if !d.closed {
+		d.pw.Close()
+		d.err, d.closed = <-d.done, true
+	}
+	return d.err
+}
+
+// errWriter remembers whether writing failed, to tell a full disk from a
+// malformed stream.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) Write(p []byte) (int, error) {
+	n, err := e.w.Write(p)
+	if err != nil {
+		e.err = err
+	}
+	return n, err
+}
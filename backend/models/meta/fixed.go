@@ -0,0 +1,186 @@
+package meta
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/rand"
+
+	"github.com/rifchzschki/Audio-Steganografi/backend/utils/keystream"
+)
+
+// Format versions
+const (
+	Version1 = 1 // signature and width byte, found by scanning the bits
+	Version2 = 2 // fixed header at key-derived positions
+	Version3 = 3 // salt block, key stretched with a KDF, keystream order
+)
+
+// Version 2 and 3 files have a Fixed header of FixedBits bits, one in the
+// lowest bit of each unit, at positions of the carrier derived from the key
+// and all within FixedSpan units; in version 3 they follow the salt block.
+// The header is masked and authenticated with the key, so without it the
+// positions can neither be found nor told from noise. The units left over
+// hold the packed Header, the payload and the end marker, width bits
+// each, in the order the mode gives; Reed-Solomon coded when the fixed
+// header has parity. Matrix embedded files hold k of those bits in the
+// lowest bits of each block of 2^k-1 units instead.
+const (
+	FixedBits = 128
+	FixedSpan = FixedBits * fixedGap
+	fixedGap  = 8 // largest distance between two header positions
+)
+
+// Algorithm is the carrier a version 2 payload was embedded in.
+type Algorithm uint8
+
+const (
+	AlgorithmLSB Algorithm = iota
+	AlgorithmHuffman
+	AlgorithmAncillary
+	AlgorithmID3PRIV
+	AlgorithmID3GEOB
+	AlgorithmID3Padding
+)
+
+// Mode tells how the units after the fixed header were used.
+type Mode uint8
+
+const (
+	ModeRandom Mode = 1 << 0 // key-seeded random order
+	ModeStrict Mode = 1 << 1 // CRC-protected MP3 bytes left out
+)
+
+// MaxMatrix is the largest k of matrix embedding, blocks of 255 units.
+const MaxMatrix = 8
+
+// Fixed is the version 2 and 3 header.
+type Fixed struct {
+	Version   uint8
+	Width     uint8
+	Mode      Mode
+	Algorithm Algorithm
+	Parity    uint8 // Reed-Solomon parity bytes per codeword, 0 when not coded
+	Depth     uint8 // codewords interleaved
+	Matrix    uint8 // k of the (1, 2^k-1, k) Hamming code of matrix embedding, 0 when not used
+}
+
+// FileKey is what the positions, the fixed header and the order of a file
+// are derived from: the key itself in version 2, the key stretched with
+// the salt of the file in version 3.
+type FileKey struct {
+	version uint8
+	secret  []byte
+}
+
+// KeyV2 is the key of a version 2 file.
+func KeyV2(key string) FileKey {
+	return FileKey{Version2, []byte(key)}
+}
+
+// KeyV3 is the key of a version 3 file, stretched as its salt block says.
+func KeyV3(key string, p KDFParams, salt []byte) (FileKey, error) {
+	secret, err := p.Derive(key, salt)
+	if err != nil {
+		return FileKey{}, err
+	}
+	return FileKey{Version3, secret}, nil
+}
+
+// Version is the format version the key is for.
+func (k FileKey) Version() uint8 {
+	return k.version
+}
+
+// derive derives the key used for one purpose.
+func (k FileKey) derive(purpose string) []byte {
+	m := hmac.New(sha256.New, k.secret)
+	m.Write([]byte(purpose))
+	return m.Sum(nil)
+}
+
+// Positions returns the positions of the fixed header bits, ascending, as
+// indices into the units of the carrier.
+func (k FileKey) Positions() []int {
+	positions := make([]int, FixedBits)
+	p := -1
+	if k.version == Version2 {
+		seed := k.derive("positions")
+		rsrc := rand.New(rand.NewSource(int64(binary.LittleEndian.Uint64(seed[:8]))))
+		for i := range positions {
+			p += 1 + rsrc.Intn(fixedGap)
+			positions[i] = p
+		}
+		return positions
+	}
+	src := keystream.New(k.derive("positions"))
+	p += SaltBits
+	for i := range positions {
+		p += 1 + src.Intn(fixedGap)
+		positions[i] = p
+	}
+	return positions
+}
+
+// Skip returns the indices into the units of the carrier that hold no
+// payload bits, ascending: the salt block and the fixed header.
+func (k FileKey) Skip() []int {
+	var skip []int
+	if k.version >= Version3 {
+		for i := range SaltBits {
+			skip = append(skip, i)
+		}
+	}
+	return append(skip, k.Positions()...)
+}
+
+// Shuffle puts order in the key-derived random order of the payload.
+func (k FileKey) Shuffle(order []int) {
+	if k.version == Version2 {
+		// As version 1, seeded from the key alone
+		h := sha256.Sum256(k.secret)
+		rsrc := rand.New(rand.NewSource(int64(binary.LittleEndian.Uint64(h[:8]))))
+		for i := len(order) - 1; i > 0; i-- {
+			j := rsrc.Intn(i + 1)
+			order[i], order[j] = order[j], order[i]
+		}
+		return
+	}
+	keystream.New(k.derive("order")).Shuffle(order)
+}
+
+// PayloadKey is the key the payload is encrypted with.
+func (k FileKey) PayloadKey() []byte {
+	return k.derive("payload")
+}
+
+// Seal packs, authenticates and masks f into FixedBits/8 bytes.
+func (k FileKey) Seal(f Fixed) []byte {
+	b := []byte{f.Version, f.Width, byte(f.Mode), byte(f.Algorithm), f.Parity, f.Depth, f.Matrix, 0}
+	m := hmac.New(sha256.New, k.derive("tag"))
+	m.Write(b)
+	b = m.Sum(b)[:FixedBits/8]
+	mask := k.derive("mask")
+	for i := range b {
+		b[i] ^= mask[i]
+	}
+	return b
+}
+
+// Open reverses Seal. It reports false when b was not sealed with k.
+func (k FileKey) Open(b []byte) (Fixed, bool) {
+	if len(b) != FixedBits/8 {
+		return Fixed{}, false
+	}
+	mask := k.derive("mask")
+	p := make([]byte, len(b))
+	for i := range b {
+		p[i] = b[i] ^ mask[i]
+	}
+	m := hmac.New(sha256.New, k.derive("tag"))
+	m.Write(p[:8])
+	if !hmac.Equal(m.Sum(nil)[:8], p[8:]) || p[0] != k.version {
+		return Fixed{}, false
+	}
+	return Fixed{Version: p[0], Width: p[1], Mode: Mode(p[2]), Algorithm: Algorithm(p[3]), Parity: p[4], Depth: p[5], Matrix: p[6]}, true
+}
@@ -0,0 +1,100 @@
+package meta
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KDF is the function stretching the key of a version 3 file.
+type KDF uint8
+
+const (
+	KDFArgon2id KDF = 1
+	KDFScrypt   KDF = 2
+)
+
+func (k KDF) String() string {
+	switch k {
+	case KDFArgon2id:
+		return "argon2id"
+	case KDFScrypt:
+		return "scrypt"
+	}
+	return fmt.Sprintf("kdf %d", uint8(k))
+}
+
+// KDFParams are the key derivation settings of a file.
+type KDFParams struct {
+	KDF KDF
+	// Argon2id: log2 of the memory in KiB, passes and lanes.
+	// scrypt: log2 of N, r and p.
+	Cost, Time, Threads uint8
+}
+
+// DefaultKDF holds the settings new files are written with.
+var DefaultKDF = map[KDF]KDFParams{
+	KDFArgon2id: {KDF: KDFArgon2id, Cost: 16, Time: 3, Threads: 4}, // 64 MiB
+	KDFScrypt:   {KDF: KDFScrypt, Cost: 15, Time: 8, Threads: 1},   // 32 MiB
+}
+
+// valid reports whether p is within the limits a decoder accepts, so a
+// file cannot ask for more than 256 MiB or an unbounded amount of work.
+func (p KDFParams) valid() bool {
+	switch p.KDF {
+	case KDFArgon2id:
+		return p.Cost >= 10 && p.Cost <= 18 && p.Time >= 1 && p.Time <= 10 && p.Threads >= 1 && p.Threads <= 16
+	case KDFScrypt:
+		return p.Cost >= 10 && p.Cost <= 18 && p.Time >= 1 && p.Time <= 8 && p.Threads >= 1 && p.Threads <= 16
+	}
+	return false
+}
+
+func (p KDFParams) String() string {
+	if p.KDF == KDFArgon2id {
+		return fmt.Sprintf("%s m=%d KiB t=%d p=%d", p.KDF, 1<<p.Cost, p.Time, p.Threads)
+	}
+	return fmt.Sprintf("%s N=%d r=%d p=%d", p.KDF, 1<<p.Cost, p.Time, p.Threads)
+}
+
+// Derive stretches key with salt into 32 bytes.
+func (p KDFParams) Derive(key string, salt []byte) ([]byte, error) {
+	switch p.KDF {
+	case KDFArgon2id:
+		return argon2.IDKey([]byte(key), salt, uint32(p.Time), 1<<p.Cost, p.Threads, 32), nil
+	case KDFScrypt:
+		return scrypt.Key([]byte(key), salt, 1<<p.Cost, int(p.Time), int(p.Threads), 32)
+	}
+	return nil, fmt.Errorf("unknown key derivation function %d", p.KDF)
+}
+
+// Version 3 files start with a salt block of SaltBits bits in the lowest
+// bit of the first units of the carrier: the KDF parameters and a random
+// salt. It is read before the key is known, so its place and contents do
+// not depend on the key; the parameters are whitened with the salt so no
+// bit of it is constant.
+const (
+	SaltSize = 16
+	SaltBits = 8 * (4 + SaltSize)
+)
+
+// PackSalt builds the salt block.
+func PackSalt(p KDFParams, salt []byte) []byte {
+	w := sha256.Sum256(salt)
+	b := []byte{byte(p.KDF) ^ w[0], p.Cost ^ w[1], p.Time ^ w[2], p.Threads ^ w[3]}
+	return append(b, salt...)
+}
+
+// UnpackSalt reverses PackSalt. It reports false when the parameters are
+// out of bounds, which they mostly are for bits that are no salt block.
+func UnpackSalt(b []byte) (KDFParams, []byte, bool) {
+	if len(b) != SaltBits/8 {
+		return KDFParams{}, nil, false
+	}
+	salt := b[4:]
+	w := sha256.Sum256(salt)
+	p := KDFParams{KDF: KDF(b[0] ^ w[0]), Cost: b[1] ^ w[1], Time: b[2] ^ w[2], Threads: b[3] ^ w[3]}
+	return p, salt, p.valid()
+}
@@ -1,6 +1,9 @@
 package meta
 
-import "encoding/binary"
+import (
+    "encoding/binary"
+    "io"
+)
 
 const Magic = 0x6D703373
 
@@ -9,6 +12,24 @@ type Flags uint8
 const (
     FlagEncrypted   Flags = 1 << 0 
     FlagRandomStart Flags = 1 << 1 
+    // Sealed with an AEAD keyed from the file key, version 3 and later
+    FlagAES256GCM        Flags = 1 << 2
+    FlagChaCha20Poly1305 Flags = 1 << 3
+    // The AEAD key is wrapped to X25519 recipients, not derived
+    FlagRecipients Flags = 1 << 4
+    // Extension records follow the size
+    FlagExtended Flags = 1 << 5
+    // The secret was compressed before encryption, the algorithm byte
+    // follows the size
+    FlagCompressed Flags = 1 << 6
+)
+
+// Extension records, a type and length byte each before the value.
+// Readers skip the types they do not know.
+const (
+    recordSigner    = 1 // Ed25519 public key
+    recordSignature = 2 // Ed25519 signature
+    recordChecksum  = 3 // checksum algorithm and digest of the secret
 )
 
 type Header struct {
@@ -18,14 +39,55 @@ type Header struct {
     Name    string // Original filename
     Size    uint64 // Size of the payload in bytes
     Ext     string // File extension
+    
+    // Follows the size, set FlagCompressed when packed
+    Compression Compression
+    
+    // Extension, set FlagExtended when packed
+    Signer    []byte // public key the header and payload were signed with
+    Signature []byte
+    Checksum  Checksum
+    Digest    []byte // of the secret as extracted, by Checksum
+}
+
+// extension packs the extension records, nil when there are none.
+func (h Header) extension() []byte {
+    var b []byte
+    record := func(t byte, v []byte) {
+        if len(v) > 0 {
+            b = append(append(b, t, byte(len(v))), v...)
+        }
+    }
+    if h.Checksum != ChecksumNone {
+        record(recordChecksum, append([]byte{byte(h.Checksum)}, h.Digest...))
+    }
+    record(recordSigner, h.Signer)
+    record(recordSignature, h.Signature)
+    return b
+}
+
+// Signed returns the packed header without the signature, what the
+// signature covers.
+func Signed(h Header) []byte {
+    h.Signature = nil
+    return Pack(h)
 }
 
 func Pack(h Header) []byte {
     name := []byte(h.Name)
     ext := []byte(h.Ext)
     
+    records := h.extension()
+    h.Flags &^= FlagExtended | FlagCompressed
+    if records != nil {
+        h.Flags |= FlagExtended
+    }
+    if h.Compression != CompressionNone {
+        h.Flags |= FlagCompressed
+    }
+    
     // Calculate required buffer size
-    bufSize := 4 + 1 + 1 + 1 + 1 + len(name) + 1 + len(ext) + 8
+    bufSize := 4 + 1 + 1 + 1 + 1 + len(name) + 1 + len(ext) + 8 + 1 + 2 + len(records)
     b := make([]byte, 0, bufSize)
     tmp := make([]byte, 8)
     
@@ -50,6 +112,17 @@ func Pack(h Header) []byte {
     binary.BigEndian.PutUint64(tmp, h.Size)
     b = append(b, tmp...)
     
+    // Pack compression
+    if h.Flags&FlagCompressed != 0 {
+        b = append(b, byte(h.Compression))
+    }
+    
+    // Pack extension
+    if records != nil {
+        b = binary.BigEndian.AppendUint16(b, uint16(len(records)))
+        b = append(b, records...)
+    }
+    
     return b
 }
 
@@ -99,6 +172,94 @@ func Unpack(b []byte) (Header, bool) {
         return h, false
     }
     h.Size = binary.BigEndian.Uint64(b[i : i+8])
+    i += 8
+    
+    // Unpack compression
+    if h.Flags&FlagCompressed != 0 {
+        if i+1 > len(b) {
+            return h, false
+        }
+        h.Compression = Compression(b[i])
+        i++
+    }
+    
+    // Unpack extension
+    if h.Flags&FlagExtended == 0 {
+        return h, true
+    }
+    if i+2 > len(b) {
+        return h, false
+    }
+    n := int(binary.BigEndian.Uint16(b[i:]))
+    i += 2
+    if i+n > len(b) {
+        return h, false
+    }
+    for r := b[i : i+n]; len(r) > 0; {
+        if len(r) < 2 || 2+int(r[1]) > len(r) {
+            return h, false
+        }
+        v := r[2 : 2+int(r[1])]
+        switch r[0] {
+        case recordSigner:
+            h.Signer = v
+        case recordSignature:
+            h.Signature = v
+        case recordChecksum:
+            if len(v) == 0 {
+                return h, false
+            }
+            h.Checksum, h.Digest = Checksum(v[0]), v[1:]
+        }
+        r = r[2+len(v):]
+    }
     
     return h, true
 }
+
+// Need returns the length of the packed header that starts with b, as far
+// as b tells: while b is incomplete it may be short of the full length but
+// is always more than len(b).
+func Need(b []byte) int {
+    // Magic, version, flags, width and name length
+    n := 8
+    if len(b) < n {
+        return n
+    }
+    // Name and extension length
+    n += int(b[7]) + 1
+    if len(b) < n {
+        return n
+    }
+    // Extension, size and compression
+    n += int(b[n-1]) + 8
+    if Flags(b[5])&FlagCompressed != 0 {
+        n++
+    }
+    if len(b) < n || Flags(b[5])&FlagExtended == 0 {
+        return n
+    }
+    // Extension records
+    n += 2
+    if len(b) < n {
+        return n
+    }
+    return n + int(binary.BigEndian.Uint16(b[n-2:]))
+}
+
+// Read reads a packed header from r, stopping as soon as the magic number
+// does not match.
+func Read(r io.Reader) (Header, bool) {
+    var b []byte
+    for n := Need(b); n > len(b); n = Need(b) {
+        m := len(b)
+        b = append(b, make([]byte, n-m)...)
+        if _, err := io.ReadFull(r, b[m:]); err != nil {
+            return Header{}, false
+        }
+        if m < 4 && binary.BigEndian.Uint32(b[:4]) != Magic {
+            return Header{}, false
+        }
+    }
+    return Unpack(b)
+}
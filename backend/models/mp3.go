@@ -1,11 +1,20 @@
 package models
 
+import "github.com/rifchzschki/Audio-Steganografi/backend/models/id3"
+
 type AudioMetadata struct {
-	SampleRate int
-	Channels   int
-	BitDepth   int
-	Duration   float64
-	TotalBytes int
+	SampleRate     int
+	Channels       int
+	BitDepth       int
+	Duration       float64
+	TotalBytes     int
+	Frames         int
+	Bitrate        int // average bits per second
+	VBR            bool
+	TagType        string // Xing, Info or VBRI when a tag frame is present
+	Encoder        string
+	EncoderDelay   int // samples trimmed from the start for gapless playback
+	EncoderPadding int // samples trimmed from the end for gapless playback
 }
 
 type Tag interface {
@@ -46,14 +55,16 @@ type ID3v2Header struct {
 }
 
 type MP3FrameHeader struct {
-	VersionID     int
-	Layer         int
-	ProtectionBit bool
-	Bitrate       int
-	SampleRate    int
-	Padding       bool
-	ChannelMode   int
-	FrameLength   int
+	VersionID       int
+	Layer           int
+	ProtectionBit   bool
+	Bitrate         int
+	SampleRate      int
+	Padding         bool
+	ChannelMode     int
+	FrameLength     int
+	FreeFormat      bool
+	SamplesPerFrame int
 }
 
 type MP3Frame struct {
@@ -69,6 +80,7 @@ func (f *MP3Frame) Size() int                  { return len(f.HeaderBytes) + len
 type MP3File struct {
 	ID3v2     *ID3v2Header
 	ID3v2Data []byte
+	ID3v2Tag  *id3.Tag // parsed tag, nil if it could not be parsed
 	Frames    []Frame
 	ID3v1     Tag
 }
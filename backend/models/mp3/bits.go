@@ -0,0 +1,60 @@
+package mp3
+
+// bitReader reads big-endian bit fields from a byte slice. Reads past the
+// end return zero bits.
+type bitReader struct {
+	data []byte
+	pos  int // bit position
+}
+
+func (r *bitReader) read(n int) int {
+	v := 0
+	for k := 0; k < n; k++ {
+		v <<= 1
+		if i := r.pos >> 3; i < len(r.data) {
+			v |= int(r.data[i]>>(7-uint(r.pos&7))) & 1
+		}
+		r.pos++
+	}
+	return v
+}
+
+// bitWriter appends big-endian bit fields to a byte slice.
+type bitWriter struct {
+	data []byte
+	n    int // bits written
+}
+
+func (w *bitWriter) write(v, n int) {
+	for k := n - 1; k >= 0; k-- {
+		if w.n&7 == 0 {
+			w.data = append(w.data, 0)
+		}
+		if (v>>uint(k))&1 == 1 {
+			w.data[w.n>>3] |= 0x80 >> uint(w.n&7)
+		}
+		w.n++
+	}
+}
+
+// copyBits appends n bits of src starting at bit position from.
+func (w *bitWriter) copyBits(src []byte, from, n int) {
+	r := &bitReader{data: src, pos: from}
+	for ; n >= 16; n -= 16 {
+		w.write(r.read(16), 16)
+	}
+	w.write(r.read(n), n)
+}
+
+// putBits stores the low n bits of v at bit position pos of buf.
+func putBits(buf []byte, pos, n, v int) {
+	for k := n - 1; k >= 0; k-- {
+		mask := byte(0x80 >> uint(pos&7))
+		if (v>>uint(k))&1 == 1 {
+			buf[pos>>3] |= mask
+		} else {
+			buf[pos>>3] &^= mask
+		}
+		pos++
+	}
+}
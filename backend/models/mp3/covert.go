@@ -0,0 +1,179 @@
+package mp3
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Bit positions of the private, copyright and original bits in HeaderBytes.
+var headerBits = []int{2*8 + 7, 3*8 + 4, 3*8 + 5}
+
+// Covert lists the bits of the audio frames that decoders ignore: the
+// private, copyright and original bits of every header, the private bits
+// of the Layer III side information and the ancillary data left after the
+// main data (stuffing and padding slots included). Writing them leaves the
+// decoded audio bit-identical.
+type Covert struct {
+	Header    int // header bits
+	Private   int // side information private bits
+	Ancillary int // bits after the main data of the granules
+
+	frames []*Frame
+	bits   []covertBit
+}
+
+type covertBit struct {
+	frame  int32
+	header bool // position in HeaderBytes instead of Data
+	pos    int32
+}
+
+// Covert finds the ignored bits of the audio frames. Bits are ordered by
+// frame: the header bits, the private bits and then the ancillary bits of
+// the frame's own main data area. Unless protected is set, the header and
+// private bits of CRC-protected frames are left out.
+func (f *File) Covert(protected bool) *Covert {
+	c := &Covert{frames: f.AudioFrames()}
+
+	// used has one bit mask per byte of the reservoir, i.e. the main data
+	// areas of all frames laid end to end
+	var used []byte
+	areas := make([]int, len(c.frames))
+	sides := make([]*SideInfo, len(c.frames))
+	for i, fr := range c.frames {
+		areas[i] = -1
+		if fr.Header.Layer != 3 {
+			continue
+		}
+		si, err := ParseSideInfo(fr.Header, fr.Data)
+		if err != nil {
+			continue
+		}
+		sides[i] = si
+		own := len(used)
+		areas[i] = own
+		used = append(used, make([]byte, len(fr.Data)-fr.Header.MainDataOffset())...)
+
+		bits := 0
+		for gr := 0; gr < si.Granules; gr++ {
+			for ch := 0; ch < fr.Header.Channels(); ch++ {
+				bits += si.Gr[gr][ch].Part23Length
+			}
+		}
+		start := (own - si.MainDataBegin) * 8
+		for k := max(start, 0); k < start+bits && k>>3 < len(used); k++ {
+			used[k>>3] |= 0x80 >> uint(k&7)
+		}
+	}
+
+	for i, fr := range c.frames {
+		h := fr.Header
+		si := sides[i]
+		// Bits covered by a CRC are only used where it can be recomputed
+		_, ok := fr.ComputeCRC()
+		crc := h.Protection && (!protected || !ok)
+		if !crc {
+			for _, pos := range headerBits {
+				c.bits = append(c.bits, covertBit{int32(i), true, int32(pos)})
+				c.Header++
+			}
+		}
+		if si == nil {
+			continue
+		}
+		side := (h.MainDataOffset() - h.SideInfoLength()) * 8
+		n, skip := h.Channels(), 8
+		if h.VersionID == MPEG1 {
+			n, skip = 5, 9
+			if h.Channels() == 2 {
+				n = 3
+			}
+		}
+		if crc {
+			n = 0 // covered by the CRC
+		}
+		for k := 0; k < n; k++ {
+			c.bits = append(c.bits, covertBit{int32(i), false, int32(side + skip + k)})
+			c.Private++
+		}
+
+		off := h.MainDataOffset()
+		for k := 0; k < (len(fr.Data)-off)*8; k++ {
+			b := areas[i]*8 + k
+			if used[b>>3]&(0x80>>uint(b&7)) == 0 {
+				c.bits = append(c.bits, covertBit{int32(i), false, int32(off*8 + k)})
+				c.Ancillary++
+			}
+		}
+	}
+	return c
+}
+
+// Bits returns the current value of every covert bit.
+func (c *Covert) Bits() []byte {
+	p := make([]byte, len(c.bits))
+	for i, b := range c.bits {
+		buf := c.frames[b.frame].Data
+		if b.header {
+			buf = c.frames[b.frame].HeaderBytes
+		}
+		p[i] = buf[b.pos>>3] >> uint(7-b.pos&7) & 1
+	}
+	return p
+}
+
+// SetBits writes p into the covert bits, the inverse of Bits, and updates
+// the header fields of the frames it changed. It returns the number of bits
+// changed. Protected frames need their CRC recomputed afterwards.
+func (c *Covert) SetBits(p []byte) int {
+	changed := 0
+	touched := map[int32]bool{}
+	for i, b := range c.bits {
+		if i >= len(p) {
+			break
+		}
+		fr := c.frames[b.frame]
+		buf := fr.Data
+		if b.header {
+			buf = fr.HeaderBytes
+		}
+		if int(buf[b.pos>>3]>>uint(7-b.pos&7)&1) == int(p[i]&1) {
+			continue
+		}
+		putBits(buf, int(b.pos), 1, int(p[i]&1))
+		touched[b.frame] = true
+		changed++
+	}
+
+	for i := range touched {
+		fr := c.frames[i]
+		h := fr.Header
+		h.Private = fr.HeaderBytes[2]&0x01 != 0
+		h.Copyright = fr.HeaderBytes[3]&0x08 != 0
+		h.Original = fr.HeaderBytes[3]&0x04 != 0
+	}
+	return changed
+}
+
+// Len returns the number of covert bits.
+func (c *Covert) Len() int {
+	return len(c.bits)
+}
+
+// String summarises the covert capacity by region.
+func (c *Covert) String() string {
+	var parts []string
+	add := func(n int, what string) {
+		if n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, what))
+		}
+	}
+	add(c.Ancillary, "ancillary")
+	add(c.Private, "side information private")
+	add(c.Header, "header")
+	s := fmt.Sprintf("%d covert bits (%d bytes)", len(c.bits), len(c.bits)/8)
+	if len(parts) > 0 {
+		s += ": " + strings.Join(parts, ", ")
+	}
+	return s
+}
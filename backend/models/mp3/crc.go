@@ -0,0 +1,227 @@
+package mp3
+
+import (
+	"fmt"
+	"strings"
+)
+
+// crc16 is the CRC-16 (polynomial 0x8005, initial value 0xFFFF) used by
+// protected MPEG audio frames.
+func crc16(crc uint16, b []byte) uint16 {
+	return crc16Bits(crc, b, len(b)*8)
+}
+
+// crc16Bits runs the CRC over the first n bits of b.
+func crc16Bits(crc uint16, b []byte, n int) uint16 {
+	for k := 0; k < n; k++ {
+		bit := uint16(b[k>>3]>>(7-uint(k&7))) & 1
+		if (crc>>15)^bit != 0 {
+			crc = crc<<1 ^ 0x8005
+		} else {
+			crc <<= 1
+		}
+	}
+	return crc
+}
+
+// Layer II bit allocation tables (ISO/IEC 11172-3 B.2a-d, ISO/IEC 13818-3
+// B.1): number of allocation bits per subband.
+var layer2Alloc = [5][]int{
+	{4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 2, 2, 2, 2},
+	{4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 2, 2, 2, 2, 2, 2, 2},
+	{4, 4, 3, 3, 3, 3, 3, 3},
+	{4, 4, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3},
+	{4, 4, 4, 4, 3, 3, 3, 3, 3, 3, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2},
+}
+
+// layer2Table picks the allocation table from the bitrate per channel and
+// the sample rate.
+func (h *FrameHeader) layer2Table() []int {
+	if h.VersionID != MPEG1 {
+		return layer2Alloc[4]
+	}
+	br := h.Bitrate / 1000 / h.Channels()
+	switch {
+	case (h.SampleRate == 48000 && br >= 56) || (br >= 56 && br <= 80):
+		return layer2Alloc[0]
+	case h.SampleRate != 48000 && br >= 96:
+		return layer2Alloc[1]
+	case h.SampleRate != 32000 && br <= 48:
+		return layer2Alloc[2]
+	default:
+		return layer2Alloc[3]
+	}
+}
+
+// bound is the first subband coded as intensity stereo in Layers I and II.
+func (h *FrameHeader) bound() int {
+	if h.ChannelMode == JointStereo {
+		return (h.ModeExtension + 1) * 4
+	}
+	return 32
+}
+
+// ProtectedBits returns how many bits of Data after the CRC word the CRC
+// covers: the Layer III side information, the Layer I bit allocation, or
+// the Layer II bit allocation and scale factor selection. Layer II needs
+// the allocation itself, so ok is false when the frame is too short.
+func (fr *Frame) ProtectedBits() (n int, ok bool) {
+	h := fr.Header
+	nch := h.Channels()
+	switch h.Layer {
+	case 3:
+		n = h.SideInfoLength() * 8
+	case 1:
+		for sb := 0; sb < 32; sb++ {
+			if sb < h.bound() {
+				n += 4 * nch
+			} else {
+				n += 4
+			}
+		}
+	default:
+		if h.Bitrate == 0 {
+			return 0, false
+		}
+		table := h.layer2Table()
+		r := &bitReader{data: fr.Data, pos: 16}
+		for sb := range table {
+			for ch := 0; ch < nch; ch++ {
+				if sb >= h.bound() && ch > 0 {
+					// Intensity stereo subbands share one allocation
+					break
+				}
+				if r.read(table[sb]) != 0 {
+					n += 2 * nch
+					if sb < h.bound() {
+						n -= 2 * (nch - 1)
+					}
+				}
+			}
+		}
+		n += r.pos - 16
+	}
+	if 2+(n+7)/8 > len(fr.Data) {
+		return n, false
+	}
+	return n, true
+}
+
+// StoredCRC returns the CRC word of a protected frame.
+func (fr *Frame) StoredCRC() (uint16, bool) {
+	if !fr.Header.Protection || len(fr.Data) < 2 {
+		return 0, false
+	}
+	return uint16(fr.Data[0])<<8 | uint16(fr.Data[1]), true
+}
+
+// ComputeCRC computes the CRC of a protected frame over the last two header
+// bytes and the protected bits.
+func (fr *Frame) ComputeCRC() (uint16, bool) {
+	if !fr.Header.Protection {
+		return 0, false
+	}
+	n, ok := fr.ProtectedBits()
+	if !ok {
+		return 0, false
+	}
+	crc := crc16(0xFFFF, fr.HeaderBytes[2:4])
+	return crc16Bits(crc, fr.Data[2:], n), true
+}
+
+// CheckCRC reports whether a protected frame carries the right CRC. ok is
+// false for unprotected frames and frames too short to check.
+func (fr *Frame) CheckCRC() (valid, ok bool) {
+	stored, ok := fr.StoredCRC()
+	if !ok {
+		return false, false
+	}
+	crc, ok := fr.ComputeCRC()
+	if !ok {
+		return false, false
+	}
+	return crc == stored, true
+}
+
+// UpdateCRC stores the computed CRC in a protected frame.
+func (fr *Frame) UpdateCRC() bool {
+	crc, ok := fr.ComputeCRC()
+	if !ok {
+		return false
+	}
+	fr.Data[0], fr.Data[1] = byte(crc>>8), byte(crc)
+	return true
+}
+
+// UpdateCRC recomputes the CRC of the protected audio frames, except the
+// frames in skip (indices into AudioFrames, usually the frames whose CRC
+// was already wrong before embedding). It returns the number of frames
+// updated.
+func (f *File) UpdateCRC(skip []int) int {
+	keep := map[int]bool{}
+	for _, i := range skip {
+		keep[i] = true
+	}
+	n := 0
+	for i, fr := range f.AudioFrames() {
+		if fr.Header.Protection && !keep[i] && fr.UpdateCRC() {
+			n++
+		}
+	}
+	return n
+}
+
+// CRCReport is the result of checking the CRC of every protected frame.
+type CRCReport struct {
+	Protected int   // frames with the protection bit
+	Failed    []int // indices into AudioFrames of frames with a wrong CRC
+	Unchecked int   // protected frames too short to check
+}
+
+// ValidateCRC checks the CRC of every protected audio frame.
+func (f *File) ValidateCRC() *CRCReport {
+	r := &CRCReport{}
+	for i, fr := range f.AudioFrames() {
+		r.Check(i, fr)
+	}
+	return r
+}
+
+// Check adds audio frame i to the report and reports whether its CRC is
+// wrong.
+func (r *CRCReport) Check(i int, fr *Frame) bool {
+	if !fr.Header.Protection {
+		return false
+	}
+	r.Protected++
+	valid, ok := fr.CheckCRC()
+	switch {
+	case !ok:
+		r.Unchecked++
+	case !valid:
+		r.Failed = append(r.Failed, i)
+		return true
+	}
+	return false
+}
+
+func (r *CRCReport) String() string {
+	if r.Protected == 0 {
+		return "no CRC-protected frames"
+	}
+	s := fmt.Sprintf("%d of %d protected frames fail CRC", len(r.Failed), r.Protected)
+	if r.Unchecked > 0 {
+		s += fmt.Sprintf(", %d too short to check", r.Unchecked)
+	}
+	if len(r.Failed) > 0 {
+		var idx []string
+		for _, i := range r.Failed[:min(len(r.Failed), 10)] {
+			idx = append(idx, fmt.Sprint(i))
+		}
+		if len(r.Failed) > 10 {
+			idx = append(idx, "...")
+		}
+		s += " (frames " + strings.Join(idx, ", ") + ")"
+	}
+	return s
+}
@@ -0,0 +1,79 @@
+package mp3
+
+import (
+	"bytes"
+	"io"
+
+	gomp3 "github.com/hajimehoshi/go-mp3"
+)
+
+// PCM is the decoded audio of the audio frames: 16-bit samples interleaved
+// by channel, one slice per frame so two decodes can be aligned frame by
+// frame. Frames that failed to decode are nil.
+type PCM struct {
+	SampleRate int
+	Channels   int
+	Frames     [][]int16
+	Failed     []int // indices into AudioFrames
+}
+
+// reader hides Seek from go-mp3, which would otherwise scan the whole
+// stream on every restart.
+type reader struct{ io.Reader }
+
+// DecodePCM decodes the audio frames with go-mp3. When a frame fails the
+// decoder restarts at the next one, without the bit reservoir of the
+// frames before it.
+func (f *File) DecodePCM() *PCM {
+	frames := f.AudioFrames()
+	p := &PCM{Frames: make([][]int16, len(frames))}
+	if len(frames) == 0 {
+		return p
+	}
+	p.SampleRate = frames[0].Header.SampleRate
+	p.Channels = frames[0].Header.Channels()
+
+	for start := 0; start < len(frames); {
+		var stream []byte
+		for _, fr := range frames[start:] {
+			stream = append(stream, fr.HeaderBytes...)
+			stream = append(stream, fr.Data...)
+		}
+		var out []byte
+		d, err := gomp3.NewDecoder(reader{bytes.NewReader(stream)})
+		if err == nil {
+			out, err = io.ReadAll(d)
+		}
+
+		// go-mp3 always writes stereo: 4 bytes per sample frame
+		i := start
+		for ; i < len(frames); i++ {
+			n := frames[i].Header.SamplesPerFrame * 4
+			if len(out) < n {
+				break
+			}
+			s := make([]int16, 0, frames[i].Header.SamplesPerFrame*p.Channels)
+			for k := 0; k < n; k += 4 {
+				s = append(s, int16(uint16(out[k])|uint16(out[k+1])<<8))
+				if p.Channels == 2 {
+					s = append(s, int16(uint16(out[k+2])|uint16(out[k+3])<<8))
+				}
+			}
+			p.Frames[i] = s
+			out = out[n:]
+		}
+		if i == len(frames) {
+			break
+		}
+		if err == nil {
+			// The decoder stopped early on a truncated frame
+			for ; i < len(frames); i++ {
+				p.Failed = append(p.Failed, i)
+			}
+			break
+		}
+		p.Failed = append(p.Failed, i)
+		start = i + 1
+	}
+	return p
+}
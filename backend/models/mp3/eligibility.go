@@ -0,0 +1,224 @@
+package mp3
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Eligibility marks which bytes of the audio frames may carry hidden data.
+// Only the Layer III main data area is eligible: frame headers, CRC words,
+// side information and the VBR tag frame are left untouched because a
+// single flipped bit there breaks the decoding of whole granules.
+type Eligibility struct {
+	Eligible []bool // one entry per byte of the concatenated Data of AudioFrames
+
+	Total     int // bytes of every frame, headers and tag frame included
+	Usable    int // eligible bytes
+	Headers   int // 4-byte frame headers
+	CRC       int // CRC-16 words of protected frames
+	SideInfo  int // Layer III side information
+	TagFrame  int // Xing/Info/VBRI tag frame
+	Broken    int // bytes of frames too short to hold their side information
+	Ancillary int // eligible bytes not used by any granule (stuffing or ancillary data)
+	Borrowed  int // eligible bytes holding main data of a later frame (bit reservoir)
+	Protected int // CRC-covered Layer I/II bytes, see ExcludeProtected
+
+	protected []int // offsets of the CRC-covered Layer I/II bytes
+	excluded  bool
+}
+
+// Eligibility builds the eligibility map of the audio frames. Main data
+// is followed through the bit reservoir: main_data_begin points back into
+// the main data area of earlier frames, so those bytes are counted as
+// belonging to the frame that reads them.
+func (f *File) Eligibility() *Eligibility {
+	s := NewEligibilityScanner(false)
+	if f.Tag != nil && len(f.Frames) > 0 {
+		s.TagFrame(f.Frames[0])
+	}
+	e := s.e
+	for _, fr := range f.AudioFrames() {
+		mark, protected := s.Frame(fr)
+		for _, k := range protected {
+			e.protected = append(e.protected, len(e.Eligible)+k)
+		}
+		e.Eligible = append(e.Eligible, mark...)
+	}
+	return s.Close()
+}
+
+// reservoirWindow is how far back main_data_begin can point (9 bits in
+// MPEG-1), rounded up.
+const reservoirWindow = 512
+
+// EligibilityScanner builds the eligibility of the audio frames one frame
+// at a time, for streams that are not held in memory. It keeps only the
+// part of the bit reservoir a later frame can still read. The returned
+// Eligibility has the counters but no Eligible map.
+type EligibilityScanner struct {
+	e      *Eligibility
+	strict bool
+	used   []bool // reservoir bytes from base on, true once a granule read them
+	base   int
+}
+
+// NewEligibilityScanner starts a scan. In strict mode the CRC-protected
+// Layer I/II bytes are left out, as ExcludeProtected does.
+func NewEligibilityScanner(strict bool) *EligibilityScanner {
+	return &EligibilityScanner{e: &Eligibility{excluded: strict}, strict: strict}
+}
+
+// TagFrame counts the Xing/Info/VBRI tag frame, which is never eligible.
+func (s *EligibilityScanner) TagFrame(fr *Frame) {
+	s.e.TagFrame = len(fr.HeaderBytes) + len(fr.Data)
+	s.e.Total += s.e.TagFrame
+}
+
+// Frame marks the eligible bytes of the Data of the next audio frame. It
+// also returns the offsets of the CRC-protected bytes that are still
+// eligible, none in strict mode.
+func (s *EligibilityScanner) Frame(fr *Frame) ([]bool, []int) {
+	e := s.e
+	h := fr.Header
+	e.Total += len(fr.HeaderBytes) + len(fr.Data)
+	e.Headers += len(fr.HeaderBytes)
+	mark := make([]bool, len(fr.Data))
+	var protected []int
+
+	crc := 0
+	if h.Protection {
+		crc = 2
+	}
+	if h.Layer != 3 {
+		// Layers I and II have no side information or reservoir
+		if crc > len(fr.Data) {
+			crc = len(fr.Data)
+		}
+		e.CRC += crc
+		for k := crc; k < len(fr.Data); k++ {
+			mark[k] = true
+		}
+		if n, ok := fr.ProtectedBits(); ok && crc > 0 {
+			for k := crc; k < crc+(n+7)/8 && k < len(fr.Data); k++ {
+				if s.strict {
+					mark[k] = false
+					e.Protected++
+				} else {
+					protected = append(protected, k)
+				}
+			}
+		}
+		s.count(mark)
+		return mark, protected
+	}
+
+	si, err := ParseSideInfo(h, fr.Data)
+	if err != nil {
+		e.Broken += len(fr.Data)
+		return mark, nil
+	}
+	e.CRC += crc
+	e.SideInfo += h.SideInfoLength()
+
+	off := h.MainDataOffset()
+	for k := off; k < len(fr.Data); k++ {
+		mark[k] = true
+	}
+
+	// Reservoir bytes this far back can no longer be read by any frame
+	own := s.base + len(s.used)
+	if drop := own - reservoirWindow - s.base; drop > 4*reservoirWindow {
+		for _, u := range s.used[:drop] {
+			if !u {
+				e.Ancillary++
+			}
+		}
+		s.used = append(s.used[:0], s.used[drop:]...)
+		s.base += drop
+	}
+	s.used = append(s.used, make([]bool, len(fr.Data)-off)...)
+
+	// Mark the reservoir bytes this frame's granules read
+	start := own - si.MainDataBegin
+	for k := start; k < start+si.MainDataLength(h.Channels()) && k < s.base+len(s.used); k++ {
+		if k >= s.base && !s.used[k-s.base] {
+			s.used[k-s.base] = true
+			if k < own {
+				e.Borrowed++
+			}
+		}
+	}
+	s.count(mark)
+	return mark, protected
+}
+
+func (s *EligibilityScanner) count(mark []bool) {
+	for _, ok := range mark {
+		if ok {
+			s.e.Usable++
+		}
+	}
+}
+
+// Close finishes the scan and returns the counters.
+func (s *EligibilityScanner) Close() *Eligibility {
+	for _, u := range s.used {
+		if !u {
+			s.e.Ancillary++
+		}
+	}
+	s.used = nil
+	return s.e
+}
+
+// ExcludeProtected makes the Layer I/II bytes covered by the CRC (bit
+// allocation and scale factor selection) ineligible, so embedding never
+// invalidates the CRC. Layer III side information is always excluded.
+func (e *Eligibility) ExcludeProtected() {
+	if e.excluded {
+		return
+	}
+	e.excluded = true
+	for _, k := range e.protected {
+		if e.Eligible[k] {
+			e.Eligible[k] = false
+			e.Usable--
+			e.Protected++
+		}
+	}
+}
+
+// Positions returns the offsets of the eligible bytes.
+func (e *Eligibility) Positions() []int {
+	pos := make([]int, 0, e.Usable)
+	for i, ok := range e.Eligible {
+		if ok {
+			pos = append(pos, i)
+		}
+	}
+	return pos
+}
+
+// String summarises how many bytes were excluded and why.
+func (e *Eligibility) String() string {
+	var parts []string
+	add := func(n int, why string) {
+		if n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, why))
+		}
+	}
+	add(e.Headers, "frame header")
+	add(e.CRC, "CRC")
+	add(e.SideInfo, "side information")
+	add(e.TagFrame, "VBR tag frame")
+	add(e.Protected, "CRC-protected")
+	add(e.Broken, "truncated frame")
+	s := fmt.Sprintf("%d of %d bytes usable", e.Usable, e.Total)
+	if len(parts) > 0 {
+		s += " (excluded: " + strings.Join(parts, ", ") + ")"
+	}
+	if e.Ancillary > 0 || e.Borrowed > 0 {
+		s += fmt.Sprintf(", %d usable bytes are ancillary data, %d carry reservoir data of a later frame", e.Ancillary, e.Borrowed)
+	}
+	return s
+}
@@ -0,0 +1,213 @@
+package mp3
+
+import (
+	"errors"
+
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/id3"
+	"github.com/rifchzschki/Audio-Steganografi/backend/utils"
+)
+
+// Raw values of the 2-bit version field.
+const (
+	MPEG25 = 0
+	MPEG2  = 2
+	MPEG1  = 3
+)
+
+// Channel modes.
+const (
+	Stereo      = 0
+	JointStereo = 1
+	DualChannel = 2
+	Mono        = 3
+)
+
+// ParseHeader decodes the 4-byte frame header at the start of b. Free format
+// frames (bitrate index 0) come back with FreeFormat set and no length; call
+// SetFreeFormatSize once the frame size is known.
+func ParseHeader(b []byte) (*FrameHeader, error) {
+	if len(b) < 4 {
+		return nil, errors.New("header too short")
+	}
+	if b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return nil, errors.New("no frame sync")
+	}
+
+	h := &FrameHeader{
+		VersionID:     int(b[1]>>3) & 0x03,
+		Layer:         4 - int(b[1]>>1)&0x03,
+		Protection:    b[1]&0x01 == 0,
+		Padding:       b[2]&0x02 != 0,
+		Private:       b[2]&0x01 != 0,
+		ChannelMode:   int(b[3]>>6) & 0x03,
+		ModeExtension: int(b[3]>>4) & 0x03,
+		Copyright:     b[3]&0x08 != 0,
+		Original:      b[3]&0x04 != 0,
+		Emphasis:      int(b[3]) & 0x03,
+	}
+	if h.VersionID == 1 {
+		return nil, errors.New("reserved MPEG version")
+	}
+	if h.Layer == 4 {
+		return nil, errors.New("reserved layer")
+	}
+	if h.Emphasis == 2 {
+		return nil, errors.New("reserved emphasis")
+	}
+
+	brIdx := int(b[2]>>4) & 0x0F
+	srIdx := int(b[2]>>2) & 0x03
+	if brIdx == 15 || srIdx == 3 {
+		return nil, errors.New("invalid bitrate or sample rate index")
+	}
+
+	switch h.VersionID {
+	case MPEG1:
+		h.SampleRate = utils.MP3SampleRateTable[0][srIdx]
+	case MPEG2:
+		h.SampleRate = utils.MP3SampleRateTable[1][srIdx]
+	default:
+		h.SampleRate = utils.MP3SampleRateTable[2][srIdx]
+	}
+
+	// Layer II does not allow every bitrate/mode pair, but decoders accept
+	// them anyway so they are not rejected here.
+	col := h.Layer - 1
+	if h.VersionID != MPEG1 {
+		col = 3
+		if h.Layer != 1 {
+			col = 4
+		}
+	}
+
+	switch h.Layer {
+	case 1:
+		h.SamplesPerFrame = 384
+	case 2:
+		h.SamplesPerFrame = 1152
+	default:
+		h.SamplesPerFrame = 1152
+		if h.VersionID != MPEG1 {
+			h.SamplesPerFrame = 576
+		}
+	}
+
+	if brIdx == 0 {
+		h.FreeFormat = true
+		return h, nil
+	}
+	h.Bitrate = utils.MP3BitrateTable[brIdx][col] * 1000
+	h.FrameLength = h.slotCount(h.Bitrate)*h.slotSize() + utils.BoolToInt(h.Padding)*h.slotSize()
+	return h, nil
+}
+
+// slotSize is 4 bytes for Layer I and 1 byte for Layers II and III.
+func (h *FrameHeader) slotSize() int {
+	if h.Layer == 1 {
+		return 4
+	}
+	return 1
+}
+
+// slotCount is the number of slots in an unpadded frame at bitrate br.
+func (h *FrameHeader) slotCount(br int) int {
+	return h.SamplesPerFrame / 8 * br / h.SampleRate / h.slotSize()
+}
+
+// SetFreeFormatSize fills in FrameLength and Bitrate of a free format frame
+// from the unpadded frame size of the stream.
+func (h *FrameHeader) SetFreeFormatSize(size int) {
+	h.FrameLength = size + utils.BoolToInt(h.Padding)*h.slotSize()
+	h.Bitrate = size * 8 * h.SampleRate / h.SamplesPerFrame
+}
+
+// SideInfoLength returns the size of the Layer III side information.
+func (h *FrameHeader) SideInfoLength() int {
+	if h.Layer != 3 {
+		return 0
+	}
+	mono := h.ChannelMode == Mono
+	switch {
+	case h.VersionID == MPEG1 && mono:
+		return 17
+	case h.VersionID == MPEG1:
+		return 32
+	case mono:
+		return 9
+	default:
+		return 17
+	}
+}
+
+// Channels returns the number of audio channels in the frame.
+func (h *FrameHeader) Channels() int {
+	if h.ChannelMode == Mono {
+		return 1
+	}
+	return 2
+}
+
+// sameStream reports whether two headers can belong to the same stream.
+func sameStream(a, b *FrameHeader) bool {
+	return a.VersionID == b.VersionID && a.Layer == b.Layer && a.SampleRate == b.SampleRate
+}
+
+// FreeFormatSize finds the unpadded size of the free format frame at the
+// start of data by looking for the sync word of the following frame, and
+// the one after it when the stream is long enough. It returns -1 if no
+// consistent size is found.
+func FreeFormatSize(data []byte, h *FrameHeader) int {
+	pad := utils.BoolToInt(h.Padding) * h.slotSize()
+	for j := 4 + pad + 1; j+4 <= len(data); j++ {
+		if data[j] != 0xFF {
+			continue
+		}
+		next, err := ParseHeader(data[j : j+4])
+		if err != nil || !next.FreeFormat || !sameStream(h, next) {
+			continue
+		}
+		size := j - pad
+		third := j + size + utils.BoolToInt(next.Padding)*next.slotSize()
+		if third+4 <= len(data) {
+			h3, err := ParseHeader(data[third : third+4])
+			if err != nil || !h3.FreeFormat || !sameStream(h, h3) {
+				continue
+			}
+		}
+		return size
+	}
+	return -1
+}
+
+// IsMP3 reports whether data holds an MPEG audio stream: after an optional
+// ID3v2 tag, a frame header within the first 4 KB followed by a second one
+// where the first frame ends.
+func IsMP3(data []byte) bool {
+	start := id3.Size(data)
+	end := min(len(data)-4, start+4096)
+	for i := start; i <= end; i++ {
+		if data[i] != 0xFF || data[i+1]&0xE0 != 0xE0 {
+			continue
+		}
+		h, err := ParseHeader(data[i : i+4])
+		if err != nil {
+			continue
+		}
+		if h.FreeFormat {
+			if FreeFormatSize(data[i:], h) > 0 {
+				return true
+			}
+			continue
+		}
+		next := i + h.FrameLength
+		if next == len(data) {
+			return true
+		}
+		if next+4 <= len(data) {
+			if _, err := ParseHeader(data[next : next+4]); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
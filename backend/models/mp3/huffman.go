@@ -0,0 +1,212 @@
+package mp3
+
+import (
+	"errors"
+	"fmt"
+)
+
+// huffTable is one of the 32 big value tables or one of the two count1
+// tables (32 and 33 here). Tables 16-23 and 24-31 share their codes and
+// only differ in the number of linbits.
+type huffTable struct {
+	xlen    int
+	linbits int
+	codes   []uint32
+	lens    []uint8
+	tree    [][2]int32 // decoding tree, leaves are stored as -(index+1)
+}
+
+var huffTables [34]*huffTable
+
+func init() {
+	add := func(t, xlen, linbits int, codes []uint32, lens []uint8) {
+		huffTables[t] = &huffTable{xlen: xlen, linbits: linbits, codes: codes, lens: lens}
+		huffTables[t].buildTree()
+	}
+	add(1, 2, 0, huffCodes1, huffLens1)
+	add(2, 3, 0, huffCodes2, huffLens2)
+	add(3, 3, 0, huffCodes3, huffLens3)
+	add(5, 4, 0, huffCodes5, huffLens5)
+	add(6, 4, 0, huffCodes6, huffLens6)
+	add(7, 6, 0, huffCodes7, huffLens7)
+	add(8, 6, 0, huffCodes8, huffLens8)
+	add(9, 6, 0, huffCodes9, huffLens9)
+	add(10, 8, 0, huffCodes10, huffLens10)
+	add(11, 8, 0, huffCodes11, huffLens11)
+	add(12, 8, 0, huffCodes12, huffLens12)
+	add(13, 16, 0, huffCodes13, huffLens13)
+	add(15, 16, 0, huffCodes15, huffLens15)
+	for t, lb := range []int{1, 2, 3, 4, 6, 8, 10, 13} {
+		add(16+t, 16, lb, huffCodes16, huffLens16)
+	}
+	for t, lb := range []int{4, 5, 6, 7, 8, 9, 11, 13} {
+		add(24+t, 16, lb, huffCodes24, huffLens24)
+	}
+	add(32, 0, 0, huffCodesA, huffLensA)
+	add(33, 0, 0, huffCodesB, huffLensB)
+}
+
+func (t *huffTable) buildTree() {
+	t.tree = [][2]int32{{0, 0}}
+	for i, code := range t.codes {
+		node := 0
+		for b := int(t.lens[i]) - 1; b >= 0; b-- {
+			bit := (code >> uint(b)) & 1
+			if b == 0 {
+				t.tree[node][bit] = int32(-(i + 1))
+				break
+			}
+			if t.tree[node][bit] == 0 {
+				t.tree = append(t.tree, [2]int32{})
+				t.tree[node][bit] = int32(len(t.tree) - 1)
+			}
+			node = int(t.tree[node][bit])
+		}
+	}
+}
+
+// maxValue is the largest magnitude the table can code.
+func (t *huffTable) maxValue() int {
+	if t.linbits > 0 {
+		return 15 + 1<<uint(t.linbits) - 1
+	}
+	return t.xlen - 1
+}
+
+func (t *huffTable) readCode(r *bitReader) (int, error) {
+	node := 0
+	for k := 0; k < 32; k++ {
+		next := t.tree[node][r.read(1)]
+		if next < 0 {
+			return int(-next - 1), nil
+		}
+		if next == 0 {
+			break
+		}
+		node = int(next)
+	}
+	return 0, errors.New("invalid Huffman code")
+}
+
+func huffTableFor(n int) (*huffTable, error) {
+	if n < 0 || n >= len(huffTables) || (n != 0 && huffTables[n] == nil) {
+		return nil, fmt.Errorf("invalid Huffman table %d", n)
+	}
+	return huffTables[n], nil
+}
+
+// decodePair reads one big value codeword with its linbits and sign bits.
+// Table 0 codes nothing and always yields zeros.
+func decodePair(r *bitReader, table int) (x, y int, err error) {
+	t, err := huffTableFor(table)
+	if err != nil || t == nil {
+		return 0, 0, err
+	}
+	idx, err := t.readCode(r)
+	if err != nil {
+		return 0, 0, fmt.Errorf("table %d: %v", table, err)
+	}
+	x, y = idx/t.xlen, idx%t.xlen
+	if t.linbits > 0 && x == 15 {
+		x += r.read(t.linbits)
+	}
+	if x != 0 && r.read(1) == 1 {
+		x = -x
+	}
+	if t.linbits > 0 && y == 15 {
+		y += r.read(t.linbits)
+	}
+	if y != 0 && r.read(1) == 1 {
+		y = -y
+	}
+	return x, y, nil
+}
+
+// decodeQuad reads one count1 codeword with its sign bits.
+func decodeQuad(r *bitReader, table int) (q [4]int, err error) {
+	idx, err := huffTables[32+table].readCode(r)
+	if err != nil {
+		return q, fmt.Errorf("count1 table %d: %v", table, err)
+	}
+	for k := 0; k < 4; k++ {
+		if idx>>(3-uint(k))&1 == 0 {
+			continue
+		}
+		q[k] = 1
+		if r.read(1) == 1 {
+			q[k] = -1
+		}
+	}
+	return q, nil
+}
+
+// split returns the table index and linbits value of one magnitude.
+func (t *huffTable) split(v int) (int, int) {
+	if t.linbits > 0 && v >= 15 {
+		return 15, v - 15
+	}
+	return v, 0
+}
+
+// pairLength returns the number of bits encodePair writes for x, y.
+func pairLength(table, x, y int) int {
+	t := huffTables[table]
+	if t == nil {
+		return 0
+	}
+	ax, ay := abs(x), abs(y)
+	ix, _ := t.split(ax)
+	iy, _ := t.split(ay)
+	n := int(t.lens[ix*t.xlen+iy])
+	for _, v := range []int{ix, iy} {
+		if v == 15 && t.linbits > 0 {
+			n += t.linbits
+		}
+	}
+	if ax != 0 {
+		n++
+	}
+	if ay != 0 {
+		n++
+	}
+	return n
+}
+
+// encodePair writes one big value codeword; it is the inverse of decodePair.
+func encodePair(w *bitWriter, table, x, y int) {
+	t := huffTables[table]
+	if t == nil {
+		return
+	}
+	ix, lx := t.split(abs(x))
+	iy, ly := t.split(abs(y))
+	i := ix*t.xlen + iy
+	w.write(int(t.codes[i]), int(t.lens[i]))
+	if ix == 15 && t.linbits > 0 {
+		w.write(lx, t.linbits)
+	}
+	if x != 0 {
+		w.write(sign(x), 1)
+	}
+	if iy == 15 && t.linbits > 0 {
+		w.write(ly, t.linbits)
+	}
+	if y != 0 {
+		w.write(sign(y), 1)
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// sign is the sign bit of a non-zero value: 1 for negative.
+func sign(v int) int {
+	if v < 0 {
+		return 1
+	}
+	return 0
+}
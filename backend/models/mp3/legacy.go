@@ -0,0 +1,61 @@
+package mp3
+
+import "bytes"
+
+// LegacyFrameData returns the data bytes of the frames the first releases
+// embedded version 1 payloads in, or nil when there are none. Their frame
+// walk only knew Layer III and sized every frame with the MPEG-1 formula
+// 144*bitrate/rate, taking the bitrate from the MPEG-1 table whatever the
+// version. The frames it finds are the same as Parse's for MPEG-1 streams,
+// but not for MPEG-2 and 2.5 ones.
+func LegacyFrameData(data []byte) []byte {
+	i, end := 0, len(data)
+	if len(data) >= 10 && bytes.Equal(data[0:3], []byte("ID3")) {
+		size := 0
+		for j := 0; j < 4; j++ {
+			size = size<<7 | int(data[6+j]&0x7F)
+		}
+		// The footer flag was not looked at
+		if 10+size <= len(data) {
+			i = 10 + size
+		}
+	}
+	if len(data) >= 128 && bytes.Equal(data[len(data)-128:len(data)-125], []byte("TAG")) {
+		end -= 128
+	}
+
+	var out []byte
+	for i+4 <= end {
+		if data[i] != 0xFF || data[i+1]&0xE0 != 0xE0 {
+			i++
+			continue
+		}
+		fl := legacyFrameLength(data[i : i+4])
+		if fl <= 0 || i+fl > end {
+			i++
+			continue
+		}
+		out = append(out, data[i+4:i+fl]...)
+		i += fl
+	}
+	return out
+}
+
+// legacyBitrates is the MPEG-1 Layer III bitrate table.
+var legacyBitrates = [15]int{0, 32000, 40000, 48000, 56000, 64000, 80000, 96000,
+	112000, 128000, 160000, 192000, 224000, 256000, 320000}
+
+func legacyFrameLength(h []byte) int {
+	br, sr := int(h[2]>>4), int(h[2]>>2&3)
+	if br == 0 || br == 15 || sr == 3 || h[1]>>1&3 != 1 {
+		return -1
+	}
+	rate := [3]int{44100, 48000, 32000}[sr]
+	switch h[1] >> 3 & 3 {
+	case 2:
+		rate /= 2
+	case 0:
+		rate /= 4
+	}
+	return 144*legacyBitrates[br]/rate + int(h[2]>>1&1)
+}
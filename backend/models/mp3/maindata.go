@@ -0,0 +1,714 @@
+package mp3
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Long block scalefactor band boundaries, used to find where the three
+// Huffman regions of the big_values area start.
+var (
+	sfBandLong44 = []int{0, 4, 8, 12, 16, 20, 24, 30, 36, 44, 52, 62, 74, 90, 110, 134, 162, 196, 238, 288, 342, 418, 576}
+	sfBandLong48 = []int{0, 4, 8, 12, 16, 20, 24, 30, 36, 42, 50, 60, 72, 88, 106, 128, 156, 190, 230, 276, 330, 384, 576}
+	sfBandLong32 = []int{0, 4, 8, 12, 16, 20, 24, 30, 36, 44, 54, 66, 82, 102, 126, 156, 194, 240, 296, 364, 448, 550, 576}
+	sfBandLong22 = []int{0, 6, 12, 18, 24, 30, 36, 44, 54, 66, 80, 96, 116, 140, 168, 200, 238, 284, 336, 396, 464, 522, 576}
+	sfBandLong24 = []int{0, 6, 12, 18, 24, 30, 36, 44, 54, 66, 80, 96, 114, 136, 162, 194, 232, 278, 332, 394, 464, 540, 576}
+	sfBandLong8  = []int{0, 12, 24, 36, 48, 60, 72, 88, 108, 132, 160, 192, 232, 280, 336, 400, 476, 566, 568, 570, 572, 574, 576}
+)
+
+func sfBandLong(rate int) []int {
+	switch rate {
+	case 44100:
+		return sfBandLong44
+	case 48000:
+		return sfBandLong48
+	case 32000:
+		return sfBandLong32
+	case 24000:
+		return sfBandLong24
+	case 8000:
+		return sfBandLong8
+	default: // 22050, 16000, 12000, 11025
+		return sfBandLong22
+	}
+}
+
+// MPEG-1 scalefactor bit lengths (slen1, slen2) by scalefac_compress.
+var slenMPEG1 = [16][2]int{
+	{0, 0}, {0, 1}, {0, 2}, {0, 3}, {3, 0}, {1, 1}, {1, 2}, {1, 3},
+	{2, 1}, {2, 2}, {2, 3}, {3, 1}, {3, 2}, {3, 3}, {4, 2}, {4, 3},
+}
+
+// MPEG-2 number of scalefactor bands per slen group, by block kind (long,
+// short, mixed) and the table chosen by scalefac_compress.
+var nrOfSfbMPEG2 = [3][6][4]int{
+	{{6, 5, 5, 5}, {6, 5, 7, 3}, {11, 10, 0, 0}, {7, 7, 7, 0}, {6, 6, 6, 3}, {8, 8, 5, 0}},
+	{{9, 9, 9, 9}, {9, 9, 12, 6}, {18, 18, 0, 0}, {12, 12, 12, 0}, {12, 9, 9, 6}, {15, 12, 9, 0}},
+	{{6, 9, 9, 9}, {6, 9, 12, 6}, {15, 18, 0, 0}, {6, 15, 12, 0}, {6, 12, 9, 6}, {6, 18, 9, 0}},
+}
+
+// part2Length returns the number of scalefactor bits at the start of a
+// granule's main data.
+func part2Length(h *FrameHeader, s *SideInfo, gr, ch int) int {
+	g := &s.Gr[gr][ch]
+	short := g.WindowSwitching && g.BlockType == 2
+
+	if h.VersionID == MPEG1 {
+		slen1, slen2 := slenMPEG1[g.ScalefacCompress][0], slenMPEG1[g.ScalefacCompress][1]
+		if short {
+			if g.MixedBlock {
+				return 17*slen1 + 18*slen2
+			}
+			return 18*slen1 + 18*slen2
+		}
+		n := 0
+		for band, size := range [4]int{6 * slen1, 5 * slen1, 5 * slen2, 5 * slen2} {
+			if gr == 0 || !s.Scfsi[ch][band] {
+				n += size
+			}
+		}
+		return n
+	}
+
+	// MPEG-2/2.5: the right channel of intensity stereo uses other tables
+	var slen [4]int
+	table := 0
+	sfc := g.ScalefacCompress
+	if h.ChannelMode == JointStereo && h.ModeExtension&1 != 0 && ch == 1 {
+		sfc >>= 1
+		switch {
+		case sfc < 180:
+			slen, table = [4]int{sfc / 36, sfc % 36 / 6, sfc % 36 % 6, 0}, 3
+		case sfc < 244:
+			sfc -= 180
+			slen, table = [4]int{sfc & 63 >> 4, sfc & 15 >> 2, sfc & 3, 0}, 4
+		default:
+			sfc -= 244
+			slen, table = [4]int{sfc / 3, sfc % 3, 0, 0}, 5
+		}
+	} else {
+		switch {
+		case sfc < 400:
+			slen, table = [4]int{sfc >> 4 / 5, sfc >> 4 % 5, sfc & 15 >> 2, sfc & 3}, 0
+		case sfc < 500:
+			sfc -= 400
+			slen, table = [4]int{sfc >> 2 / 5, sfc >> 2 % 5, sfc & 3, 0}, 1
+		default:
+			sfc -= 500
+			slen, table = [4]int{sfc / 3, sfc % 3, 0, 0}, 2
+		}
+	}
+	kind := 0
+	if short {
+		kind = 1
+		if g.MixedBlock {
+			kind = 2
+		}
+	}
+	n := 0
+	for k := 0; k < 4; k++ {
+		n += nrOfSfbMPEG2[kind][table][k] * slen[k]
+	}
+	return n
+}
+
+// regions returns the first coefficient of Huffman regions 1 and 2.
+func regions(h *FrameHeader, g *GranuleInfo) (int, int) {
+	if g.WindowSwitching && g.BlockType == 2 {
+		// Three short windows of the first three bands, no region 2
+		if h.SampleRate == 8000 && !g.MixedBlock {
+			return 72, 576
+		}
+		return 36, 576
+	}
+	l := sfBandLong(h.SampleRate)
+	i := g.Region0Count + 1
+	j := g.Region0Count + g.Region1Count + 2
+	if i > len(l)-1 {
+		i = len(l) - 1
+	}
+	if j > len(l)-1 {
+		j = len(l) - 1
+	}
+	return l[i], l[j]
+}
+
+// Granule is the Huffman-decoded main data of one granule of one channel.
+// Only the big_values coefficients are kept decoded; scalefactors and the
+// count1 region are carried as raw bits so they are written back as is.
+type Granule struct {
+	Info   *GranuleInfo
+	Big    []int // quantized big_values coefficients, two per codeword
+	Count1 int   // coefficients coded in the count1 region
+	tables []int // Huffman table of each big value pair
+	part2  []byte
+	part2n int
+	tail   []byte // count1 codewords and stuffing bits
+	tailn  int
+	used   int  // tail bits up to the last non-zero count1 quadruple
+	r1, r2 int  // first coefficient of regions 1 and 2
+	dirty  bool // coefficients changed since decoding
+}
+
+// MainData is the decoded main data of every Layer III audio frame.
+type MainData struct {
+	Granules  [][]*Granule // per audio frame, in granule then channel order; nil for skipped frames
+	Repacked  bool         // set by Repack when the original layout did not fit
+	side      []*SideInfo
+	areaStart []int // start of each frame's main data area in the reservoir
+	areaLen   []int
+	start     []int // start of each frame's main data in the reservoir
+	size      []int // bytes of main data of each frame
+	reservoir []byte
+}
+
+// DecodeMainData Huffman-decodes the main data of every audio frame,
+// following main_data_begin back through the bit reservoir. Frames at the
+// start of a cut stream whose main data lies before the first frame are
+// skipped, like decoders do, and written back untouched.
+func (f *File) DecodeMainData() (*MainData, error) {
+	frames := f.AudioFrames()
+	m := &MainData{}
+	for i, fr := range frames {
+		if fr.Header.Layer != 3 {
+			return nil, fmt.Errorf("frame %d is not Layer III", i)
+		}
+		si, err := ParseSideInfo(fr.Header, fr.Data)
+		if err != nil {
+			return nil, fmt.Errorf("frame %d: %v", i, err)
+		}
+		off := fr.Header.MainDataOffset()
+		m.side = append(m.side, si)
+		m.areaStart = append(m.areaStart, len(m.reservoir))
+		m.areaLen = append(m.areaLen, len(fr.Data)-off)
+		m.reservoir = append(m.reservoir, fr.Data[off:]...)
+	}
+
+	for i, fr := range frames {
+		h, si := fr.Header, m.side[i]
+		start := m.areaStart[i] - si.MainDataBegin
+		m.start = append(m.start, start)
+		m.size = append(m.size, si.MainDataLength(h.Channels()))
+		if start < 0 {
+			if i > 0 && m.Granules[i-1] != nil {
+				return nil, fmt.Errorf("frame %d: main_data_begin points before the first frame", i)
+			}
+			m.Granules = append(m.Granules, nil)
+			continue
+		}
+
+		r := &bitReader{data: m.reservoir, pos: start * 8}
+		var grs []*Granule
+		for gr := 0; gr < si.Granules; gr++ {
+			for ch := 0; ch < h.Channels(); ch++ {
+				g, err := decodeGranule(r, h, si, gr, ch)
+				if err != nil {
+					return nil, fmt.Errorf("frame %d granule %d channel %d: %v", i, gr, ch, err)
+				}
+				grs = append(grs, g)
+			}
+		}
+		if r.pos > (m.areaStart[i]+m.areaLen[i])*8 {
+			return nil, fmt.Errorf("frame %d: main data overruns the frame", i)
+		}
+		m.Granules = append(m.Granules, grs)
+	}
+	return m, nil
+}
+
+func decodeGranule(r *bitReader, h *FrameHeader, si *SideInfo, gr, ch int) (*Granule, error) {
+	info := &si.Gr[gr][ch]
+	g := &Granule{Info: info}
+	start := r.pos
+	end := start + info.Part23Length
+
+	g.part2n = part2Length(h, si, gr, ch)
+	if g.part2n > info.Part23Length {
+		return nil, errors.New("scalefactors overrun part2_3_length")
+	}
+	if info.BigValues*2 > 576 {
+		return nil, errors.New("big_values out of range")
+	}
+	w := &bitWriter{}
+	w.copyBits(r.data, start, g.part2n)
+	g.part2 = w.data
+	r.pos += g.part2n
+
+	g.r1, g.r2 = regions(h, info)
+	for k := 0; k < info.BigValues; k++ {
+		table := info.TableSelect[g.region(2*k)]
+		x, y, err := decodePair(r, table)
+		if err != nil {
+			return nil, err
+		}
+		g.Big = append(g.Big, x, y)
+		g.tables = append(g.tables, table)
+	}
+	if r.pos > end {
+		return nil, errors.New("big_values overrun part2_3_length")
+	}
+
+	// The count1 region runs to the end of part2_3_length; a final quadruple
+	// that crosses the end is discarded, as decoders do.
+	w = &bitWriter{}
+	w.copyBits(r.data, r.pos, end-r.pos)
+	g.tail, g.tailn = w.data, w.n
+	tailStart := r.pos
+	for r.pos < end && info.BigValues*2+g.Count1 < 576 {
+		q, err := decodeQuad(r, info.Count1TableSelect)
+		if err != nil {
+			return nil, err
+		}
+		if r.pos > end {
+			break
+		}
+		g.Count1 += 4
+		if q != [4]int{} {
+			g.used = r.pos - tailStart
+		}
+	}
+	r.pos = end
+	return g, nil
+}
+
+// region returns the Huffman region of big value coefficient k.
+func (g *Granule) region(k int) int {
+	switch {
+	case k < g.r1:
+		return 0
+	case k < g.r2:
+		return 1
+	}
+	return 2
+}
+
+// selectTables picks the cheapest Huffman table for every region, as an
+// encoder does. A table can code a region if its largest value fits.
+func (g *Granule) selectTables() {
+	for region := 0; region < 3; region++ {
+		var pairs []int
+		peak := 0
+		for k := range g.tables {
+			if g.region(2*k) == region {
+				pairs = append(pairs, k)
+				if v := max(abs(g.Big[2*k]), abs(g.Big[2*k+1])); v > peak {
+					peak = v
+				}
+			}
+		}
+		if len(pairs) == 0 {
+			continue
+		}
+		best, bestLen := 0, -1
+		if peak > 0 {
+			for t := 1; t < 32; t++ {
+				if huffTables[t] == nil || huffTables[t].maxValue() < peak {
+					continue
+				}
+				n := 0
+				for _, k := range pairs {
+					n += pairLength(t, g.Big[2*k], g.Big[2*k+1])
+				}
+				if bestLen < 0 || n < bestLen {
+					best, bestLen = t, n
+				}
+			}
+		}
+		for _, k := range pairs {
+			g.tables[k] = best
+		}
+		g.Info.TableSelect[region] = best
+	}
+}
+
+// encode writes the granule back and returns its part2_3_length.
+func (g *Granule) encode(w *bitWriter) int {
+	n := w.n
+	w.copyBits(g.part2, 0, g.part2n)
+	for k, table := range g.tables {
+		encodePair(w, table, g.Big[2*k], g.Big[2*k+1])
+	}
+	w.copyBits(g.tail, 0, g.tailn)
+	return w.n - n
+}
+
+// eligible reports whether big value coefficient k can carry a parity bit.
+// Only non-zero coefficients do, and embedding never makes them zero, so
+// the decoder finds the same coefficients.
+func (g *Granule) eligible(k int) bool {
+	return g.Big[k] != 0
+}
+
+// setParity moves coefficient k one step so that its magnitude has the
+// given parity, choosing the neighbour with the shorter codeword. A 1 in a
+// region coded with table 1 becomes 2 and the region gets a new table.
+func (g *Granule) setParity(k int, parity int) {
+	v := abs(g.Big[k])
+	if v&1 == parity {
+		return
+	}
+	g.dirty = true
+	table := g.tables[k/2]
+	best, bestLen := 0, 0
+	for _, c := range []int{v - 1, v + 1} {
+		if c < 1 || c > huffTables[table].maxValue() {
+			continue
+		}
+		x, y := g.Big[k&^1], g.Big[k|1]
+		if k&1 == 0 {
+			x = c
+		} else {
+			y = c
+		}
+		if n := pairLength(table, x, y); best == 0 || n < bestLen {
+			best, bestLen = c, n
+		}
+	}
+	if best == 0 {
+		best = v + 1
+	}
+	if g.Big[k] < 0 {
+		best = -best
+	}
+	g.Big[k] = best
+}
+
+// each calls fn for every coefficient that can carry a bit. Coefficients
+// are visited round-robin over the frames (the first eligible coefficient
+// of every frame, then the second, ...) so that even a short sequential
+// payload spreads its codeword growth over the whole bit reservoir.
+func (m *MainData) each(fn func(g *Granule, k int)) {
+	units := make([][]uint16, len(m.Granules))
+	rounds := 0
+	for i, grs := range m.Granules {
+		for j, g := range grs {
+			for k := range g.Big {
+				if g.eligible(k) {
+					units[i] = append(units[i], uint16(j*576+k))
+				}
+			}
+		}
+		if len(units[i]) > rounds {
+			rounds = len(units[i])
+		}
+	}
+	for r := 0; r < rounds; r++ {
+		for i, u := range units {
+			if r < len(u) {
+				fn(m.Granules[i][u[r]/576], int(u[r]%576))
+			}
+		}
+	}
+}
+
+// Parities returns the parity of every coefficient that can carry a bit.
+func (m *MainData) Parities() []byte {
+	var p []byte
+	m.each(func(g *Granule, k int) {
+		p = append(p, byte(abs(g.Big[k])&1))
+	})
+	return p
+}
+
+// SetParities changes the coefficients whose parity differs from p, the
+// inverse of Parities. It returns the number of coefficients changed.
+func (m *MainData) SetParities(p []byte) int {
+	i, changed := 0, 0
+	m.each(func(g *Granule, k int) {
+		if i >= len(p) {
+			return
+		}
+		if abs(g.Big[k])&1 != int(p[i]&1) {
+			g.setParity(k, int(p[i]&1))
+			changed++
+		}
+		i++
+	})
+	return changed
+}
+
+// Coefficients returns every big_values coefficient in frame order.
+func (m *MainData) Coefficients() []int {
+	var c []int
+	for _, grs := range m.Granules {
+		for _, g := range grs {
+			c = append(c, g.Big...)
+		}
+	}
+	return c
+}
+
+// Repack Huffman-encodes the main data back into the audio frames and
+// updates the side information and the CRC of each frame. Changed granules
+// get new Huffman tables and region boundaries. Frames keep their position
+// in the bit reservoir while everything still fits; otherwise the whole
+// stream is repacked as tightly as main_data_begin allows, after
+// re-selecting the tables of every granule and dropping trailing zero
+// count1 quadruples and stuffing bits.
+func (f *File) Repack(m *MainData) error {
+	frames := f.AudioFrames()
+	if len(frames) != len(m.Granules) {
+		return errors.New("main data does not belong to this file")
+	}
+
+	for i, grs := range m.Granules {
+		for _, g := range grs {
+			if g.dirty {
+				g.retable(frames[i].Header)
+			}
+		}
+	}
+	bits, err := m.encode()
+	if err != nil {
+		return err
+	}
+	start, ok := m.layout(frames, bits, false)
+	res := append([]byte(nil), m.reservoir...)
+	if !ok {
+		for i, grs := range m.Granules {
+			for _, g := range grs {
+				g.retable(frames[i].Header)
+				g.tailn = g.used
+			}
+		}
+		if bits, err = m.encode(); err != nil {
+			return err
+		}
+		if start, ok = m.layout(frames, bits, true); !ok {
+			return errors.New("main data does not fit in the bit reservoir")
+		}
+		m.Repacked = true
+		res = make([]byte, len(m.reservoir))
+		copy(res, m.reservoir[:m.skipped()])
+	}
+
+	for i, fr := range frames {
+		if m.Granules[i] == nil {
+			continue
+		}
+		// Main data starts on a byte boundary; the bits after its end in the
+		// last byte are left as they were
+		w := bits[i]
+		copy(res[start[i]:], w.data[:w.n/8])
+		if rest := w.n & 7; rest != 0 {
+			putBits(res, (start[i]+w.n/8)*8, rest, int(w.data[w.n/8])>>uint(8-rest))
+		}
+
+		si := m.side[i]
+		si.MainDataBegin = m.areaStart[i] - start[i]
+		m.writeSideInfo(fr, si, m.Granules[i])
+	}
+
+	// Later frames borrow from earlier areas, so copy back only at the end
+	for i, fr := range frames {
+		copy(fr.Data[fr.Header.MainDataOffset():], res[m.areaStart[i]:m.areaStart[i]+m.areaLen[i]])
+	}
+	m.start = start
+	m.reservoir = res
+	for _, grs := range m.Granules {
+		for _, g := range grs {
+			g.dirty = false
+		}
+	}
+	return nil
+}
+
+// encode Huffman-encodes the main data of every frame.
+func (m *MainData) encode() ([]*bitWriter, error) {
+	bits := make([]*bitWriter, len(m.Granules))
+	for i, grs := range m.Granules {
+		if grs == nil {
+			continue
+		}
+		w := &bitWriter{}
+		for _, g := range grs {
+			n := g.encode(w)
+			if n > 4095 {
+				return nil, fmt.Errorf("frame %d: granule exceeds part2_3_length", i)
+			}
+			g.Info.Part23Length = n
+		}
+		bits[i] = w
+	}
+	return bits, nil
+}
+
+// writeSideInfo stores main_data_begin, part2_3_length, table_select and
+// the region counts in the side information of a frame and recomputes its CRC.
+func (m *MainData) writeSideInfo(fr *Frame, si *SideInfo, grs []*Granule) {
+	h := fr.Header
+	side := fr.Data[h.MainDataOffset()-h.SideInfoLength():]
+	nch := h.Channels()
+
+	// Bit offsets of the first granule and of table_select inside it
+	var base, stride, tables int
+	if h.VersionID == MPEG1 {
+		putBits(side, 0, 9, si.MainDataBegin)
+		base, stride, tables = 9+5+4, 59, 12+9+8+4+1
+		if nch == 2 {
+			base = 9 + 3 + 8
+		}
+	} else {
+		putBits(side, 0, 8, si.MainDataBegin)
+		base, stride, tables = 8+nch, 63, 12+9+8+9+1
+	}
+	for i, g := range grs {
+		pos := base + i*stride
+		putBits(side, pos, 12, g.Info.Part23Length)
+		n := 3
+		if g.Info.WindowSwitching {
+			pos += 3
+			n = 2
+		}
+		for r := 0; r < n; r++ {
+			putBits(side, pos+tables+r*5, 5, g.Info.TableSelect[r])
+		}
+		if !g.Info.WindowSwitching {
+			putBits(side, pos+tables+15, 4, g.Info.Region0Count)
+			putBits(side, pos+tables+19, 3, g.Info.Region1Count)
+		}
+	}
+	fr.UpdateCRC()
+}
+
+// skipped returns the end of the main data of the skipped frames at the
+// start of the stream, which must stay where it is.
+func (m *MainData) skipped() int {
+	end := 0
+	for i, grs := range m.Granules {
+		if grs != nil {
+			break
+		}
+		if e := m.start[i] + m.size[i]; e > end {
+			end = e
+		}
+	}
+	return end
+}
+
+// layout places each frame's main data in the reservoir. Without tight it
+// keeps the original start whenever possible.
+func (m *MainData) layout(frames []*Frame, bits []*bitWriter, tight bool) ([]int, bool) {
+	start := make([]int, len(frames))
+	end := m.skipped()
+	for i, fr := range frames {
+		if m.Granules[i] == nil {
+			start[i] = m.start[i]
+			continue
+		}
+		maxBegin := 511
+		if fr.Header.VersionID != MPEG1 {
+			maxBegin = 255
+		}
+		pos := m.areaStart[i] - maxBegin
+		if !tight {
+			pos = m.start[i]
+		}
+		if pos < end {
+			pos = end
+		}
+		if pos < 0 {
+			pos = 0
+		}
+		size := (bits[i].n + 7) / 8
+		if pos > m.areaStart[i] || m.areaStart[i]-pos > maxBegin || pos+size > m.areaStart[i]+m.areaLen[i] {
+			return nil, false
+		}
+		start[i] = pos
+		end = pos + size
+	}
+	return start, true
+}
+
+// retable picks new Huffman tables for the granule, and new region
+// boundaries for long blocks.
+func (g *Granule) retable(h *FrameHeader) {
+	if g.Info.WindowSwitching {
+		g.selectTables()
+		return
+	}
+	g.optimizeRegions(h)
+}
+
+// optimizeRegions picks region0_count and region1_count, together with the
+// tables of the three regions, so that the big_values area is as short as
+// possible. It only applies to long blocks without window switching.
+func (g *Granule) optimizeRegions(h *FrameHeader) {
+	bands := sfBandLong(h.SampleRate)
+	n := len(g.Big)
+
+	// Per table, prefix sums of the bits of each scalefactor band and the
+	// largest value in each band
+	nb := len(bands) - 1
+	var cost [32][]int
+	peak := make([]int, nb+1)
+	for t := 1; t < 32; t++ {
+		if huffTables[t] == nil {
+			continue
+		}
+		cost[t] = make([]int, nb+1)
+	}
+	for b := 0; b < nb; b++ {
+		m := 0
+		for k := bands[b]; k < bands[b+1] && k < n; k++ {
+			m = max(m, abs(g.Big[k]))
+		}
+		peak[b+1] = m
+		for t := 1; t < 32; t++ {
+			if cost[t] == nil {
+				continue
+			}
+			c := 0
+			if m > huffTables[t].maxValue() {
+				c = 1 << 20 // cannot code this band, best skips the table
+			} else {
+				for k := bands[b]; k < bands[b+1] && k < n; k += 2 {
+					c += pairLength(t, g.Big[k], g.Big[k+1])
+				}
+			}
+			cost[t][b+1] = cost[t][b] + c
+		}
+	}
+
+	// best returns the cheapest table for bands [b0, b1)
+	best := func(b0, b1 int) (int, int) {
+		m := 0
+		for b := b0 + 1; b <= b1; b++ {
+			m = max(m, peak[b])
+		}
+		if m == 0 || b0 >= b1 {
+			return 0, 0
+		}
+		bt, bn := 0, -1
+		for t := 1; t < 32; t++ {
+			if cost[t] == nil || huffTables[t].maxValue() < m {
+				continue
+			}
+			if c := cost[t][b1] - cost[t][b0]; bn < 0 || c < bn {
+				bt, bn = t, c
+			}
+		}
+		return bt, bn
+	}
+
+	bestBits := -1
+	var bestR0, bestR1 int
+	var bestT [3]int
+	for r0 := 0; r0 < 16; r0++ {
+		for r1 := 0; r1 < 8; r1++ {
+			i, j := min(r0+1, nb), min(r0+r1+2, nb)
+			t0, c0 := best(0, i)
+			t1, c1 := best(i, j)
+			t2, c2 := best(j, nb)
+			if c := c0 + c1 + c2; bestBits < 0 || c < bestBits {
+				bestBits, bestR0, bestR1, bestT = c, r0, r1, [3]int{t0, t1, t2}
+			}
+		}
+	}
+	g.Info.Region0Count, g.Info.Region1Count = bestR0, bestR1
+	g.r1, g.r2 = regions(h, g.Info)
+	g.Info.TableSelect = bestT
+	for k := range g.tables {
+		g.tables[k] = bestT[g.region(2*k)]
+	}
+}
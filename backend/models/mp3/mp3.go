@@ -3,17 +3,26 @@ package mp3
 import (
 	"bytes"
 	"errors"
+
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/id3"
 )
 
 type FrameHeader struct {
-    VersionID   int
-    Layer       int
-    Protection  bool
-    Bitrate     int
-    SampleRate  int
-    Padding     bool
-    ChannelMode int
-    FrameLength int
+    VersionID       int  // raw version bits: MPEG1, MPEG2 or MPEG25
+    Layer           int  // 1, 2 or 3
+    Protection      bool // true when a CRC-16 follows the header
+    Bitrate         int  // bits per second
+    SampleRate      int
+    Padding         bool
+    Private         bool
+    ChannelMode     int
+    ModeExtension   int
+    Copyright       bool
+    Original        bool
+    Emphasis        int
+    FreeFormat      bool
+    SamplesPerFrame int
+    FrameLength     int
 }
 
 type Frame struct {
@@ -26,22 +35,16 @@ type File struct {
     ID3v2  []byte
     Frames []*Frame
     ID3v1  []byte
+    Tag    *VBRTag // Xing/Info/VBRI tag in the first frame, if any
 }
 
 func Parse(data []byte) (*File, error) {
     f := &File{}
     i := 0
     
-    if len(data) >= 10 && bytes.Equal(data[0:3], []byte("ID3")) {
-        size := 0
-        for j := 0; j < 4; j++ {
-            size = (size << 7) | int(data[6+j]&0x7F)
-        }
-        if 10+size <= len(data) {
-            f.ID3v2 = make([]byte, 10+size)
-            copy(f.ID3v2, data[:10+size])
-            i = 10 + size
-        }
+    if n := id3.Size(data); n > 0 {
+        f.ID3v2 = append([]byte(nil), data[:n]...)
+        i = n
     }
     
     if len(data) >= 128 && bytes.Equal(data[len(data)-128:len(data)-125], []byte("TAG")) {
@@ -55,15 +58,33 @@ func Parse(data []byte) (*File, error) {
     }
     
     // Parse MP3 frames
+    freeSize := 0
     for i+4 <= max {
         if data[i] != 0xFF || (data[i+1]&0xE0) != 0xE0 {
             i++
             continue
         }
         
-        // Calculate frame length
-        fl := frameLen(data[i:])
-        if fl <= 0 || i+fl > max {
+        h, err := ParseHeader(data[i : i+4])
+        if err != nil {
+            i++
+            continue
+        }
+        
+        // Free format streams keep one frame size, found from the first frame
+        if h.FreeFormat {
+            if freeSize <= 0 {
+                freeSize = FreeFormatSize(data[i:max], h)
+            }
+            if freeSize <= 0 {
+                i++
+                continue
+            }
+            h.SetFreeFormatSize(freeSize)
+        }
+        
+        fl := h.FrameLength
+        if fl <= 4 || i+fl > max {
             i++
             continue
         }
@@ -74,7 +95,7 @@ func Parse(data []byte) (*File, error) {
         
         // Create frame with data
         fr := &Frame{
-            Header:      &FrameHeader{FrameLength: fl},
+            Header:      h,
             HeaderBytes: fh,
             Data:        append([]byte(nil), data[i+4:i+fl]...),
         }
@@ -87,52 +108,15 @@ func Parse(data []byte) (*File, error) {
         return nil, errors.New("no frames")
     }
     
+    f.DetectTag()
+    
     return f, nil
 }
 
 
-func frameLen(h []byte) int {
-    if len(h) < 4 {
-        return -1
-    }
-    
-    brIdx := int((h[2] >> 4) & 0x0F)
-    srIdx := int((h[2] >> 2) & 0x03)
-    
-    if brIdx == 0 || brIdx == 15 || srIdx == 3 {
-        return -1
-    }
-    
-    pad := (h[2] >> 1) & 1
-    ver := (h[1] >> 3) & 3
-    lay := (h[1] >> 1) & 3
-    
-    if lay != 1 {
-        return -1
-    }
-    
-    // Bitrate table (Layer III)
-    brTab := []int{0, 32000, 40000, 48000, 56000, 64000, 80000, 96000, 
-        112000, 128000, 160000, 192000, 224000, 256000, 320000}
-    
-    // Sample rate table
-    srTab := []int{44100, 48000, 32000}
-    
-    sr := srTab[srIdx]
-    if ver == 2 {
-        sr /= 2
-    } else if ver == 0 {
-        sr /= 4
-    }
-    
-    if sr == 0 {
-        return -1
-    }
-    
-    return (144 * brTab[brIdx] / sr) + int(pad)
-}
-
 func Serialize(f *File) []byte {
+    f.updateTagCRC()
+    
     var out []byte
     
     if len(f.ID3v2) > 0 {
@@ -0,0 +1,131 @@
+package mp3
+
+import "errors"
+
+// GranuleInfo is the side information of one granule of one channel.
+type GranuleInfo struct {
+	Part23Length      int // bits of scalefactors and Huffman data
+	BigValues         int
+	GlobalGain        int
+	ScalefacCompress  int
+	WindowSwitching   bool
+	BlockType         int
+	MixedBlock        bool
+	TableSelect       [3]int
+	SubblockGain      [3]int
+	Region0Count      int
+	Region1Count      int
+	Preflag           bool
+	ScalefacScale     bool
+	Count1TableSelect int
+}
+
+// SideInfo is the Layer III side information that follows the header (and
+// the CRC word, when present). MPEG-2 and 2.5 frames have a single granule.
+type SideInfo struct {
+	MainDataBegin int // bytes of main data taken from the bit reservoir
+	PrivateBits   int
+	Scfsi         [2][4]bool
+	Granules      int
+	Gr            [2][2]GranuleInfo // [granule][channel]
+}
+
+// MainDataOffset returns where the main data area starts inside Frame.Data,
+// after the CRC word and the side information.
+func (h *FrameHeader) MainDataOffset() int {
+	off := h.SideInfoLength()
+	if h.Protection {
+		off += 2
+	}
+	return off
+}
+
+// MainDataLength returns the number of main data bytes the frame's granules
+// use, rounded up to whole bytes.
+func (s *SideInfo) MainDataLength(channels int) int {
+	bits := 0
+	for gr := 0; gr < s.Granules; gr++ {
+		for ch := 0; ch < channels; ch++ {
+			bits += s.Gr[gr][ch].Part23Length
+		}
+	}
+	return (bits + 7) / 8
+}
+
+// ParseSideInfo decodes the Layer III side information of a frame. data is
+// Frame.Data, i.e. everything after the 4-byte header.
+func ParseSideInfo(h *FrameHeader, data []byte) (*SideInfo, error) {
+	if h.Layer != 3 {
+		return nil, errors.New("side information only exists in Layer III")
+	}
+	start := h.MainDataOffset() - h.SideInfoLength()
+	if len(data) < h.MainDataOffset() {
+		return nil, errors.New("frame too short for side information")
+	}
+
+	r := &bitReader{data: data[start:h.MainDataOffset()]}
+	nch := h.Channels()
+	lsf := h.VersionID != MPEG1
+	s := &SideInfo{Granules: 2}
+
+	if lsf {
+		s.Granules = 1
+		s.MainDataBegin = r.read(8)
+		s.PrivateBits = r.read(nch)
+	} else {
+		s.MainDataBegin = r.read(9)
+		if nch == 1 {
+			s.PrivateBits = r.read(5)
+		} else {
+			s.PrivateBits = r.read(3)
+		}
+		for ch := 0; ch < nch; ch++ {
+			for band := 0; band < 4; band++ {
+				s.Scfsi[ch][band] = r.read(1) == 1
+			}
+		}
+	}
+
+	for gr := 0; gr < s.Granules; gr++ {
+		for ch := 0; ch < nch; ch++ {
+			g := &s.Gr[gr][ch]
+			g.Part23Length = r.read(12)
+			g.BigValues = r.read(9)
+			g.GlobalGain = r.read(8)
+			if lsf {
+				g.ScalefacCompress = r.read(9)
+			} else {
+				g.ScalefacCompress = r.read(4)
+			}
+			g.WindowSwitching = r.read(1) == 1
+			if g.WindowSwitching {
+				g.BlockType = r.read(2)
+				g.MixedBlock = r.read(1) == 1
+				for k := 0; k < 2; k++ {
+					g.TableSelect[k] = r.read(5)
+				}
+				for k := 0; k < 3; k++ {
+					g.SubblockGain[k] = r.read(3)
+				}
+				// Region counts are implicit for switched windows
+				g.Region0Count = 7
+				if g.BlockType == 2 && !g.MixedBlock {
+					g.Region0Count = 8
+				}
+				g.Region1Count = 20 - g.Region0Count
+			} else {
+				for k := 0; k < 3; k++ {
+					g.TableSelect[k] = r.read(5)
+				}
+				g.Region0Count = r.read(4)
+				g.Region1Count = r.read(3)
+			}
+			if !lsf {
+				g.Preflag = r.read(1) == 1
+			}
+			g.ScalefacScale = r.read(1) == 1
+			g.Count1TableSelect = r.read(1)
+		}
+	}
+	return s, nil
+}
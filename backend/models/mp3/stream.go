@@ -0,0 +1,229 @@
+package mp3
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/id3"
+)
+
+// StreamBuffer is the read-ahead of a Reader. Frames and the free format
+// search must fit in it.
+const StreamBuffer = 1 << 16
+
+// Reader reads an MP3 stream one frame at a time. It skips the same junk
+// Parse does, so writing the frames back through a Writer gives the output
+// of Serialize.
+type Reader struct {
+	ID3v2  []byte
+	ID3v1  []byte  // set once Next returned io.EOF
+	Tag    *VBRTag // Xing/Info/VBRI tag, set by the first call to Next
+	Frames int     // frames read so far
+
+	br       *bufio.Reader
+	freeSize int
+	music    uint16 // LAME music CRC of the frames after the first
+}
+
+// NewReader reads the ID3v2 tag at the start of r, if any.
+func NewReader(r io.Reader) (*Reader, error) {
+	rd := &Reader{br: bufio.NewReaderSize(r, StreamBuffer)}
+	head, err := rd.br.Peek(10)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if n := id3.HeaderSize(head); n > 0 {
+		rd.ID3v2 = make([]byte, n)
+		if _, err := io.ReadFull(rd.br, rd.ID3v2); err != nil {
+			return nil, fmt.Errorf("failed to read ID3v2 tag: %v", err)
+		}
+	}
+	return rd, nil
+}
+
+// peek returns up to n bytes ahead. Once the end of the stream is in view
+// a trailing ID3v1 tag is left out, as Parse leaves it out of the frame
+// search.
+func (r *Reader) peek(n int) ([]byte, error) {
+	b, err := r.br.Peek(n + 128)
+	if err == nil {
+		return b[:n], nil
+	}
+	if err != io.EOF {
+		return nil, err
+	}
+	if len(b) >= 128 && bytes.Equal(b[len(b)-128:len(b)-125], []byte("TAG")) {
+		b = b[:len(b)-128]
+	}
+	return b[:min(n, len(b))], nil
+}
+
+// skip drops bytes up to the next possible frame sync.
+func (r *Reader) skip() error {
+	b, err := r.peek(4096)
+	if err != nil {
+		return err
+	}
+	k := bytes.IndexByte(b[1:], 0xFF)
+	if k < 0 {
+		k = len(b) - 1
+	}
+	_, err = r.br.Discard(k + 1)
+	return err
+}
+
+// Next returns the next frame, or io.EOF after the last one.
+func (r *Reader) Next() (*Frame, error) {
+	for {
+		b, err := r.peek(4)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) < 4 {
+			return nil, r.finish()
+		}
+		if b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+			if err := r.skip(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		h, err := ParseHeader(b)
+		if err == nil && h.FreeFormat {
+			if r.freeSize <= 0 {
+				data, err := r.peek(StreamBuffer - 128)
+				if err != nil {
+					return nil, err
+				}
+				r.freeSize = FreeFormatSize(data, h)
+			}
+			if r.freeSize > 0 {
+				h.SetFreeFormatSize(r.freeSize)
+			}
+		}
+		var data []byte
+		if err == nil && h.FrameLength > 4 && h.FrameLength <= StreamBuffer-128 {
+			if data, err = r.peek(h.FrameLength); err != nil {
+				return nil, err
+			}
+		}
+		if h == nil || len(data) < h.FrameLength || h.FrameLength <= 4 {
+			if _, err := r.br.Discard(1); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		fr := &Frame{
+			Header:      h,
+			HeaderBytes: append([]byte(nil), data[:4]...),
+			Data:        append([]byte(nil), data[4:]...),
+		}
+		if _, err := r.br.Discard(len(data)); err != nil {
+			return nil, err
+		}
+		r.Frames++
+		if r.Frames == 1 {
+			r.Tag = ParseVBRTag(h, data)
+		} else {
+			r.music = CRC16LAME(r.music, fr.HeaderBytes)
+			r.music = CRC16LAME(r.music, fr.Data)
+		}
+		return fr, nil
+	}
+}
+
+// TagFrame reports whether the frame Next just returned is the VBR tag
+// frame, which carries no audio.
+func (r *Reader) TagFrame() bool {
+	return r.Frames == 1 && r.Tag != nil
+}
+
+// finish reads what follows the last frame.
+func (r *Reader) finish() error {
+	rest, err := io.ReadAll(r.br)
+	if err != nil {
+		return err
+	}
+	if len(rest) >= 128 && bytes.Equal(rest[len(rest)-128:len(rest)-125], []byte("TAG")) {
+		r.ID3v1 = append([]byte(nil), rest[len(rest)-128:]...)
+	}
+	if r.Frames == 0 {
+		return errors.New("no frames")
+	}
+	if r.Tag != nil && r.Tag.LAME != nil {
+		r.Tag.LAME.MusicCRCValid = r.music == r.Tag.LAME.MusicCRC
+	}
+	return io.EOF
+}
+
+// Writer writes an MP3 stream one frame at a time, the streaming
+// counterpart of Serialize.
+type Writer struct {
+	w      io.Writer
+	n      int64
+	frames int
+	tag    *Frame // copy of the first frame
+	tagAt  int64
+	music  uint16
+
+	// Stale is set by Close when the LAME tag CRCs changed but w could not
+	// seek back to the tag frame to rewrite them.
+	Stale bool
+}
+
+// NewWriter writes the ID3v2 tag, if any.
+func NewWriter(w io.Writer, id3v2 []byte) (*Writer, error) {
+	wr := &Writer{w: w}
+	return wr, wr.write(id3v2)
+}
+
+func (w *Writer) write(b []byte) error {
+	n, err := w.w.Write(b)
+	w.n += int64(n)
+	return err
+}
+
+// WriteFrame writes the next frame.
+func (w *Writer) WriteFrame(fr *Frame) error {
+	w.frames++
+	if w.frames == 1 {
+		w.tag = &Frame{Header: fr.Header, HeaderBytes: append([]byte(nil), fr.HeaderBytes...), Data: append([]byte(nil), fr.Data...)}
+		w.tagAt = w.n
+	} else {
+		w.music = CRC16LAME(w.music, fr.HeaderBytes)
+		w.music = CRC16LAME(w.music, fr.Data)
+	}
+	if err := w.write(fr.HeaderBytes); err != nil {
+		return err
+	}
+	return w.write(fr.Data)
+}
+
+// Close writes the ID3v1 tag and updates the LAME tag CRCs of tag, which
+// must come from the Reader once it reached the end.
+func (w *Writer) Close(tag *VBRTag, id3v1 []byte) error {
+	if err := w.write(id3v1); err != nil {
+		return err
+	}
+	if tag == nil || tag.LAME == nil || w.tag == nil || !tag.LAME.update(w.tag, w.music) {
+		return nil
+	}
+	ws, ok := w.w.(io.WriteSeeker)
+	if !ok {
+		w.Stale = true
+		return nil
+	}
+	if _, err := ws.Seek(w.tagAt, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := ws.Write(append(append([]byte(nil), w.tag.HeaderBytes...), w.tag.Data...)); err != nil {
+		return err
+	}
+	_, err := ws.Seek(0, io.SeekEnd)
+	return err
+}
@@ -0,0 +1,250 @@
+package mp3
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Xing header flags.
+const (
+	xingFrames  = 0x1
+	xingBytes   = 0x2
+	xingTOC     = 0x4
+	xingQuality = 0x8
+)
+
+// VBRTag describes the Xing/Info or VBRI tag frame written at the start of
+// most encoder output. The tag frame decodes as silence and carries no audio.
+type VBRTag struct {
+	Kind    string // "Xing", "Info" or "VBRI"
+	Frames  int    // number of audio frames, 0 if not present
+	Bytes   int    // size of the audio stream in bytes, 0 if not present
+	TOC     []byte
+	Quality int
+	LAME    *LAMETag
+	offset  int // offset of the tag inside the frame, header included
+}
+
+// LAMETag is the LAME extension that follows a Xing/Info header.
+type LAMETag struct {
+	Encoder        string
+	Revision       int
+	VBRMethod      int
+	Lowpass        int // Hz
+	EncoderDelay   int // samples added at the start
+	EncoderPadding int // samples added at the end
+	MusicLength    uint32
+	MusicCRC       uint16
+	TagCRC         uint16
+	MusicCRCValid  bool
+	TagCRCValid    bool
+	offset         int
+}
+
+// VBR reports whether the tag describes a variable bitrate stream.
+func (t *VBRTag) VBR() bool {
+	if t.Kind == "Info" {
+		return false
+	}
+	if t.LAME != nil && t.LAME.VBRMethod == 1 {
+		return false // CBR written with a Xing header
+	}
+	return true
+}
+
+// ParseVBRTag looks for a Xing/Info or VBRI tag in a frame. frame holds the
+// whole frame, header included. It returns nil when the frame is audio.
+func ParseVBRTag(h *FrameHeader, frame []byte) *VBRTag {
+	if h.Layer != 3 {
+		return nil
+	}
+
+	off := 4 + h.SideInfoLength()
+	if h.Protection {
+		off += 2
+	}
+	if off+8 <= len(frame) {
+		id := string(frame[off : off+4])
+		if id == "Xing" || id == "Info" {
+			return parseXing(frame, off)
+		}
+	}
+
+	// VBRI always sits 32 bytes after the header
+	if 36+26 <= len(frame) && bytes.Equal(frame[36:40], []byte("VBRI")) {
+		t := &VBRTag{Kind: "VBRI", offset: 36}
+		t.Quality = int(binary.BigEndian.Uint16(frame[44:46]))
+		t.Bytes = int(binary.BigEndian.Uint32(frame[46:50]))
+		t.Frames = int(binary.BigEndian.Uint32(frame[50:54]))
+		entries := int(binary.BigEndian.Uint16(frame[54:56]))
+		size := int(binary.BigEndian.Uint16(frame[58:60]))
+		if end := 62 + entries*size; end <= len(frame) {
+			t.TOC = append([]byte(nil), frame[62:end]...)
+		}
+		return t
+	}
+	return nil
+}
+
+func parseXing(frame []byte, off int) *VBRTag {
+	t := &VBRTag{Kind: string(frame[off : off+4]), offset: off}
+	flags := binary.BigEndian.Uint32(frame[off+4 : off+8])
+	p := off + 8
+	if flags&xingFrames != 0 && p+4 <= len(frame) {
+		t.Frames = int(binary.BigEndian.Uint32(frame[p : p+4]))
+		p += 4
+	}
+	if flags&xingBytes != 0 && p+4 <= len(frame) {
+		t.Bytes = int(binary.BigEndian.Uint32(frame[p : p+4]))
+		p += 4
+	}
+	if flags&xingTOC != 0 && p+100 <= len(frame) {
+		t.TOC = append([]byte(nil), frame[p:p+100]...)
+		p += 100
+	}
+	if flags&xingQuality != 0 && p+4 <= len(frame) {
+		t.Quality = int(binary.BigEndian.Uint32(frame[p : p+4]))
+		p += 4
+	}
+
+	if p+36 > len(frame) {
+		return t
+	}
+	enc := frame[p : p+9]
+	if !bytes.HasPrefix(enc, []byte("LAME")) && !bytes.HasPrefix(enc, []byte("L3.99")) &&
+		!bytes.HasPrefix(enc, []byte("Lavc")) && !bytes.HasPrefix(enc, []byte("Lavf")) {
+		return t
+	}
+	l := &LAMETag{
+		Encoder:        string(bytes.TrimRight(enc, "\x00 ")),
+		Revision:       int(frame[p+9] >> 4),
+		VBRMethod:      int(frame[p+9] & 0x0F),
+		Lowpass:        int(frame[p+10]) * 100,
+		EncoderDelay:   int(frame[p+21])<<4 | int(frame[p+22]>>4),
+		EncoderPadding: int(frame[p+22]&0x0F)<<8 | int(frame[p+23]),
+		MusicLength:    binary.BigEndian.Uint32(frame[p+28 : p+32]),
+		MusicCRC:       binary.BigEndian.Uint16(frame[p+32 : p+34]),
+		TagCRC:         binary.BigEndian.Uint16(frame[p+34 : p+36]),
+		offset:         p,
+	}
+	l.TagCRCValid = CRC16LAME(0, frame[:p+34]) == l.TagCRC
+	t.LAME = l
+	return t
+}
+
+// DetectTag looks for a Xing/Info/VBRI tag in the first frame and sets Tag.
+func (f *File) DetectTag() {
+	f.Tag = nil
+	if len(f.Frames) == 0 {
+		return
+	}
+	first := f.Frames[0]
+	frame := append(append([]byte(nil), first.HeaderBytes...), first.Data...)
+	f.Tag = ParseVBRTag(first.Header, frame)
+	if f.Tag != nil && f.Tag.LAME != nil {
+		f.Tag.LAME.MusicCRCValid = f.musicCRC() == f.Tag.LAME.MusicCRC
+	}
+}
+
+// CRC16LAME is the CRC-16/ARC checksum used by the LAME tag for both the
+// music CRC and the tag CRC.
+func CRC16LAME(crc uint16, b []byte) uint16 {
+	for _, v := range b {
+		crc ^= uint16(v)
+		for k := 0; k < 8; k++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// musicCRC computes the LAME music CRC over every frame after the tag frame.
+func (f *File) musicCRC() uint16 {
+	var crc uint16
+	for _, fr := range f.Frames[1:] {
+		crc = CRC16LAME(crc, fr.HeaderBytes)
+		crc = CRC16LAME(crc, fr.Data)
+	}
+	return crc
+}
+
+// AudioFrames returns the frames that carry audio, skipping the VBR tag
+// frame. Embedding only touches these frames.
+func (f *File) AudioFrames() []*Frame {
+	if f.Tag != nil && len(f.Frames) > 0 {
+		return f.Frames[1:]
+	}
+	return f.Frames
+}
+
+// updateTagCRC rewrites the LAME music CRC and tag CRC after the audio
+// frames have been modified.
+func (f *File) updateTagCRC() {
+	if f.Tag == nil || f.Tag.LAME == nil || len(f.Frames) == 0 {
+		return
+	}
+	f.Tag.LAME.update(f.Frames[0], f.musicCRC())
+}
+
+// update writes the music CRC music and a new tag CRC into the tag frame
+// fr. CRCs that were already wrong in the input are left alone so
+// unmodified files serialize byte-for-byte. It reports whether fr changed.
+func (l *LAMETag) update(fr *Frame, music uint16) bool {
+	// Offsets are frame relative, Data starts after the 4 header bytes
+	p := l.offset - 4
+	if p+36 > len(fr.Data) {
+		return false
+	}
+	old := binary.BigEndian.Uint32(fr.Data[p+32 : p+36])
+	if l.MusicCRCValid {
+		l.MusicCRC = music
+		binary.BigEndian.PutUint16(fr.Data[p+32:p+34], l.MusicCRC)
+	}
+	if l.TagCRCValid {
+		frame := append(append([]byte(nil), fr.HeaderBytes...), fr.Data[:p+34]...)
+		l.TagCRC = CRC16LAME(0, frame)
+		binary.BigEndian.PutUint16(fr.Data[p+34:p+36], l.TagCRC)
+	}
+	return binary.BigEndian.Uint32(fr.Data[p+32:p+36]) != old
+}
+
+// Duration returns the playing time in seconds and whether the stream is
+// VBR. With a LAME tag the encoder delay and padding are removed so the
+// result is the gapless duration.
+func (f *File) Duration() (float64, bool) {
+	if len(f.Frames) == 0 {
+		return 0, false
+	}
+	h := f.Frames[0].Header
+	if h.SampleRate == 0 {
+		return 0, false
+	}
+
+	vbr := false
+	frames := len(f.AudioFrames())
+	if f.Tag != nil {
+		vbr = f.Tag.VBR()
+		if f.Tag.Frames > 0 {
+			frames = f.Tag.Frames
+		}
+	} else {
+		for _, fr := range f.Frames[1:] {
+			if fr.Header.Bitrate != h.Bitrate {
+				vbr = true
+				break
+			}
+		}
+	}
+
+	samples := frames * h.SamplesPerFrame
+	if f.Tag != nil && f.Tag.LAME != nil {
+		if gapless := samples - f.Tag.LAME.EncoderDelay - f.Tag.LAME.EncoderPadding; gapless > 0 {
+			samples = gapless
+		}
+	}
+	return float64(samples) / float64(h.SampleRate), vbr
+}
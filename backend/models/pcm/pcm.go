@@ -0,0 +1,73 @@
+package pcm
+
+import "math"
+
+// Buffer holds interleaved PCM samples decoded from a lossless cover.
+// Integer samples are sign extended to int32, float samples keep their raw
+// IEEE-754 bits so that LSB changes land in the mantissa.
+type Buffer struct {
+	SampleRate int
+	Channels   int
+	BitDepth   int
+	Float      bool
+	Samples    []int32
+}
+
+// Frames returns the number of sample frames (one sample per channel).
+func (b *Buffer) Frames() int {
+	if b.Channels == 0 {
+		return 0
+	}
+	return len(b.Samples) / b.Channels
+}
+
+// Duration returns the length of the buffer in seconds.
+func (b *Buffer) Duration() float64 {
+	if b.SampleRate == 0 {
+		return 0
+	}
+	return float64(b.Frames()) / float64(b.SampleRate)
+}
+
+// LowBytes returns the least significant byte of every sample. The embedder
+// works on these bytes exactly like it works on MP3 frame bytes.
+func (b *Buffer) LowBytes() []byte {
+	out := make([]byte, len(b.Samples))
+	for i, s := range b.Samples {
+		out[i] = byte(s)
+	}
+	return out
+}
+
+// SetLowBytes writes modified low bytes back into the samples.
+func (b *Buffer) SetLowBytes(lo []byte) {
+	for i := range b.Samples {
+		if i >= len(lo) {
+			break
+		}
+		b.Samples[i] = int32(uint32(b.Samples[i])&^0xFF | uint32(lo[i]))
+	}
+}
+
+// Normalized converts the samples to float64 in the range [-1, 1].
+func (b *Buffer) Normalized() []float64 {
+	out := make([]float64, len(b.Samples))
+	if b.Float {
+		for i, s := range b.Samples {
+			out[i] = float64(math.Float32frombits(uint32(s)))
+		}
+		return out
+	}
+	scale := float64(int64(1) << uint(b.BitDepth-1))
+	for i, s := range b.Samples {
+		out[i] = float64(s) / scale
+	}
+	return out
+}
+
+// Clone returns a deep copy of the buffer.
+func (b *Buffer) Clone() *Buffer {
+	c := *b
+	c.Samples = append([]int32(nil), b.Samples...)
+	return &c
+}
@@ -0,0 +1,180 @@
+package wav
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/pcm"
+)
+
+// streamBlock is the size of the pieces the data chunk is edited in.
+const streamBlock = 1 << 16
+
+// Rewrite copies a WAVE file from r to w the way Serialize(Parse(...))
+// does, without holding the data chunk in memory. edit is called on
+// consecutive blocks of whole frames of the data chunk and may change the
+// samples. Sizes that are wrong in the input can only be fixed when w is an
+// io.WriteSeeker.
+func Rewrite(w io.Writer, r io.Reader, edit func(b *pcm.Buffer) error) error {
+	br := bufio.NewReaderSize(r, streamBlock)
+	head := make([]byte, 12)
+	if _, err := io.ReadFull(br, head); err != nil || !IsWAV(head) {
+		return errors.New("not a RIFF/WAVE file")
+	}
+	end := int64(8) + int64(binary.LittleEndian.Uint32(head[4:8]))
+	if end < 12 {
+		end = math.MaxInt64
+	}
+
+	out := &offsetWriter{w: w}
+	if err := out.write(head); err != nil {
+		return err
+	}
+	// patches holds the size fields that turned out wrong
+	patches := map[int64]uint32{}
+	f := &File{}
+	seenFmt, seenData := false, false
+	tmp := make([]byte, 8)
+	pos := int64(12)
+	for pos+8 <= end {
+		if _, err := io.ReadFull(br, tmp); err != nil {
+			break
+		}
+		pos += 8
+		id := string(tmp[0:4])
+		size := int64(binary.LittleEndian.Uint32(tmp[4:8]))
+		if pos+size > end {
+			size = end - pos
+		}
+		at := out.n + 4
+		binary.LittleEndian.PutUint32(tmp[4:8], uint32(size))
+		if err := out.write(tmp); err != nil {
+			return err
+		}
+
+		var n int64
+		var err error
+		switch id {
+		case "data":
+			if !seenFmt {
+				return errors.New("data chunk before fmt chunk")
+			}
+			seenData = true
+			n, err = rewriteData(out, br, f.Format, size, edit)
+		case "fmt ":
+			data := make([]byte, size)
+			k, rerr := io.ReadFull(br, data)
+			n, data = int64(k), data[:k]
+			if rerr == nil {
+				if err := f.parseFormat(data); err != nil {
+					return err
+				}
+				seenFmt = true
+			}
+			err = out.write(data)
+		default:
+			n, err = io.CopyN(out, br, size)
+			if err == io.EOF {
+				err = nil
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if n%2 == 1 {
+			if err := out.write([]byte{0}); err != nil {
+				return err
+			}
+		}
+		if n < size {
+			// Truncated file
+			patches[at] = uint32(n)
+			break
+		}
+		pos += size
+		if size%2 == 1 {
+			if _, err := br.Discard(1); err != nil {
+				break
+			}
+			pos++
+		}
+	}
+	if !seenFmt {
+		return errors.New("missing fmt chunk")
+	}
+	if !seenData {
+		return errors.New("missing data chunk")
+	}
+
+	if riff := uint32(out.n - 8); riff != binary.LittleEndian.Uint32(head[4:8]) {
+		patches[4] = riff
+	}
+	if len(patches) == 0 {
+		return nil
+	}
+	ws, ok := w.(io.WriteSeeker)
+	if !ok {
+		return fmt.Errorf("WAVE sizes are wrong in the input and the output cannot be rewound to fix them")
+	}
+	for at, v := range patches {
+		binary.LittleEndian.PutUint32(tmp, v)
+		if _, err := ws.Seek(at, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := ws.Write(tmp[:4]); err != nil {
+			return err
+		}
+	}
+	_, err := ws.Seek(0, io.SeekEnd)
+	return err
+}
+
+// rewriteData copies size bytes of the data chunk, passing the whole frames
+// through edit. It returns the bytes copied, less than size when r ends.
+func rewriteData(out *offsetWriter, r io.Reader, fm Format, size int64, edit func(b *pcm.Buffer) error) (int64, error) {
+	block := make([]byte, streamBlock/fm.BlockAlign*fm.BlockAlign)
+	var encoded []byte
+	var n int64
+	for n < size {
+		k, rerr := io.ReadFull(r, block[:min(int64(len(block)), size-n)])
+		if k == 0 {
+			break
+		}
+		buf, tail := decodeSamples(fm, block[:k])
+		if err := edit(buf); err != nil {
+			return n, err
+		}
+		encoded = appendSamples(encoded[:0], fm, buf)
+		if err := out.write(encoded); err != nil {
+			return n, err
+		}
+		if err := out.write(tail); err != nil {
+			return n, err
+		}
+		n += int64(k)
+		if rerr != nil {
+			break
+		}
+	}
+	return n, nil
+}
+
+type offsetWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (o *offsetWriter) Write(b []byte) (int, error) {
+	k, err := o.w.Write(b)
+	o.n += int64(k)
+	return k, err
+}
+
+func (o *offsetWriter) write(b []byte) error {
+	_, err := o.Write(b)
+	return err
+}
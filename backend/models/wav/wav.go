@@ -0,0 +1,251 @@
+package wav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/pcm"
+)
+
+const (
+	FormatPCM        = 0x0001
+	FormatIEEEFloat  = 0x0003
+	FormatExtensible = 0xFFFE
+)
+
+type Format struct {
+	AudioFormat   uint16
+	Channels      int
+	SampleRate    int
+	ByteRate      int
+	BlockAlign    int
+	BitsPerSample int
+	ValidBits     int    // bits of a sample that carry audio, the rest of its container is padding
+	SubFormat     uint16 // only set for WAVE_FORMAT_EXTENSIBLE
+}
+
+// width returns the size of a sample container in bytes.
+func (f Format) width() int {
+	return f.BlockAlign / f.Channels
+}
+
+// Float reports whether the samples are IEEE-754 floats.
+func (f Format) Float() bool {
+	return f.AudioFormat == FormatIEEEFloat || (f.AudioFormat == FormatExtensible && f.SubFormat == FormatIEEEFloat)
+}
+
+type Chunk struct {
+	ID   string
+	Data []byte
+}
+
+type File struct {
+	Format Format
+	Chunks []*Chunk // every chunk in file order, the data chunk is rebuilt from PCM and keeps no Data
+	PCM    *pcm.Buffer
+	tail   []byte // trailing bytes of the data chunk that do not form a whole frame
+}
+
+// IsWAV reports whether data starts with a RIFF/WAVE header.
+func IsWAV(data []byte) bool {
+	return len(data) >= 12 && bytes.Equal(data[0:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WAVE"))
+}
+
+func Parse(data []byte) (*File, error) {
+	if !IsWAV(data) {
+		return nil, errors.New("not a RIFF/WAVE file")
+	}
+
+	f := &File{}
+	end := 8 + int(binary.LittleEndian.Uint32(data[4:8]))
+	if end > len(data) || end < 12 {
+		end = len(data)
+	}
+
+	var fmtChunk *Chunk
+	var samples []byte
+	seenData := false
+	i := 12
+	for i+8 <= end {
+		id := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4 : i+8]))
+		i += 8
+		if size < 0 || i+size > end {
+			// Truncated chunk, keep what is there
+			size = end - i
+		}
+		// The data chunk is rebuilt from PCM, so it is not copied
+		c := &Chunk{ID: id}
+		if id == "data" {
+			samples, seenData = data[i:i+size], true
+		} else {
+			c.Data = append([]byte(nil), data[i:i+size]...)
+		}
+		f.Chunks = append(f.Chunks, c)
+		if id == "fmt " {
+			fmtChunk = c
+		}
+		i += size + size&1
+	}
+
+	if fmtChunk == nil {
+		return nil, errors.New("missing fmt chunk")
+	}
+	if !seenData {
+		return nil, errors.New("missing data chunk")
+	}
+	if err := f.parseFormat(fmtChunk.Data); err != nil {
+		return nil, err
+	}
+
+	f.PCM, f.tail = decodeSamples(f.Format, samples)
+	return f, nil
+}
+
+func (f *File) parseFormat(b []byte) error {
+	if len(b) < 16 {
+		return errors.New("fmt chunk too short")
+	}
+	f.Format = Format{
+		AudioFormat:   binary.LittleEndian.Uint16(b[0:2]),
+		Channels:      int(binary.LittleEndian.Uint16(b[2:4])),
+		SampleRate:    int(binary.LittleEndian.Uint32(b[4:8])),
+		ByteRate:      int(binary.LittleEndian.Uint32(b[8:12])),
+		BlockAlign:    int(binary.LittleEndian.Uint16(b[12:14])),
+		BitsPerSample: int(binary.LittleEndian.Uint16(b[14:16])),
+	}
+	if f.Format.AudioFormat == FormatExtensible {
+		if len(b) < 26 {
+			return errors.New("extensible fmt chunk too short")
+		}
+		f.Format.ValidBits = int(binary.LittleEndian.Uint16(b[18:20]))
+		f.Format.SubFormat = binary.LittleEndian.Uint16(b[24:26])
+	}
+	if f.Format.ValidBits == 0 {
+		f.Format.ValidBits = f.Format.BitsPerSample
+	}
+
+	code := f.Format.AudioFormat
+	if code == FormatExtensible {
+		code = f.Format.SubFormat
+	}
+	if f.Format.Channels == 0 || f.Format.BlockAlign == 0 || f.Format.BlockAlign%f.Format.Channels != 0 {
+		return errors.New("invalid channel layout")
+	}
+
+	width := f.Format.width()
+	switch code {
+	case FormatPCM:
+		if width < 1 || width > 4 {
+			return fmt.Errorf("unsupported PCM sample size: %d bits", width*8)
+		}
+		// The low byte of the valid bits is embedded in, so it must not
+		// reach into the padding
+		if f.Format.ValidBits < 8 || f.Format.ValidBits > width*8 {
+			return fmt.Errorf("unsupported PCM sample size: %d valid bits in %d", f.Format.ValidBits, width*8)
+		}
+	case FormatIEEEFloat:
+		if width != 4 || f.Format.ValidBits != 32 {
+			return fmt.Errorf("unsupported float sample size: %d valid bits in %d", f.Format.ValidBits, width*8)
+		}
+	default:
+		return fmt.Errorf("unsupported WAVE format 0x%04X", code)
+	}
+	return nil
+}
+
+// decodeSamples returns the samples of the whole frames in b and the bytes
+// left over. Samples are left justified in their containers, they are
+// shifted down so that their lowest valid bit is bit 0.
+func decodeSamples(fm Format, b []byte) (*pcm.Buffer, []byte) {
+	width := fm.width()
+	shift := uint(width*8 - fm.ValidBits)
+	n := (len(b) / fm.BlockAlign) * fm.Channels
+	buf := &pcm.Buffer{
+		SampleRate: fm.SampleRate,
+		Channels:   fm.Channels,
+		BitDepth:   fm.ValidBits,
+		Float:      fm.Float(),
+		Samples:    make([]int32, n),
+	}
+
+	for k := 0; k < n; k++ {
+		p := b[k*width : (k+1)*width]
+		switch width {
+		case 1:
+			// 8-bit WAVE is unsigned
+			buf.Samples[k] = int32(p[0]) - 128
+		case 2:
+			buf.Samples[k] = int32(int16(binary.LittleEndian.Uint16(p)))
+		case 3:
+			v := int32(p[0]) | int32(p[1])<<8 | int32(p[2])<<16
+			buf.Samples[k] = (v << 8) >> 8
+		case 4:
+			buf.Samples[k] = int32(binary.LittleEndian.Uint32(p))
+		}
+		buf.Samples[k] >>= shift
+	}
+
+	return buf, append([]byte(nil), b[n*width:]...)
+}
+
+// appendSamples appends the samples encoded as decodeSamples decodes them,
+// the padding bits written as zero.
+func appendSamples(dst []byte, fm Format, buf *pcm.Buffer) []byte {
+	width := fm.width()
+	shift := uint(width*8 - fm.ValidBits)
+	n := len(dst)
+	dst = slices.Grow(dst, len(buf.Samples)*width)[:n+len(buf.Samples)*width]
+	for k, s := range buf.Samples {
+		s <<= shift
+		p := dst[n+k*width : n+(k+1)*width]
+		switch width {
+		case 1:
+			p[0] = byte(s + 128)
+		case 2:
+			binary.LittleEndian.PutUint16(p, uint16(s))
+		case 3:
+			p[0], p[1], p[2] = byte(s), byte(s>>8), byte(s>>16)
+		case 4:
+			binary.LittleEndian.PutUint32(p, uint32(s))
+		}
+	}
+	return dst
+}
+
+// Serialize writes the file out in a buffer of its final size.
+func Serialize(f *File) []byte {
+	size := 12
+	for _, c := range f.Chunks {
+		n := len(c.Data)
+		if c.ID == "data" && f.PCM != nil {
+			n = len(f.PCM.Samples)*f.Format.width() + len(f.tail)
+		}
+		size += 8 + n + n%2
+	}
+
+	out := make([]byte, 0, size)
+	out = append(out, "RIFF"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(size-8))
+	out = append(out, "WAVE"...)
+	for _, c := range f.Chunks {
+		at := len(out)
+		out = append(out, c.ID...)
+		out = append(out, 0, 0, 0, 0)
+		if c.ID == "data" && f.PCM != nil {
+			out = appendSamples(out, f.Format, f.PCM)
+			out = append(out, f.tail...)
+		} else {
+			out = append(out, c.Data...)
+		}
+		n := len(out) - at - 8
+		binary.LittleEndian.PutUint32(out[at+4:], uint32(n))
+		if n%2 == 1 {
+			out = append(out, 0)
+		}
+	}
+	return out
+}
@@ -26,6 +26,7 @@ func SetupRouter() *gin.Engine {
 		api.GET("/hello", controllers.HandleHello) 
 
 		api.POST("/encode", controllers.HandleEncode)
+		api.POST("/capacity", controllers.HandleCapacity)
         api.GET("/download/stego/:filename", controllers.HandleDownloadStego)
         api.GET("/play/stego/:filename", controllers.HandlePlayStego)
 		
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Authenticated payload ciphers
+const (
+	CipherAES256GCM        = "aes-256-gcm"
+	CipherChaCha20Poly1305 = "chacha20-poly1305"
+)
+
+// ErrWrongKey is returned when a payload does not authenticate.
+var ErrWrongKey = errors.New("failed to decrypt the payload: wrong key or corrupted data")
+
+// A sealed payload is a random nonce followed by the plaintext cut into
+// chunks of sealChunk bytes, each sealed on its own so that neither side
+// holds more than one chunk. The key of a payload is derived from the key
+// given and the nonce. The nonce of a chunk is its index, big-endian in
+// the first 11 bytes, and 1 in the last byte for the final chunk, so
+// chunks cannot be reordered, dropped or cut off unnoticed. An empty
+// plaintext is one empty chunk.
+const (
+	sealNonce    = 16
+	sealChunk    = 64 << 10
+	sealOverhead = 16 // tag of one chunk
+)
+
+// AEADCipher encrypts and authenticates payloads.
+type AEADCipher struct {
+	newAEAD func(key []byte) (cipher.AEAD, error)
+	key     []byte
+}
+
+// NewAEADCipher returns the named cipher keyed with key, which should come
+// from a key derivation function.
+func NewAEADCipher(name string, key []byte) (*AEADCipher, error) {
+	c := &AEADCipher{key: key}
+	switch name {
+	case CipherAES256GCM:
+		c.newAEAD = func(key []byte) (cipher.AEAD, error) {
+			block, err := aes.NewCipher(key)
+			if err != nil {
+				return nil, err
+			}
+			return cipher.NewGCM(block)
+		}
+	case CipherChaCha20Poly1305:
+		c.newAEAD = chacha20poly1305.New
+	default:
+		return nil, fmt.Errorf("unknown cipher %q", name)
+	}
+	return c, nil
+}
+
+// SealedSize returns the size of a sealed payload of n bytes.
+func SealedSize(n int64) int64 {
+	chunks := max((n+sealChunk-1)/sealChunk, 1)
+	return sealNonce + n + chunks*sealOverhead
+}
+
+// MaxPlaintext returns the largest payload that is at most n bytes sealed.
+func MaxPlaintext(n int64) int64 {
+	n -= sealNonce
+	if n < sealOverhead {
+		return 0
+	}
+	q, r := n/(sealChunk+sealOverhead), n%(sealChunk+sealOverhead)
+	p := q * sealChunk
+	if r > sealOverhead {
+		p += r - sealOverhead
+	}
+	return p
+}
+
+// sealable reports whether some payload seals into n bytes.
+func sealable(n int64) bool {
+	return n >= sealNonce+sealOverhead && SealedSize(MaxPlaintext(n)) == n
+}
+
+func (c *AEADCipher) aead(nonce []byte) (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, c.key, nonce, []byte("payload")), key); err != nil {
+		return nil, err
+	}
+	return c.newAEAD(key)
+}
+
+func chunkNonce(nonce []byte, index uint64, last bool) []byte {
+	clear(nonce)
+	binary.BigEndian.PutUint64(nonce[3:11], index)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// EncryptReader seals the size bytes read from r, SealedSize(size) bytes
+// in all.
+func (c *AEADCipher) EncryptReader(r io.Reader, size int64) (io.Reader, error) {
+	nonce := make([]byte, sealNonce)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	aead, err := c.aead(nonce)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, sealChunk+sealOverhead)
+	return &sealReader{
+		aead:  aead,
+		r:     r,
+		left:  size,
+		plain: make([]byte, sealChunk),
+		nonce: make([]byte, aead.NonceSize()),
+		out:   out,
+		buf:   append(out, nonce...),
+	}, nil
+}
+
+type sealReader struct {
+	aead  cipher.AEAD
+	r     io.Reader
+	left  int64 // plaintext bytes not read yet
+	index uint64
+	done  bool
+	plain []byte
+	nonce []byte
+	out   []byte
+	buf   []byte // sealed bytes not returned yet
+}
+
+func (s *sealReader) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		n := min(s.left, sealChunk)
+		if _, err := io.ReadFull(s.r, s.plain[:n]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		s.left -= n
+		s.done = s.left == 0
+		s.buf = s.aead.Seal(s.out[:0], chunkNonce(s.nonce, s.index, s.done), s.plain[:n], nil)
+		s.index++
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// DecryptWriter opens the size sealed bytes written to it into w. Writes
+// fail with ErrWrongKey as soon as a chunk does not authenticate; nothing
+// of it reaches w.
+func (c *AEADCipher) DecryptWriter(w io.Writer, size int64) io.Writer {
+	o := &openWriter{c: c, w: w, left: size}
+	if !sealable(size) {
+		o.err = ErrWrongKey
+	}
+	return o
+}
+
+type openWriter struct {
+	c     *AEADCipher
+	w     io.Writer
+	left  int64 // sealed bytes not written yet
+	aead  cipher.AEAD
+	index uint64
+	nonce []byte
+	buf   []byte // nonce, then the current chunk
+	err   error
+}
+
+func (o *openWriter) Write(p []byte) (int, error) {
+	n := 0
+	for o.err == nil && len(p) > 0 {
+		if int64(len(p)) > o.left {
+			o.err = ErrWrongKey
+			break
+		}
+		want := sealNonce
+		if o.aead != nil {
+			want = sealChunk + sealOverhead
+		}
+		k := min(want-len(o.buf), len(p))
+		o.buf = append(o.buf, p[:k]...)
+		p, n, o.left = p[k:], n+k, o.left-int64(k)
+		if len(o.buf) < want && o.left > 0 {
+			continue
+		}
+		if o.aead == nil {
+			if o.aead, o.err = o.c.aead(o.buf); o.err != nil {
+				break
+			}
+			o.nonce = make([]byte, o.aead.NonceSize())
+			o.buf = make([]byte, 0, sealChunk+sealOverhead)
+			continue
+		}
+		plain, err := o.aead.Open(o.buf[:0], chunkNonce(o.nonce, o.index, o.left == 0), o.buf, nil)
+		if err != nil {
+			o.err = ErrWrongKey
+			break
+		}
+		o.index++
+		o.buf = o.buf[:0]
+		if _, err := o.w.Write(plain); err != nil {
+			o.err = err
+		}
+	}
+	return n, o.err
+}
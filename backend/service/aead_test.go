@@ -0,0 +1,141 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// seal returns plain sealed with c.
+func seal(t *testing.T, c *AEADCipher, plain []byte) []byte {
+	t.Helper()
+	r, err := c.EncryptReader(bytes.NewReader(plain), int64(len(plain)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	return sealed
+}
+
+// open writes sealed to c's DecryptWriter in pieces of at most piece
+// bytes and returns what came out.
+func open(c *AEADCipher, sealed []byte, piece int) ([]byte, error) {
+	var out bytes.Buffer
+	w := c.DecryptWriter(&out, int64(len(sealed)))
+	for len(sealed) > 0 {
+		k := min(piece, len(sealed))
+		if _, err := w.Write(sealed[:k]); err != nil {
+			return out.Bytes(), err
+		}
+		sealed = sealed[k:]
+	}
+	return out.Bytes(), nil
+}
+
+func TestAEADRoundTrip(t *testing.T) {
+	sizes := []int{0, 1, sealChunk - 1, sealChunk, sealChunk + 1, 2*sealChunk + 5}
+	for _, name := range []string{CipherAES256GCM, CipherChaCha20Poly1305} {
+		c, err := NewAEADCipher(name, []byte("a key from the key derivation"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range sizes {
+			plain := make([]byte, n)
+			rand.New(rand.NewSource(int64(n))).Read(plain)
+			sealed := seal(t, c, plain)
+			if int64(len(sealed)) != SealedSize(int64(n)) {
+				t.Fatalf("%s: %d bytes sealed into %d, SealedSize says %d", name, n, len(sealed), SealedSize(int64(n)))
+			}
+			// Writes of any size, across the chunk boundaries
+			for _, piece := range []int{len(sealed) + 1, 1000, 7} {
+				got, err := open(c, sealed, piece)
+				if err != nil {
+					t.Fatalf("%s: open %d bytes in pieces of %d: %v", name, n, piece, err)
+				}
+				if !bytes.Equal(got, plain) {
+					t.Fatalf("%s: %d bytes opened in pieces of %d differ", name, n, piece)
+				}
+			}
+		}
+	}
+}
+
+func TestAEADTampered(t *testing.T) {
+	c, err := NewAEADCipher(CipherAES256GCM, []byte("a key from the key derivation"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := make([]byte, 2*sealChunk+100)
+	rand.New(rand.NewSource(1)).Read(plain)
+	sealed := seal(t, c, plain)
+	chunk := func(i int) []byte {
+		start := sealNonce + i*(sealChunk+sealOverhead)
+		return sealed[start:min(start+sealChunk+sealOverhead, len(sealed))]
+	}
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	nonce := sealed[:sealNonce]
+	other, err := NewAEADCipher(CipherAES256GCM, []byte("another key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		c      *AEADCipher
+		sealed []byte
+		opened int // bytes of plain written before the failure
+	}{
+		{"wrong key", other, sealed, 0},
+		{"flipped bit", c, func() []byte {
+			b := bytes.Clone(sealed)
+			b[len(b)-1] ^= 1
+			return b
+		}(), 2 * sealChunk},
+		{"last chunk dropped", c, join(nonce, chunk(0), chunk(1)), sealChunk},
+		{"cut short", c, sealed[:len(sealed)-1], 2 * sealChunk},
+		{"chunks swapped", c, join(nonce, chunk(1), chunk(0), chunk(2)), 0},
+		{"chunk repeated", c, join(nonce, chunk(0), chunk(0), chunk(2)), sealChunk},
+		{"nonce only", c, nonce, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := open(tt.c, tt.sealed, 4096)
+			if !errors.Is(err, ErrWrongKey) {
+				t.Fatalf("open: %v, want %v", err, ErrWrongKey)
+			}
+			if len(got) != tt.opened || !bytes.Equal(got, plain[:len(got)]) {
+				t.Fatalf("%d bytes written before the failure, want the first %d", len(got), tt.opened)
+			}
+		})
+	}
+}
+
+func TestSealedSize(t *testing.T) {
+	for _, n := range []int64{0, 1, sealChunk - 1, sealChunk, sealChunk + 1, 3*sealChunk - 1, 3 * sealChunk, 3*sealChunk + 1} {
+		s := SealedSize(n)
+		if got := MaxPlaintext(s); got != n {
+			t.Errorf("MaxPlaintext(SealedSize(%d)) = %d", n, got)
+		}
+		if !sealable(s) {
+			t.Errorf("%d bytes, the sealed size of %d, are not sealable", s, n)
+		}
+	}
+	// Every room is filled as far as a payload fits, one more byte would
+	// not fit
+	for _, room := range []int64{0, sealNonce + sealOverhead - 1, sealNonce + sealOverhead, sealNonce + sealChunk + sealOverhead, sealNonce + sealChunk + sealOverhead + 1, sealNonce + sealChunk + 2*sealOverhead, 5 * sealChunk} {
+		p := MaxPlaintext(room)
+		if room >= sealNonce+sealOverhead && SealedSize(p) > room {
+			t.Errorf("MaxPlaintext(%d) = %d seals into %d bytes", room, p, SealedSize(p))
+		}
+		if SealedSize(p+1) <= room {
+			t.Errorf("MaxPlaintext(%d) = %d, but %d bytes fit", room, p, p+1)
+		}
+	}
+}
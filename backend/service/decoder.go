@@ -8,7 +8,9 @@ import (
 	"os"
 
 	"github.com/rifchzschki/Audio-Steganografi/backend/models"
-	"github.com/rifchzschki/Audio-Steganografi/backend/utils"
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/cover"
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/id3"
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/mp3"
 )
 
 type MP3Decoder struct {
@@ -65,6 +67,13 @@ func (d *MP3Decoder) Decode() (*models.MP3File, error) {
 		return nil, errors.New("no data loaded")
 	}
 	
+	// Only MP3 is parsed here; other formats go through models/cover
+	if f, err := cover.Detect(d.data); err != nil {
+		return nil, err
+	} else if f.Name() != "MP3" {
+		return nil, fmt.Errorf("%s is not supported here, only MP3", f.Name())
+	}
+
 	mp3File := models.NewMP3File()
 	d.offset = 0
 	
@@ -109,8 +118,14 @@ func (d *MP3Decoder) parseID3v2(mp3File *models.MP3File) error {
 		mp3File.ID3v2Data = make([]byte, size)
 		copy(mp3File.ID3v2Data, d.data[10:10+size])
 	}
+	if tag, err := id3.Parse(d.data); err == nil {
+		mp3File.ID3v2Tag = tag
+	}
 	
 	d.offset = 10 + size
+	if n := id3.Size(d.data); n > 0 {
+		d.offset = n // footer included
+	}
 	return nil
 }
 
@@ -142,6 +157,7 @@ func (d *MP3Decoder) parseFrames(mp3File *models.MP3File) error {
 		maxOffset -= 128
 	}
 	
+	freeSize := 0
 	for d.offset < maxOffset-4 {
 		if d.offset+4 > len(d.data) {
 			break
@@ -149,18 +165,25 @@ func (d *MP3Decoder) parseFrames(mp3File *models.MP3File) error {
 		
 		headerBytes := d.data[d.offset : d.offset+4]
 		
-		if !d.isValidFrameHeader(headerBytes) {
+		h, err := mp3.ParseHeader(headerBytes)
+		if err != nil {
 			d.offset++
 			continue
 		}
 		
-		header, err := d.parseFrameHeader(headerBytes)
-		if err != nil {
-			d.offset++
-			continue
+		if h.FreeFormat {
+			if freeSize <= 0 {
+				freeSize = mp3.FreeFormatSize(d.data[d.offset:maxOffset], h)
+			}
+			if freeSize <= 0 {
+				d.offset++
+				continue
+			}
+			h.SetFreeFormatSize(freeSize)
 		}
+		header := toModelHeader(h)
 		
-		if header.FrameLength <= 0 || d.offset+header.FrameLength > maxOffset {
+		if header.FrameLength <= 4 || d.offset+header.FrameLength > maxOffset {
 			d.offset++
 			continue
 		}
@@ -184,91 +207,29 @@ func (d *MP3Decoder) parseFrames(mp3File *models.MP3File) error {
 	return nil
 }
 
-func (d *MP3Decoder) isValidFrameHeader(header []byte) bool {
-	if len(header) < 4 {
-		return false
-	}
-	
-	if header[0] != 0xFF {
-		return false
-	}
-	
-	if (header[1] & 0xE0) != 0xE0 {
-		return false
-	}
-	
-	versionID := (header[1] >> 3) & 0x03
-	if versionID == 1 {
-		return false
-	}
-	
-	layer := (header[1] >> 1) & 0x03
-	if layer == 0 {
-		return false
-	}
-	
-	bitrateIndex := (header[2] >> 4) & 0x0F
-	if bitrateIndex == 0 || bitrateIndex == 15 {
-		return false
+// parseFrameHeader decodes a frame header with the same tables and length
+// rules as mp3.Parse. Free format headers come back without a length.
+func (d *MP3Decoder) parseFrameHeader(headerBytes []byte) (*models.MP3FrameHeader, error) {
+	h, err := mp3.ParseHeader(headerBytes)
+	if err != nil {
+		return nil, err
 	}
-	
-	sampleRateIndex := (header[2] >> 2) & 0x03
-
-	return sampleRateIndex != 3
+	return toModelHeader(h), nil
 }
 
-func (d *MP3Decoder) parseFrameHeader(headerBytes []byte) (*models.MP3FrameHeader, error) {
-	if len(headerBytes) < 4 {
-		return nil, errors.New("invalid header length")
+func toModelHeader(h *mp3.FrameHeader) *models.MP3FrameHeader {
+	return &models.MP3FrameHeader{
+		VersionID:       h.VersionID,
+		Layer:           h.Layer,
+		ProtectionBit:   !h.Protection,
+		Bitrate:         h.Bitrate,
+		SampleRate:      h.SampleRate,
+		Padding:         h.Padding,
+		ChannelMode:     h.ChannelMode,
+		FrameLength:     h.FrameLength,
+		FreeFormat:      h.FreeFormat,
+		SamplesPerFrame: h.SamplesPerFrame,
 	}
-	
-	header := &models.MP3FrameHeader{}
-	
-	header.VersionID = int((headerBytes[1] >> 3) & 0x03)
-	header.Layer = int((headerBytes[1] >> 1) & 0x03)
-	header.ProtectionBit = (headerBytes[1] & 0x01) == 1
-	
-	bitrateIndex := int((headerBytes[2] >> 4) & 0x0F)
-	sampleRateIndex := int((headerBytes[2] >> 2) & 0x03)
-	
-	header.Padding = (headerBytes[2] & 0x02) != 0
-	header.ChannelMode = int((headerBytes[3] >> 6) & 0x03)
-	
-	versionIndex := 0
-	switch header.VersionID {
-	case 3:
-		versionIndex = 0
-	case 2:
-		versionIndex = 1
-	case 0:
-		versionIndex = 2
-	}
-	
-	layerIndex := 0
-	
-	switch header.Layer {
-	case 3:
-		layerIndex = 0
-	case 2:
-		layerIndex = 1
-	case 1:
-		layerIndex = 2
-	}
-	
-	if versionIndex < 3 && layerIndex < 3 {
-		header.Bitrate = utils.MP3BitrateTable[bitrateIndex][layerIndex] * 1000
-		header.SampleRate = utils.MP3SampleRateTable[versionIndex][sampleRateIndex]
-	}
-	
-	if header.SampleRate > 0 {
-		if header.Layer == 1 {
-			header.FrameLength = (12*header.Bitrate/header.SampleRate + int(utils.BoolToInt(header.Padding))) * 4
-		} else {
-			header.FrameLength = 144*header.Bitrate/header.SampleRate + int(utils.BoolToInt(header.Padding))
-		}
-	}
-	
-	return header, nil
 }
 
 
@@ -313,23 +274,72 @@ func (d *MP3Decoder) GetMetadata(mp3File *models.MP3File) *models.AudioMetadata
 		channels = 1
 	}
 	
-	totalBytes := 0
-	for _, frame := range mp3File.Frames {
-		totalBytes += len(frame.GetData())
-	}
+	// Duration and VBR status come from the Xing/Info/VBRI tag when there is
+	// one, otherwise from counting frames
+	f := toMP3File(mp3File)
+	duration, vbr := f.Duration()
 	
-	duration := 0.0
-	if header.SampleRate > 0 && header.Bitrate > 0 {
-		duration = float64(totalBytes*8) / float64(header.Bitrate)
+	totalBytes := 0
+	for _, frame := range f.AudioFrames() {
+		totalBytes += len(frame.HeaderBytes) + len(frame.Data)
 	}
 	
-	return &models.AudioMetadata{
+	meta := &models.AudioMetadata{
 		SampleRate: header.SampleRate,
 		Channels:   channels,
 		BitDepth:   16,
 		Duration:   duration,
 		TotalBytes: totalBytes,
+		Frames:     len(f.AudioFrames()),
+		VBR:        vbr,
 	}
+	if duration > 0 {
+		meta.Bitrate = int(float64(totalBytes*8) / duration)
+	}
+	if f.Tag != nil {
+		meta.TagType = f.Tag.Kind
+		if f.Tag.Frames > 0 {
+			meta.Frames = f.Tag.Frames
+		}
+		if f.Tag.LAME != nil {
+			meta.Encoder = f.Tag.LAME.Encoder
+			meta.EncoderDelay = f.Tag.LAME.EncoderDelay
+			meta.EncoderPadding = f.Tag.LAME.EncoderPadding
+		}
+	}
+	
+	return meta
+}
+
+// toMP3File converts a decoded file to the models/mp3 representation so
+// that tag detection and duration use the same code as the stego encoder.
+func toMP3File(m *models.MP3File) *mp3.File {
+	f := &mp3.File{}
+	for _, frame := range m.Frames {
+		mf, ok := frame.(*models.MP3Frame)
+		if !ok {
+			continue
+		}
+		h := mf.Header
+		f.Frames = append(f.Frames, &mp3.Frame{
+			Header: &mp3.FrameHeader{
+				VersionID:       h.VersionID,
+				Layer:           h.Layer,
+				Protection:      !h.ProtectionBit,
+				Bitrate:         h.Bitrate,
+				SampleRate:      h.SampleRate,
+				Padding:         h.Padding,
+				ChannelMode:     h.ChannelMode,
+				FreeFormat:      h.FreeFormat,
+				SamplesPerFrame: h.SamplesPerFrame,
+				FrameLength:     h.FrameLength,
+			},
+			HeaderBytes: mf.HeaderBytes,
+			Data:        mf.Data,
+		})
+	}
+	f.DetectTag()
+	return f
 }
 
 func (d *MP3Decoder) EmbedDataWithSteganography(mp3File *models.MP3File, secretData []byte, config models.LSBConfig) *models.SteganographyResult {
@@ -393,6 +403,7 @@ func (d *MP3Decoder) reconstructMP3File(originalMP3 *models.MP3File, originalAud
 	newMP3 := models.NewMP3File()
 	
 	newMP3.ID3v2 = originalMP3.ID3v2
+	newMP3.ID3v2Tag = originalMP3.ID3v2Tag
 	if originalMP3.ID3v2Data != nil {
 		newMP3.ID3v2Data = make([]byte, len(originalMP3.ID3v2Data))
 		copy(newMP3.ID3v2Data, originalMP3.ID3v2Data)
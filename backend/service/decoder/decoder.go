@@ -1,16 +1,25 @@
 package decoder
 
 import (
+    "bytes"
     "crypto/sha256"
     "encoding/binary"
+    "errors"
     "fmt"
+    "hash"
+    "io"
     "math/rand"
     "os"
 	"path/filepath"
+    "slices"
     "github.com/rifchzschki/Audio-Steganografi/backend/service"
+    "github.com/rifchzschki/Audio-Steganografi/backend/models/cover"
+    "github.com/rifchzschki/Audio-Steganografi/backend/models/id3"
     "github.com/rifchzschki/Audio-Steganografi/backend/models/meta"
     "github.com/rifchzschki/Audio-Steganografi/backend/models/mp3"
+    "github.com/rifchzschki/Audio-Steganografi/backend/utils/bitstream"
     "github.com/rifchzschki/Audio-Steganografi/backend/utils/payload"
+    "github.com/rifchzschki/Audio-Steganografi/backend/utils/rs"
     "github.com/rifchzschki/Audio-Steganografi/backend/utils/sig"
 	"strings"
 )
@@ -20,33 +29,82 @@ func seedFromKey(key string) int64 {
     return int64(binary.LittleEndian.Uint64(h[:8])) 
 }
 
-func find(sigBits, hay []uint8) int { 
-    if len(sigBits) == 0 || len(hay) < len(sigBits) { return -1 }
-    for i := 0; i+len(sigBits) <= len(hay); i++ { 
-        ok := true
-        for j := 0; j < len(sigBits); j++ { 
-            if hay[i+j] != sigBits[j] { 
-                ok = false
-                break 
-            } 
-        }
-        if ok { return i } 
+// ErrNotFound is returned when no payload is found with the key. The fixed
+// header of newer files only opens with the key they were embedded with,
+// so a wrong key looks the same as a file without a payload.
+var ErrNotFound = errors.New("no hidden data found: wrong key or no payload")
+
+// IntegrityError reports a payload that was found but is not intact: cut
+// short, without its end marker or not matching its checksum.
+type IntegrityError struct {
+    Reason string
+}
+
+func (e *IntegrityError) Error() string {
+    return "integrity check failed: " + e.Reason
+}
+
+// readPayload reads the payload of h from stream and checks the end marker
+// of width w behind it.
+func readPayload(stream *bitstream.BitReader, h *meta.Header, w int) ([]byte, error) {
+    if rem := stream.Remaining() - sig.Len; rem < 0 || h.Size > uint64(rem/8) {
+        return nil, &IntegrityError{"the payload is cut short"}
+    }
+    pay := make([]byte, h.Size)
+    if _, err := io.ReadFull(stream, pay); err != nil {
+        return nil, &IntegrityError{"the payload is cut short"}
+    }
+    if e, err := stream.ReadBits(sig.Len); err != nil || e != sig.Map[w].E {
+        return nil, &IntegrityError{"the end marker is missing"}
     }
-    return -1 
+    return pay, nil
 }
 
-func tryDecode(audio []byte, key string, random bool, w int, dbg bool) ([]byte, *meta.Header, bool) {
-    eligible := make([]int, 0, len(audio))
-    for i, b := range audio { 
-        if b != 0x00 && b != 0xFF { 
-            eligible = append(eligible, i) 
-        } 
+// verify compares the checksum sum computed over the extracted secret with
+// the digest in h. Headers without a checksum, or with one this version
+// does not know, pass.
+func verify(h *meta.Header, sum hash.Hash) error {
+    if sum != nil && !bytes.Equal(sum.Sum(nil), h.Digest) {
+        return &IntegrityError{fmt.Sprintf("the %s checksum does not match", h.Checksum)}
+    }
+    return nil
+}
+
+// decompressor returns the writer that decompresses the secret of h into
+// w, nil when it was not compressed.
+func decompressor(w io.Writer, h *meta.Header) (io.WriteCloser, error) {
+    if h.Compression == meta.CompressionNone {
+        return nil, nil
+    }
+    return h.Compression.NewDecompressor(w)
+}
+
+// malformed turns the failure to decompress a payload into an integrity
+// error.
+func malformed(err error) error {
+    if errors.Is(err, meta.ErrMalformed) {
+        return &IntegrityError{"the compressed secret is malformed"}
+    }
+    return err
+}
+
+// tryDecode looks for a payload in the eligible bytes of audio. A nil
+// eligible list means every byte was used for embedding. It returns a nil
+// header when there is none.
+func tryDecode(audio []byte, eligible []int, key string, random bool, w int, dbg bool) ([]byte, *meta.Header, error) {
+    // Every unit in turn needs no list of positions
+    var order []int
+    if eligible != nil || random {
+        order = make([]int, 0, len(audio))
+        if eligible == nil {
+            for i := range audio { order = append(order, i) }
+        } else {
+            order = append(order, eligible...)
+        }
+        if len(order) == 0 { return nil, nil, nil }
+    } else if len(audio) == 0 {
+        return nil, nil, nil
     }
-    if len(eligible) == 0 { return nil, nil, false }
-    
-    order := make([]int, len(audio))
-    for i := range audio { order[i] = i }
-    if len(order) == 0 { panic("no audio bytes") }
     
     if random { 
         rsrc := rand.New(rand.NewSource(seedFromKey(key)))
@@ -56,129 +114,486 @@ func tryDecode(audio []byte, key string, random bool, w int, dbg bool) ([]byte,
         } 
     }
     
-    mask := byte((1 << uint(w)) - 1)
-    stream := make([]uint8, 0, len(order)*w)
-    for _, pos := range order { 
-        v := audio[pos] & mask
-        for i := w - 1; i >= 0; i-- { 
-            stream = append(stream, (v>>uint(i))&1) 
-        } 
-    }
+    stream := bitstream.NewUnitReader(audio, order, nil, w)
     
     if dbg {
-        nshow := 48
-        if len(stream) < nshow { nshow = len(stream) }
-        fmt.Printf("[DBG] w=%d random=%v firstBits=%v...\n", w, random, stream[:nshow])
-        npos := 10
-        if len(order) < npos { npos = len(order) }
-        fmt.Printf("[DBG] firstPos=%v\n", order[:npos])
+        first := make([]byte, 6)
+        n, _ := bitstream.NewUnitReader(audio, order, nil, w).Read(first)
+        fmt.Printf("[DBG] w=%d random=%v firstBits=%08b...\n", w, random, first[:n])
+        if order != nil {
+            npos := 10
+            if len(order) < npos { npos = len(order) }
+            fmt.Printf("[DBG] firstPos=%v\n", order[:npos])
+        }
         sg := sig.Map[w]
-        fmt.Printf("[DBG] sigS=%v\n", sg.S)
+        fmt.Printf("[DBG] sigS=%0*b\n", sig.Len, sg.S)
     }
     
     sg := sig.Map[w]
-    p := find(sg.S, stream)
-    if p < 0 { return nil, nil, false }
-    if p+len(sg.S)+8 > len(stream) { return nil, nil, false }
+    if !stream.Find(sg.S, sig.Len) { return nil, nil, nil }
+    
+    wb, err := stream.ReadByte()
+    if err != nil || wb != sig.WidthByte(w) { return nil, nil, nil }
     
-    wb := payload.BitsToBytes(stream[p+len(sg.S) : p+len(sg.S)+8])
-    if len(wb) != 1 || int(wb[0]-'0') != w { return nil, nil, false }
+    h, ok := meta.Read(stream)
+    if !ok { return nil, nil, nil }
     
-    mb := payload.BitsToBytes(stream[p+len(sg.S)+8:])
-    h, ok := meta.Unpack(mb)
-    if !ok { return nil, nil, false }
+    pay, err := readPayload(stream, &h, w)
+    if err != nil { return nil, nil, err }
     
-    metaLen := 4 + 1 + 1 + 1 + 1 + len(h.Name) + 1 + len(h.Ext) + 8
-    if metaLen > len(mb) { return nil, nil, false }
+    return pay, &h, nil
+}
+
+// fixedPayload is what tryDecodeFixed finds behind a fixed header.
+type fixedPayload struct {
+    pay       []byte
+    h         *meta.Header
+    fx        *meta.Fixed
+    fk        *meta.FileKey // opened the fixed header
+    corrected int           // bytes the Reed-Solomon code corrected
+}
+
+// uncoded reads the bit stream coded with the layout of fx from stream and
+// returns it corrected.
+func uncoded(stream *bitstream.BitReader, fx *meta.Fixed) (*bitstream.BitReader, int, error) {
+    code := rs.Layout{Parity: int(fx.Parity), Depth: int(fx.Depth)}
+    if code.Check() != nil {
+        return nil, 0, &IntegrityError{"the fixed header is damaged"}
+    }
+    data, corrected, err := code.Decode(stream)
+    if errors.Is(err, rs.ErrUncorrectable) {
+        return nil, corrected, &IntegrityError{"the payload has more errors than " + code.String() + " corrects"}
+    }
+    if err != nil {
+        return nil, corrected, &IntegrityError{"the payload is cut short"}
+    }
+    return bitstream.NewBitReader(data, len(data)*8), corrected, nil
+}
+
+// tryDecodeFixed reads a version 3 or 2 payload from the eligible bytes
+// of audio. It returns the fixed header and the file key that opened it,
+// along with the payload and header when those can be read too, else the
+// reason they cannot; nil when no fixed header opens.
+func tryDecodeFixed(audio []byte, eligible []int, key string, dbg bool) (*fixedPayload, error) {
+    n := len(audio)
+    if eligible != nil { n = len(eligible) }
+    lowBits := func(idx []int) []byte {
+        bw := bitstream.NewBitWriter(len(idx))
+        for _, p := range idx {
+            if eligible != nil { p = eligible[p] }
+            bw.WriteBits(uint64(audio[p]&1), 1)
+        }
+        return bw.Bytes()
+    }
     
-    pay := mb[metaLen:]
-    if int(h.Size) > len(pay) { return nil, nil, false }
-    pay = pay[:h.Size]
+    // The salt block of version 3 comes first, its parameters are only
+    // in bounds for a real one
+    var keys []meta.FileKey
+    if n >= meta.SaltBits {
+        block := make([]int, meta.SaltBits)
+        for i := range block { block[i] = i }
+        if p, salt, ok := meta.UnpackSalt(lowBits(block)); ok {
+            if dbg {
+                fmt.Printf("[DBG] units=%d salt=%x kdf=%s\n", n, salt, p)
+            }
+            if fk, err := meta.KeyV3(key, p, salt); err == nil {
+                keys = append(keys, fk)
+            }
+        }
+    }
+    keys = append(keys, meta.KeyV2(key))
     
-    return pay, &h, true
+    for _, fk := range keys {
+        positions := fk.Positions()
+        if n <= positions[len(positions)-1] { continue }
+        fixed := lowBits(positions)
+        fx, ok := fk.Open(fixed)
+        if dbg {
+            fmt.Printf("[DBG] units=%d version=%d fixed=%x valid=%v\n", n, fk.Version(), fixed, ok)
+        }
+        if !ok { continue }
+        found := &fixedPayload{fx: &fx, fk: &fk}
+        w := int(fx.Width)
+        if w < 1 || w > 4 || fx.Matrix > meta.MaxMatrix || fx.Matrix > 0 && w != 1 {
+            return found, &IntegrityError{"the fixed header is damaged"}
+        }
+        if dbg {
+            fmt.Printf("[DBG] version=%d width=%d mode=%d algorithm=%d parity=%d depth=%d matrix=%d\n", fx.Version, fx.Width, fx.Mode, fx.Algorithm, fx.Parity, fx.Depth, fx.Matrix)
+        }
+        
+        // The units not holding the salt block or fixed header, in
+        // embedding order, k bits to a block when matrix embedded
+        read := func(order, skip []int) *bitstream.BitReader {
+            if fx.Matrix > 0 {
+                return bitstream.NewMatrixReader(audio, order, skip, int(fx.Matrix))
+            }
+            return bitstream.NewUnitReader(audio, order, skip, w)
+        }
+        skip := fk.Skip()
+        var stream *bitstream.BitReader
+        if fx.Mode&meta.ModeRandom != 0 {
+            order := make([]int, 0, n-len(skip))
+            for i := 0; i < n; i++ {
+                if len(skip) > 0 && skip[0] == i {
+                    skip = skip[1:]
+                    continue
+                }
+                if eligible != nil {
+                    order = append(order, eligible[i])
+                } else {
+                    order = append(order, i)
+                }
+            }
+            fk.Shuffle(order)
+            stream = read(order, nil)
+        } else {
+            stream = read(eligible, skip)
+        }
+        
+        if fx.Parity > 0 {
+            var err error
+            if stream, found.corrected, err = uncoded(stream, &fx); err != nil {
+                return found, err
+            }
+        }
+        
+        h, ok := meta.Read(stream)
+        if !ok { return found, &IntegrityError{"the header is damaged"} }
+        pay, err := readPayload(stream, &h, w)
+        found.pay, found.h = pay, &h
+        return found, err
+    }
+    return nil, nil
+}
+
+// decryptWriter returns the writer that decrypts the payload of h into w.
+// fk is the key the fixed header was opened with, nil in version 1 files.
+func decryptWriter(w io.Writer, h *meta.Header, opts *Options, fk *meta.FileKey) (io.Writer, error) {
+    aead := h.Flags & (meta.FlagAES256GCM | meta.FlagChaCha20Poly1305)
+    switch {
+    case aead != 0:
+        // Only version 3 files have a payload key or recipients
+        if fk == nil || fk.Version() < meta.Version3 || aead == meta.FlagAES256GCM|meta.FlagChaCha20Poly1305 {
+            return nil, service.ErrWrongKey
+        }
+        name := service.CipherAES256GCM
+        if aead == meta.FlagChaCha20Poly1305 {
+            name = service.CipherChaCha20Poly1305
+        }
+        if h.Flags&meta.FlagRecipients != 0 {
+            if len(opts.Identities) == 0 {
+                return nil, fmt.Errorf("the payload is encrypted to recipients, a secret key is needed to decrypt it")
+            }
+            return service.DecryptForWriter(name, opts.Identities, w, int64(h.Size)), nil
+        }
+        c, err := service.NewAEADCipher(name, fk.PayloadKey())
+        if err != nil {
+            return nil, err
+        }
+        return c.DecryptWriter(w, int64(h.Size)), nil
+    case h.Flags&meta.FlagEncrypted != 0:
+        return service.NewExtendedVigenereCipher(opts.Key).DecryptWriter(w), nil
+    }
+    return w, nil
+}
+
+// Options selects how a payload is looked for and decrypted.
+type Options struct {
+    Key    string
+    Random bool // try random positions first in files of format version 1
+    Debug  bool
+    // Identities are the X25519 secret keys tried on payloads encrypted
+    // to recipients
+    Identities []*service.Identity
+    // Trusted are the keys whose signatures are verified
+    Trusted []service.TrustedKey
+}
+
+// Result describes an extracted payload.
+type Result struct {
+    Output    string
+    Header    *meta.Header
+    Width     int
+    Integrity string // what the payload was checked with
+    Signature service.SignatureReport
+    ECC       string // error correction the bit stream was coded with, empty for none
+    Corrected int    // bytes it corrected
 }
 
-// DecodeFile decodes a steganographic MP3 file and extracts the hidden payload
-func DecodeFile(inputFile, key, outputFileName string, random, debug bool) (string, error) {
-    // Read input file
-    b, err := os.ReadFile(inputFile)
+// fixedResult is the result of a payload found behind a fixed header.
+func fixedResult(h *meta.Header, fx *meta.Fixed, corrected int) *Result {
+    res := &Result{Header: h, Width: int(fx.Width), Corrected: corrected}
+    if fx.Parity > 0 {
+        res.ECC = rs.Layout{Parity: int(fx.Parity), Depth: int(fx.Depth)}.String()
+    }
+    return res
+}
+
+// DecodeFile decodes a steganographic MP3, WAV, FLAC or AIFF file and extracts the hidden payload
+func DecodeFile(inputFile, outputFileName string, opts Options) (*Result, error) {
+    in, err := os.Open(inputFile)
     outputDir:= "output"
     if err != nil {
-        return "",fmt.Errorf("failed to read input file: %v", err)
+        return nil, fmt.Errorf("failed to read input file: %v", err)
     }
+    defer in.Close()
+
+    var fname string
+    var out *os.File
+    open := func(h *meta.Header) (io.Writer, error) {
+        // Determine output filename
+        if outputDir != "" {
+            if outputFileName == "" {
+                outputFileName = h.Name
+            }
+            base := filepath.Base(outputFileName)
+            ext := filepath.Ext(base)
+            outputFileName = strings.TrimSuffix(base, ext) 
+            fname = filepath.Join(outputDir, outputFileName)
+        
+        } else {
+            fname = h.Name
+        }
     
-    // Parse MP3
-    f, err := mp3.Parse(b)
+        dir := filepath.Dir(fname)
+        if dir != "." {
+            if err := os.MkdirAll(dir, 0755); err != nil {
+                return nil, fmt.Errorf("failed to create output directory: %v", err)
+            }
+        }
+
+        // // Handle filename conflicts
+        // originalFname := fname
+        // counter := 1
+        // for {
+        //     if _, err := os.Stat(fname); os.IsNotExist(err) {
+        //         break
+        //     }
+        //     // File exists, create new name
+        //     ext := filepath.Ext(originalFname)
+        //     nameWithoutExt := strings.TrimSuffix(originalFname, ext)
+        //     fname = fmt.Sprintf("%s_%d%s", nameWithoutExt, counter, ext)
+        //     counter++
+        // }
+
+        out, err = os.Create(fname + h.Ext)
+        if err != nil {
+            return nil, err
+        }
+        return out, nil
+    }
+
+    res, err := Decode(in, opts, open)
+    if out != nil {
+        if cerr := out.Close(); err == nil && cerr != nil {
+            err = fmt.Errorf("failed to write output file: %v", cerr)
+        }
+        if err != nil {
+            os.Remove(out.Name())
+        }
+    }
     if err != nil {
-        return "",fmt.Errorf("failed to parse MP3: %v", err)
+        return nil, err
     }
-    
-    // Extract audio data
-    var audio []byte
-    for _, fr := range f.Frames {
-        audio = append(audio, fr.Data...)
+
+    h := res.Header
+    fmt.Printf("Successfully decoded: width=%d bytes=%d file=%s (type: %s)\n", 
+        res.Width, h.Size, fname, h.Ext)
+
+    res.Output = fname + h.Ext
+    fmt.Printf("Integrity: %s verified\n", res.Integrity)
+    if res.ECC != "" {
+        fmt.Printf("Error correction: %s, %d bytes corrected\n", res.ECC, res.Corrected)
     }
-    
-    // Try different combinations of width and randomization
-    for _, w := range []int{1, 2, 3, 4} {
-        for _, rnd := range []bool{random, !random} {
-            pay, h, ok := tryDecode(audio, key, rnd, w, debug)
-            if !ok {
-                continue
+    if h.Compression != meta.CompressionNone {
+        fmt.Printf("Compression: %s, undone\n", h.Compression)
+    }
+    fmt.Printf("Signature: %s\n", res.Signature)
+    return res, nil
+}
+
+// decode tries every candidate layout on the whole file b.
+func decode(b []byte, opts Options, open func(*meta.Header) (io.Writer, error)) (*Result, error) {
+    key, random, debug := opts.Key, opts.Random, opts.Debug
+    // Parse stego audio and extract the embeddable bytes. MP3 payloads live
+    // in the main data bytes only; older stego files used every frame byte
+    // (including the VBR tag frame, and of other frames in MPEG-2 and 2.5
+    // streams), so those layouts are tried afterwards.
+    // Payloads embedded with the Huffman algorithm are read from the
+    // parities of the non-zero coefficients, and ancillary ones from the
+    // bits decoders ignore, one bit each. ID3v2 carriers hold the bits
+    // packed into PRIV/GEOB objects or the tag padding.
+    type candidate struct {
+        audio    []byte
+        eligible []int
+        load     func() []byte    // computes audio when it is first needed
+        holds    []meta.Algorithm // version 2 payloads it can carry, none for older layouts
+    }
+    id3Algorithms := []meta.Algorithm{meta.AlgorithmID3PRIV, meta.AlgorithmID3GEOB, meta.AlgorithmID3Padding}
+    lsb := []meta.Algorithm{meta.AlgorithmLSB}
+    var candidates []candidate
+    c, _, err := cover.Parse(b)
+    if err != nil {
+        return nil, err
+    }
+    audio := c.Units()
+    mc, isMP3 := c.(*cover.MP3)
+    if !isMP3 {
+        candidates = append(candidates, candidate{audio: audio, holds: lsb})
+    } else {
+        f := mc.File
+        if tag, err := id3.Parse(f.ID3v2); err == nil {
+            for _, fr := range tag.Frames {
+                if _, data, ok := tag.Object(fr); ok {
+                    candidates = append(candidates, candidate{audio: payload.ToBits(data), holds: id3Algorithms})
+                }
             }
-            
-            // Decrypt if encrypted
-            if (h.Flags & meta.FlagEncrypted) != 0 {
-                pay = service.NewExtendedVigenereCipher(key).Decrypt(pay)
+            // The first padding byte is never used, see the encoder
+            if len(tag.Padding) > 1 {
+                candidates = append(candidates, candidate{audio: payload.ToBits(tag.Padding[1:]), holds: id3Algorithms})
             }
-            
-            // Determine output filename
-            var fname string
-            if outputDir != "" {
-                if outputFileName == "" {
-                    outputFileName = h.Name
+        }
+        // Payloads embedded without touching CRC-protected bytes use a
+        // smaller set of positions and covert bits
+        el := f.Eligibility()
+        candidates = append(candidates, candidate{audio: audio, eligible: el.Positions(), holds: lsb})
+        if el.ExcludeProtected(); el.Protected > 0 {
+            candidates = append(candidates, candidate{audio: audio, eligible: el.Positions(), holds: lsb})
+        }
+        candidates = append(candidates, candidate{audio: audio})
+        candidates = append(candidates, candidate{
+            load: func() []byte {
+                if md, err := f.DecodeMainData(); err == nil {
+                    return md.Parities()
                 }
-                base := filepath.Base(outputFileName)
-                ext := filepath.Ext(base)
-                outputFileName = strings.TrimSuffix(base, ext) 
-                fname = filepath.Join(outputDir, outputFileName)
-                
-            } else {
-                fname = h.Name
+                return nil
+            },
+            holds: []meta.Algorithm{meta.AlgorithmHuffman},
+        })
+        all, unprotected := f.Covert(true), f.Covert(false)
+        candidates = append(candidates, candidate{audio: all.Bits(), holds: []meta.Algorithm{meta.AlgorithmAncillary}})
+        if unprotected.Len() != all.Len() {
+            candidates = append(candidates, candidate{audio: unprotected.Bits(), holds: []meta.Algorithm{meta.AlgorithmAncillary}})
+        }
+        // Version 1 files used the frames of the first releases' frame
+        // walk, the VBR tag frame included. Those of MPEG-1 streams without
+        // a tag frame are the ones above, MPEG-2 and 2.5 frames differ
+        if legacy := mp3.LegacyFrameData(b); len(legacy) > 0 && !bytes.Equal(legacy, audio) {
+            candidates = append(candidates, candidate{audio: legacy})
+        }
+    }
+    units := func(c *candidate) []byte {
+        if c.load != nil {
+            c.audio, c.load = c.load(), nil
+        }
+        return c.audio
+    }
+    // The payload is decrypted in memory and checked before anything is
+    // written. A payload failing its checks leaves the next candidates to
+    // try, bad reports it when none is left
+    var bad error
+    failed := func(err error) bool {
+        if _, ok := err.(*IntegrityError); ok {
+            bad = err
+            return true
+        }
+        return false
+    }
+    write := func(pay []byte, h *meta.Header, fk *meta.FileKey) error {
+        var secret bytes.Buffer
+        var plain io.Writer = &secret
+        sum := h.Checksum.New()
+        if sum != nil {
+            plain = io.MultiWriter(&secret, sum)
+        }
+        // Decompress if compressed
+        unzip, err := decompressor(plain, h)
+        if err != nil {
+            return err
+        }
+        if unzip != nil {
+            defer unzip.Close()
+            plain = unzip
+        }
+        // Decrypt if encrypted
+        dw, err := decryptWriter(plain, h, &opts, fk)
+        if err != nil {
+            return err
+        }
+        if _, err := dw.Write(pay); err != nil {
+            return malformed(err)
+        }
+        if unzip != nil {
+            if err := unzip.Close(); err != nil {
+                return malformed(err)
             }
-            
-            dir := filepath.Dir(fname)
-            if dir != "." {
-                if err := os.MkdirAll(dir, 0755); err != nil {
-                    return "", fmt.Errorf("failed to create output directory: %v", err)
-                }
+        }
+        if err := verify(h, sum); err != nil {
+            return err
+        }
+        out, err := open(h)
+        if err != nil {
+            return err
+        }
+        if _, err := out.Write(secret.Bytes()); err != nil {
+            return fmt.Errorf("failed to write output file: %v", err)
+        }
+        return nil
+    }
+    
+    // Newer files say where everything is in the fixed header. The
+    // carriers that take long to compute come last
+    for i := range 2 * len(candidates) {
+        c := &candidates[i%len(candidates)]
+        if len(c.holds) == 0 || (c.load != nil) != (i >= len(candidates)) {
+            continue
+        }
+        found, err := tryDecodeFixed(units(c), c.eligible, key, debug)
+        if found == nil || !slices.Contains(c.holds, found.fx.Algorithm) {
+            continue
+        }
+        if failed(err) {
+            continue
+        }
+        if err := write(found.pay, found.h, found.fk); err != nil {
+            if failed(err) {
+                continue
             }
-            
-            // // Handle filename conflicts
-            // originalFname := fname
-            // counter := 1
-            // for {
-            //     if _, err := os.Stat(fname); os.IsNotExist(err) {
-            //         break
-            //     }
-            //     // File exists, create new name
-            //     ext := filepath.Ext(originalFname)
-            //     nameWithoutExt := strings.TrimSuffix(originalFname, ext)
-            //     fname = fmt.Sprintf("%s_%d%s", nameWithoutExt, counter, ext)
-            //     counter++
-            // }
-            
-            // Write output file
-            if err := os.WriteFile(fname+h.Ext, pay, 0644); err != nil {
-                return "", fmt.Errorf("failed to write output file: %v", err)
+            return nil, err
+        }
+        return fixedResult(found.h, found.fx, found.corrected), nil
+    }
+    
+    // Try different combinations of width and randomization
+    for i := range candidates {
+        c := &candidates[i]
+        if units(c) == nil {
+            continue
+        }
+        for _, w := range []int{1, 2, 3, 4} {
+            for _, rnd := range []bool{random, !random} {
+                pay, h, err := tryDecode(c.audio, c.eligible, key, rnd, w, debug)
+                if failed(err) || h == nil {
+                    continue
+                }
+                if err := write(pay, h, nil); err != nil {
+                    if failed(err) {
+                        continue
+                    }
+                    return nil, err
+                }
+                return &Result{Header: h, Width: w}, nil
             }
-            
-            fmt.Printf("Successfully decoded: width=%d bytes=%d file=%s (type: %s)\n", 
-                w, len(pay), fname, h.Ext)
-            
-            return fname+h.Ext, nil
         }
     }
     
-    return "",fmt.Errorf("signature not found - no hidden data detected")
+    if bad != nil {
+        return nil, bad
+    }
+    // No fixed header opened with the key, which is as likely wrong as
+    // there being no payload
+    if key != "" {
+        return nil, ErrNotFound
+    }
+    return nil, fmt.Errorf("signature not found - no hidden data detected")
 }
@@ -0,0 +1,108 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/meta"
+	"github.com/rifchzschki/Audio-Steganografi/backend/service/encoder"
+)
+
+// wavCover returns a 44.1 kHz stereo 16-bit WAV file of frames frames of a
+// tone with a little noise. The benchmarks use 10 minutes of it, the cover
+// the encoder benchmarks use.
+func wavCover(frames int) []byte {
+	const rate, channels = 44100, 2
+	size := frames * channels * 2
+	out := make([]byte, 44, 44+size)
+	copy(out[0:], "RIFF")
+	binary.LittleEndian.PutUint32(out[4:], uint32(36+size))
+	copy(out[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(out[16:], 16)
+	binary.LittleEndian.PutUint16(out[20:], 1)
+	binary.LittleEndian.PutUint16(out[22:], channels)
+	binary.LittleEndian.PutUint32(out[24:], rate)
+	binary.LittleEndian.PutUint32(out[28:], rate*channels*2)
+	binary.LittleEndian.PutUint16(out[32:], channels*2)
+	binary.LittleEndian.PutUint16(out[34:], 16)
+	copy(out[36:], "data")
+	binary.LittleEndian.PutUint32(out[40:], uint32(size))
+
+	rsrc := rand.New(rand.NewSource(1))
+	var tmp [2]byte
+	for i := 0; i < frames; i++ {
+		v := 8000*math.Sin(2*math.Pi*440*float64(i)/rate) + rsrc.NormFloat64()*200
+		binary.LittleEndian.PutUint16(tmp[:], uint16(int16(v)))
+		for c := 0; c < channels; c++ {
+			out = append(out, tmp[:]...)
+		}
+	}
+	return out
+}
+
+// quiet runs fn with the reports printed on stdout discarded.
+func quiet(b *testing.B, fn func() error) {
+	b.Helper()
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer devNull.Close()
+	stdout := os.Stdout
+	os.Stdout = devNull
+	defer func() { os.Stdout = stdout }()
+	if err := fn(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	cover := wavCover(10 * 60 * 44100)
+	cases := []struct {
+		name   string
+		width  int
+		secret int
+		random bool
+	}{
+		{"random/width=1", 1, 1 << 20, true},
+		{"random/width=4", 4, 4 << 20, true}, // every width is tried
+		{"sequential/width=1", 1, 1 << 20, false},
+	}
+	for _, bc := range cases {
+		b.Run(bc.name, func(b *testing.B) {
+			secret := make([]byte, bc.secret)
+			rand.New(rand.NewSource(1)).Read(secret)
+			opts := encoder.Options{Key: "STEGANO", Width: bc.width, Algorithm: encoder.AlgorithmLSB, CRC: encoder.CRCRecompute, Random: bc.random}
+			var stego bytes.Buffer
+			quiet(b, func() error {
+				_, err := encoder.Encode(&stego, bytes.NewReader(cover), bytes.NewReader(secret), "secret.bin", int64(len(secret)), opts)
+				return err
+			})
+
+			var extracted bytes.Buffer
+			decode := func() error {
+				_, err := Decode(bytes.NewReader(stego.Bytes()), Options{Key: "STEGANO", Random: bc.random}, func(*meta.Header) (io.Writer, error) {
+					extracted.Reset()
+					return &extracted, nil
+				})
+				return err
+			}
+			quiet(b, decode)
+			if !bytes.Equal(extracted.Bytes(), secret) {
+				b.Fatal("decoded secret differs from the embedded one")
+			}
+
+			b.SetBytes(int64(stego.Len()))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				quiet(b, decode)
+			}
+		})
+	}
+}
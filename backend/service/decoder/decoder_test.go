@@ -0,0 +1,192 @@
+package decoder
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/meta"
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/mp3"
+	"github.com/rifchzschki/Audio-Steganografi/backend/service"
+	"github.com/rifchzschki/Audio-Steganografi/backend/service/encoder"
+)
+
+// The testdata files were made by the first release's encoder with the key
+// STEGANO from an MPEG-2 Layer III cover, whose frames it sized with the
+// MPEG-1 formula.
+const v1Secret = "Hidden with the first release in an MPEG-2 Layer III cover.\n"
+
+func TestDecodeVersion1MPEG2(t *testing.T) {
+	tests := []struct {
+		file  string
+		width int
+	}{
+		{"testdata/v1_mpeg2_sequential.mp3", 2},
+		{"testdata/v1_mpeg2_random.mp3", 1}, // also Vigenere encrypted
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := mp3.Parse(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Frames[0].Header.VersionID != mp3.MPEG2 {
+				t.Fatal("fixture is not an MPEG-2 stream")
+			}
+
+			// Files that cannot be rewound are kept as they are read
+			readers := map[string]io.Reader{
+				"seeker":     bytes.NewReader(data),
+				"not seeker": io.MultiReader(bytes.NewReader(data)),
+			}
+			for name, r := range readers {
+				var out bytes.Buffer
+				res, err := Decode(r, Options{Key: "STEGANO", Random: true}, func(*meta.Header) (io.Writer, error) {
+					out.Reset()
+					return &out, nil
+				})
+				if err != nil {
+					t.Fatalf("%s: decode: %v", name, err)
+				}
+				if out.String() != v1Secret {
+					t.Fatalf("%s: decoded %q, want %q", name, out.String(), v1Secret)
+				}
+				if res.Width != tt.width || res.Header.Name != "v1.txt" || res.Header.Ext != ".txt" {
+					t.Errorf("%s: got width %d, name %q and extension %q", name, res.Width, res.Header.Name, res.Header.Ext)
+				}
+			}
+		})
+	}
+}
+
+// embed hides secret in a short WAV cover and returns the stego file.
+func embed(t *testing.T, secret []byte, opts encoder.Options) []byte {
+	t.Helper()
+	var stego bytes.Buffer
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	stdout := os.Stdout
+	os.Stdout = devNull
+	_, err = encoder.Encode(&stego, bytes.NewReader(wavCover(44100)), bytes.NewReader(secret), "secret.bin", int64(len(secret)), opts)
+	os.Stdout = stdout
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	return stego.Bytes()
+}
+
+func TestDecodeOpensOnlyVerified(t *testing.T) {
+	secret := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(secret)
+	stego := embed(t, secret, encoder.Options{Key: "STEGANO", Width: 1})
+
+	decode := func(data []byte) (int, []byte, error) {
+		opened := 0
+		var out bytes.Buffer
+		_, err := Decode(bytes.NewReader(data), Options{Key: "STEGANO"}, func(*meta.Header) (io.Writer, error) {
+			opened++
+			return &out, nil
+		})
+		return opened, out.Bytes(), err
+	}
+
+	opened, out, err := decode(stego)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if opened != 1 || !bytes.Equal(out, secret) {
+		t.Fatalf("opened %d times, decoded %d bytes", opened, len(out))
+	}
+
+	// Flip the lowest bit of a sample halfway through the payload, the
+	// checksum no longer matches
+	damaged := bytes.Clone(stego)
+	damaged[44+2*len(secret)*8/2] ^= 1
+	opened, out, err = decode(damaged)
+	var ie *IntegrityError
+	if !errors.As(err, &ie) {
+		t.Fatalf("decode of a damaged payload: %v", err)
+	}
+	if opened != 0 || len(out) != 0 {
+		t.Fatalf("a damaged payload was opened %d times and %d bytes written", opened, len(out))
+	}
+}
+
+func TestDecodeWrongKey(t *testing.T) {
+	secret := []byte("only for the right key")
+	tests := []struct {
+		name string
+		opts encoder.Options
+	}{
+		{"plain", encoder.Options{Key: "STEGANO", Width: 1}},
+		{"random", encoder.Options{Key: "STEGANO", Width: 2, Random: true}},
+		{"aes-256-gcm", encoder.Options{Key: "STEGANO", Width: 1, Encryption: encoder.EncryptionAES256GCM}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stego := embed(t, secret, tt.opts)
+			opened := false
+			_, err := Decode(bytes.NewReader(stego), Options{Key: "WRONG", Random: tt.opts.Random}, func(*meta.Header) (io.Writer, error) {
+				opened = true
+				return io.Discard, nil
+			})
+			if !errors.Is(err, ErrNotFound) {
+				t.Fatalf("decode with a wrong key: %v, want %v", err, ErrNotFound)
+			}
+			if opened {
+				t.Fatal("a payload was opened with a wrong key")
+			}
+		})
+	}
+}
+
+func TestDecodeSignature(t *testing.T) {
+	signer, err := service.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("signed secret")
+	trusted := []service.TrustedKey{{Name: "alice", Key: signer.Public()}}
+	tests := []struct {
+		name    string
+		opts    encoder.Options
+		trusted []service.TrustedKey
+		want    string
+	}{
+		{"unsigned", encoder.Options{Key: "STEGANO", Width: 1}, trusted, service.SignatureNone},
+		{"trusted", encoder.Options{Key: "STEGANO", Width: 1, Signer: signer}, trusted, service.SignatureVerified},
+		{"not trusted", encoder.Options{Key: "STEGANO", Width: 1, Signer: signer}, nil, service.SignatureUntrusted},
+		// Found by the in-memory decoder
+		{"random", encoder.Options{Key: "STEGANO", Width: 1, Random: true, Signer: signer}, trusted, service.SignatureVerified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stego := embed(t, secret, tt.opts)
+			res, err := Decode(bytes.NewReader(stego), Options{Key: "STEGANO", Trusted: tt.trusted}, func(*meta.Header) (io.Writer, error) {
+				return io.Discard, nil
+			})
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if res.Signature.Status != tt.want {
+				t.Fatalf("signature %s, want %s", res.Signature, tt.want)
+			}
+			if tt.want == service.SignatureVerified && res.Signature.Signer != "alice" {
+				t.Fatalf("signed by %q, want alice", res.Signature.Signer)
+			}
+			if res.Integrity != "crc32c checksum and end marker" {
+				t.Fatalf("integrity %q", res.Integrity)
+			}
+		})
+	}
+}
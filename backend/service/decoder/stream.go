@@ -0,0 +1,525 @@
+package decoder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/cover"
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/meta"
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/mp3"
+	"github.com/rifchzschki/Audio-Steganografi/backend/service"
+	"github.com/rifchzschki/Audio-Steganografi/backend/utils/bitstream"
+	"github.com/rifchzschki/Audio-Steganografi/backend/utils/rs"
+	"github.com/rifchzschki/Audio-Steganografi/backend/utils/sig"
+)
+
+// Decode extracts the payload hidden in the stego file read from r. open
+// is called with the header once the payload is found and has passed its
+// checks, and returns where the secret goes. Decode returns the header,
+// the width it was found with, what error correction fixed on the way and
+// the signature checked against opts.Trusted.
+func Decode(r io.Reader, opts Options, open func(h *meta.Header) (io.Writer, error)) (*Result, error) {
+	// The signature covers the secret as written
+	digest := service.NewDigest()
+	res, err := find(r, opts, func(h *meta.Header) (io.Writer, error) {
+		w, err := open(h)
+		if err != nil {
+			return nil, err
+		}
+		return io.MultiWriter(w, digest), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	h := res.Header
+	res.Integrity = "end marker"
+	if h.Checksum.New() != nil {
+		res.Integrity = h.Checksum.String() + " checksum and end marker"
+	}
+	res.Signature = service.Verify(h.Signer, h.Signature, meta.Signed(*h), digest.Sum(nil), opts.Trusted)
+	return res, nil
+}
+
+// find looks for the payload for Decode.
+//
+// The units of MP3 and WAV files are first scanned for a sequential
+// payload in one pass with bounded memory: a version 3 or 2 fixed header that
+// says so or, without random order, an older signature. Anything else,
+// random order, the MP3 specific algorithms and older layouts, needs the
+// whole file in memory; a file that is not an io.Seeker is then kept as it
+// is read. opts.Random only picks the order older layouts are tried in.
+// Version 1 files made from MPEG-2 and 2.5 covers are always found there,
+// they were embedded in frames the stream's units do not line up with.
+func find(r io.Reader, opts Options, open func(h *meta.Header) (io.Writer, error)) (*Result, error) {
+	var start int64
+	seeker, canSeek := r.(io.Seeker)
+	if canSeek {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			canSeek = false
+		}
+	}
+
+	br := bufio.NewReaderSize(r, mp3.StreamBuffer)
+	format, err := cover.DetectStream(br)
+	if err != nil {
+		return nil, err
+	}
+
+	var kept bytes.Buffer
+	if sf, ok := format.(cover.StreamFormat); ok {
+		src := io.Reader(br)
+		if !canSeek {
+			src = io.TeeReader(br, &kept)
+		}
+		res, err := decodeStream(src, sf, opts, open)
+		if res != nil || err != nil {
+			return res, err
+		}
+		if canSeek {
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return nil, fmt.Errorf("failed to read input file: %v", err)
+			}
+			br.Reset(r)
+		}
+	}
+	if _, err := kept.ReadFrom(br); err != nil {
+		return nil, fmt.Errorf("failed to read input file: %v", err)
+	}
+	return decode(kept.Bytes(), opts, open)
+}
+
+// Stream stops early with these.
+var (
+	errFound    = errors.New("payload found")
+	errNotFound = errors.New("no sequential payload")
+)
+
+// decodeStream looks for a sequential payload while the file streams by,
+// older layouts only when random is false. It returns a nil result and
+// error when there is none, when it fails its integrity checks or when the
+// file cannot be streamed; only failures to decrypt or write the payload
+// are errors. The secret is kept in a temporary file until the end marker
+// and checksum are read, open only gets it once they pass.
+func decodeStream(r io.Reader, format cover.StreamFormat, opts Options, open func(*meta.Header) (io.Writer, error)) (*Result, error) {
+	key := opts.Key
+	var found *lsbScanner
+	var werr, derr error
+	var spool *os.File
+	defer func() {
+		if spool != nil {
+			spool.Close()
+			os.Remove(spool.Name())
+		}
+	}()
+	start := func(s *lsbScanner) error {
+		found = s
+		var err error
+		if spool, err = os.CreateTemp("", "secret-*"); err != nil {
+			werr = err
+			return err
+		}
+		w := io.Writer(spool)
+		// The checksum is of the secret as written
+		if s.sum = s.h.Checksum.New(); s.sum != nil {
+			w = io.MultiWriter(w, s.sum)
+		}
+		if s.unzip, err = decompressor(w, &s.h); err != nil {
+			derr = err
+			return err
+		}
+		if s.unzip != nil {
+			w = s.unzip
+		}
+		if w, err = decryptWriter(w, &s.h, &opts, s.fk); err != nil {
+			derr = err
+			return err
+		}
+		s.out = bufio.NewWriter(w)
+		return nil
+	}
+	scanners := []unitScanner{newFixedScanner(key, meta.Version3, start), newFixedScanner(key, meta.Version2, start)}
+	for w := 1; w <= 4 && !opts.Random; w++ {
+		scanners = append(scanners, &lsbScanner{width: w, sig: uint16(sig.Map[w].S), open: start})
+	}
+
+	_, err := format.Stream(io.Discard, r, false, func(units []byte, eligible []bool) error {
+		for k, u := range units {
+			if eligible != nil && !eligible[k] {
+				continue
+			}
+			if found != nil {
+				if err := found.push(u); err != nil {
+					return err
+				}
+				// Beyond what the code corrects, left to the decoder
+				// that tries every candidate too
+				if found.dead() {
+					return errNotFound
+				}
+			} else {
+				alive := false
+				for _, s := range scanners {
+					if s.dead() {
+						continue
+					}
+					if err := s.push(u); err != nil {
+						return err
+					}
+					if found != nil {
+						break
+					}
+					alive = alive || !s.dead()
+				}
+				if found == nil && !alive {
+					return errNotFound
+				}
+			}
+			if found != nil && found.state == scanDone {
+				err := found.out.Flush()
+				if cerr := found.finish(); err == nil {
+					err = cerr
+				}
+				// A payload failing its checks is left to the decoder
+				// that tries every candidate, which reports it
+				if errors.Is(err, meta.ErrMalformed) || !found.ended || verify(&found.h, found.sum) != nil {
+					return errNotFound
+				}
+				if err != nil {
+					werr = err
+					return err
+				}
+				if werr = deliver(spool, &found.h, open); werr != nil {
+					return werr
+				}
+				return errFound
+			}
+		}
+		return nil
+	})
+	if found != nil {
+		found.finish()
+	}
+	switch {
+	case derr != nil:
+		return nil, derr
+	case werr == service.ErrWrongKey || werr == service.ErrNotRecipient:
+		return nil, werr
+	case werr != nil:
+		return nil, fmt.Errorf("failed to write output file: %v", werr)
+	case err == errFound && found.fx != nil:
+		corrected := 0
+		if found.ecc != nil {
+			corrected = found.ecc.Corrected()
+		}
+		return fixedResult(&found.h, found.fx, corrected), nil
+	case err == errFound:
+		return &Result{Header: &found.h, Width: found.width}, nil
+	}
+	return nil, nil
+}
+
+// deliver copies the secret kept in spool to the writer open returns for h.
+func deliver(spool *os.File, h *meta.Header, open func(*meta.Header) (io.Writer, error)) error {
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	w, err := open(h)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, spool)
+	return err
+}
+
+// unitScanner follows one possible layout through the units.
+type unitScanner interface {
+	push(u byte) error
+	dead() bool
+}
+
+// fixedScanner reads the salt block and fixed header of one format version
+// from the first units and goes on with an lsbScanner for the header and
+// payload when the fixed header says they follow in sequential order.
+type fixedScanner struct {
+	key    string
+	fk     *meta.FileKey // nil until the salt block is read
+	skip   []int         // units holding no payload bits, once fk is known
+	held   []byte
+	failed bool
+	body   *lsbScanner
+	open   func(s *lsbScanner) error
+}
+
+func newFixedScanner(key string, version uint8, open func(s *lsbScanner) error) *fixedScanner {
+	s := &fixedScanner{key: key, open: open}
+	if version == meta.Version2 {
+		s.setKey(meta.KeyV2(key))
+	}
+	return s
+}
+
+func (s *fixedScanner) setKey(fk meta.FileKey) {
+	s.fk, s.skip = &fk, fk.Skip()
+}
+
+// lowBits packs the lowest bit of the held units at idx.
+func (s *fixedScanner) lowBits(idx []int) []byte {
+	bw := bitstream.NewBitWriter(len(idx))
+	for _, i := range idx {
+		bw.WriteBits(uint64(s.held[i]&1), 1)
+	}
+	return bw.Bytes()
+}
+
+func (s *fixedScanner) dead() bool {
+	return s.failed || s.body != nil && s.body.dead()
+}
+
+func (s *fixedScanner) push(u byte) error {
+	if s.body != nil {
+		return s.body.push(u)
+	}
+	if s.failed {
+		return nil
+	}
+	s.held = append(s.held, u)
+	if s.fk == nil {
+		if len(s.held) < meta.SaltBits {
+			return nil
+		}
+		block := make([]int, meta.SaltBits)
+		for i := range block {
+			block[i] = i
+		}
+		p, salt, ok := meta.UnpackSalt(s.lowBits(block))
+		if !ok {
+			s.failed, s.held = true, nil
+			return nil
+		}
+		fk, err := meta.KeyV3(s.key, p, salt)
+		if err != nil {
+			s.failed, s.held = true, nil
+			return nil
+		}
+		s.setKey(fk)
+	}
+	if len(s.held) <= s.skip[len(s.skip)-1] {
+		return nil
+	}
+
+	positions := s.skip[len(s.skip)-meta.FixedBits:]
+	fx, ok := s.fk.Open(s.lowBits(positions))
+	if !ok || fx.Mode&meta.ModeRandom != 0 || fx.Algorithm != meta.AlgorithmLSB || fx.Width < 1 || fx.Width > 4 || fx.Matrix > meta.MaxMatrix || fx.Matrix > 0 && fx.Width != 1 {
+		s.failed, s.held = true, nil
+		return nil
+	}
+	s.body = &lsbScanner{width: int(fx.Width), state: scanHeader, fk: s.fk, fx: &fx, open: s.open}
+	if fx.Matrix > 0 {
+		s.body.k, s.body.block = int(fx.Matrix), 1<<fx.Matrix-1
+	}
+	if fx.Parity > 0 {
+		code := rs.Layout{Parity: int(fx.Parity), Depth: int(fx.Depth)}
+		if code.Check() != nil {
+			s.failed, s.held = true, nil
+			return nil
+		}
+		s.body.ecc = code.NewDecoder(decodedBits{s.body})
+	}
+	skip := s.skip
+	for i, v := range s.held {
+		if len(skip) > 0 && skip[0] == i {
+			skip = skip[1:]
+			continue
+		}
+		if err := s.body.push(v); err != nil {
+			return err
+		}
+	}
+	s.held = nil
+	return nil
+}
+
+// Scanner states
+const (
+	scanSignature = iota
+	scanWidth
+	scanHeader
+	scanPayload
+	scanEnd
+	scanDone
+	scanDead
+)
+
+// lsbScanner follows the bit stream of one width through the units the way
+// tryDecode reads it in sequential order: the first signature, the width
+// byte, the header, the payload, written to out, then the end marker.
+// Started in the header state it reads the version 2 layout, through ecc
+// when the fixed header says it is Reed-Solomon coded and k bits per
+// block of units when it is matrix embedded.
+type lsbScanner struct {
+	width  int
+	sig    uint16
+	state  int
+	window uint16 // last bits while looking for the signature or end marker
+	seen   int
+	ended  bool // the end marker was found behind the payload
+	acc    byte // bits of the current byte
+	nacc   int
+	hdr    []byte
+	h      meta.Header
+	left   uint64        // payload bytes still to write
+	fk     *meta.FileKey // opened the fixed header, nil before version 2
+	fx     *meta.Fixed   // the fixed header, nil before version 2
+	ecc    *rs.Decoder   // corrects the bits before they are read, nil when not coded
+	cacc   byte          // coded bits of the current byte
+	ncacc  int
+	k      int            // bits per block of matrix embedding
+	block  int            // units per block, 0 without matrix embedding
+	nblock int            // units of the current block seen
+	syn    int            // syndrome of the current block
+	sum    hash.Hash      // checksum of the secret, nil without one
+	unzip  io.WriteCloser // decompresses the secret, nil when it is not compressed
+	out    *bufio.Writer
+	open   func(s *lsbScanner) error
+}
+
+func (s *lsbScanner) dead() bool {
+	return s.state == scanDead
+}
+
+func (s *lsbScanner) push(u byte) error {
+	v, n := int(u), s.width
+	if s.block > 0 {
+		// A block holds the XOR of the one-based indices of its units
+		// with the lowest bit set
+		if s.nblock++; u&1 != 0 {
+			s.syn ^= s.nblock
+		}
+		if s.nblock < s.block {
+			return nil
+		}
+		v, n = s.syn, s.k
+		s.nblock, s.syn = 0, 0
+	}
+	for i := n - 1; i >= 0 && s.state < scanDone; i-- {
+		b := byte(v >> uint(i) & 1)
+		if s.ecc != nil {
+			if err := s.coded(b); err != nil {
+				return err
+			}
+		} else if err := s.bit(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// coded collects a byte of the coded stream and hands it to ecc, which
+// passes the bits on to bit once their codewords are corrected.
+func (s *lsbScanner) coded(b byte) error {
+	s.cacc = s.cacc<<1 | b
+	if s.ncacc++; s.ncacc < 8 {
+		return nil
+	}
+	_, err := s.ecc.Write([]byte{s.cacc})
+	s.cacc, s.ncacc = 0, 0
+	if errors.Is(err, rs.ErrUncorrectable) {
+		s.state = scanDead
+		return nil
+	}
+	return err
+}
+
+// decodedBits feeds the corrected bytes to the scanner bit by bit.
+type decodedBits struct {
+	s *lsbScanner
+}
+
+func (d decodedBits) Write(p []byte) (int, error) {
+	for _, c := range p {
+		for i := 7; i >= 0 && d.s.state < scanDone; i-- {
+			if err := d.s.bit(c >> uint(i) & 1); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(p), nil
+}
+
+func (s *lsbScanner) bit(b byte) error {
+	if s.state == scanSignature {
+		s.window = (s.window<<1 | uint16(b)) & (1<<sig.Len - 1)
+		if s.seen++; s.seen >= sig.Len && s.window == s.sig {
+			s.state = scanWidth
+		}
+		return nil
+	}
+	if s.state == scanEnd {
+		s.window = (s.window<<1 | uint16(b)) & (1<<sig.Len - 1)
+		if s.seen++; s.seen == sig.Len {
+			s.ended = uint64(s.window) == sig.Map[s.width].E
+			s.state = scanDone
+		}
+		return nil
+	}
+	s.acc = s.acc<<1 | b
+	if s.nacc++; s.nacc < 8 {
+		return nil
+	}
+	v := s.acc
+	s.acc, s.nacc = 0, 0
+
+	switch s.state {
+	case scanWidth:
+		if v != sig.WidthByte(s.width) {
+			s.state = scanDead
+			return nil
+		}
+		s.state = scanHeader
+	case scanHeader:
+		s.hdr = append(s.hdr, v)
+		if len(s.hdr) == 4 && binary.BigEndian.Uint32(s.hdr) != meta.Magic {
+			s.state = scanDead
+			return nil
+		}
+		if len(s.hdr) < meta.Need(s.hdr) {
+			return nil
+		}
+		h, ok := meta.Unpack(s.hdr)
+		if !ok {
+			s.state = scanDead
+			return nil
+		}
+		s.h, s.left, s.state = h, h.Size, scanPayload
+		if s.left == 0 {
+			s.end()
+		}
+		return s.open(s)
+	case scanPayload:
+		s.out.WriteByte(v)
+		if s.left--; s.left == 0 {
+			s.end()
+		}
+	}
+	return nil
+}
+
+// end goes on to the end marker behind the payload.
+func (s *lsbScanner) end() {
+	s.state, s.window, s.seen = scanEnd, 0, 0
+}
+
+// finish lets the decompressor write the last of the secret.
+func (s *lsbScanner) finish() error {
+	if s.unzip == nil {
+		return nil
+	}
+	return s.unzip.Close()
+}
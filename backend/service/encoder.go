@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/rifchzschki/Audio-Steganografi/backend/models"
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/id3"
 )
 
 type MP3Encoder struct{}
@@ -16,7 +17,9 @@ func NewMP3Encoder() *MP3Encoder {
 func (e *MP3Encoder) EncodeToBytes(mp3File *models.MP3File) ([]byte, error) {
 	var result []byte
 
-	if mp3File.HasID3v2() && mp3File.ID3v2Data != nil {
+	if mp3File.ID3v2Tag != nil {
+		result = append(result, id3.Serialize(mp3File.ID3v2Tag)...)
+	} else if mp3File.HasID3v2() && mp3File.ID3v2Data != nil {
 		id3v2Header := make([]byte, 10)
 		copy(id3v2Header[0:3], []byte("ID3"))
 		id3v2Header[3] = mp3File.ID3v2.Version[0]
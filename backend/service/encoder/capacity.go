@@ -0,0 +1,228 @@
+package encoder
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/cover"
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/id3"
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/meta"
+	"github.com/rifchzschki/Audio-Steganografi/backend/service"
+	"github.com/rifchzschki/Audio-Steganografi/backend/utils/rs"
+	"github.com/rifchzschki/Audio-Steganografi/backend/utils/sig"
+)
+
+// CapacityMode is the capacity of one algorithm, width and CRC mode.
+type CapacityMode struct {
+	Algorithm string
+	CRC       string // CRC mode, empty when the cover has no protected frames
+	Width     int
+	Matrix    int    // k of matrix embedding, 0 for none
+	Unit      string // what one unit is: a byte, sample, coefficient or bit
+	Eligible  int    // units that may carry data
+	Total     int    // all units of that kind in the cover
+	Bytes     int    // raw capacity, Eligible * Width / 8, or k bits per block
+	MaxSecret int    // largest secret that fits after the overhead
+	MaxSealed int    // the same encrypted with an AEAD cipher
+	Unlimited bool   // the carrier grows with the secret, Eligible is its limit
+}
+
+// CapacityReport lists the capacity of a cover for every mode.
+type CapacityReport struct {
+	Format     string
+	NameLength int // secret file name length the overhead was computed for
+	ExtLength  int
+	Ratio      float64 // compressed over original size the secret is expected to have
+	Overhead   int     // bytes taken by salt block, fixed header, header and end marker at width 1
+	ECC        string  // error correction the maxima assume, empty for none
+	Modes      []CapacityMode
+}
+
+// overheadBits is the number of bits the embedding adds around a secret
+// whose file name and extension have the given lengths, with the default
+// checksum and compression when compressed is set. The salt block and fixed
+// header take one bit of as many units as they have bits.
+func overheadBits(width, nameLength, extLength int, compressed bool) int {
+	return (meta.SaltBits+meta.FixedBits)*width + streamBits(nameLength, extLength, compressed)
+}
+
+// streamBits is the part of the overhead in the bit stream: the header and
+// the end marker.
+func streamBits(nameLength, extLength int, compressed bool) int {
+	h := meta.Header{
+		Name:     strings.Repeat("x", nameLength),
+		Ext:      strings.Repeat("x", extLength),
+		Checksum: meta.ChecksumCRC32C,
+		Digest:   make([]byte, meta.ChecksumCRC32C.Size()),
+	}
+	if compressed {
+		h.Compression = meta.CompressionZstd
+	}
+	return len(meta.Pack(h))*8 + sig.Len
+}
+
+// Capacity reports how large a secret the cover can hold with every
+// algorithm, width and CRC mode, for a secret file name of nameLength bytes
+// with an extension of extLength bytes (dot included). A ratio below 1 is
+// the compressed over original size expected of the secret, the largest
+// secrets are then the ones that fit once compressed. A parity above 0
+// makes them the ones that fit Reed-Solomon coded with as many parity
+// bytes per codeword. A matrix above 0 adds the modes that can be matrix
+// embedded with that k.
+func Capacity(inputFile string, nameLength, extLength int, ratio float64, parity, matrix int) (*CapacityReport, error) {
+	if nameLength < 1 || nameLength > 255 {
+		return nil, fmt.Errorf("file name length must be between 1 and 255")
+	}
+	if extLength < 0 || extLength > nameLength {
+		return nil, fmt.Errorf("extension length must be between 0 and the file name length")
+	}
+	if ratio <= 0 || ratio > 1 {
+		return nil, fmt.Errorf("compression ratio must be above 0 and at most 1")
+	}
+	if matrix != 0 && (matrix < 2 || matrix > meta.MaxMatrix) {
+		return nil, fmt.Errorf("matrix embedding needs k between 2 and %d", meta.MaxMatrix)
+	}
+	code := rs.Layout{Parity: parity, Depth: 16}
+	if parity != 0 {
+		if err := code.Check(); err != nil {
+			return nil, err
+		}
+	}
+	compressed := ratio < 1
+	data, err := os.ReadFile(inputFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cover audio: %v", err)
+	}
+	c, format, err := cover.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &CapacityReport{Format: format.Name(), NameLength: nameLength, ExtLength: extLength, Ratio: ratio}
+	r.Overhead = (overheadBits(1, nameLength, extLength, compressed) + 7) / 8
+	if parity != 0 {
+		r.ECC = code.String()
+	}
+	add := func(m CapacityMode) {
+		// Bits the units hold, and the ones left after the salt block and
+		// fixed header
+		bits := func(units int) int {
+			if m.Matrix > 0 {
+				return max(units, 0) / (1<<uint(m.Matrix) - 1) * m.Matrix
+			}
+			return units * m.Width
+		}
+		m.Bytes = bits(m.Eligible) / 8
+		// The fixed header positions depend on the key, they may reach
+		// FixedSpan units past the salt block
+		if m.Eligible >= meta.SaltBits+meta.FixedSpan {
+			room := bits(m.Eligible - meta.SaltBits - meta.FixedBits)
+			embedded := (room - streamBits(nameLength, extLength, compressed)) / 8
+			if parity != 0 {
+				// The header, the secret and the end marker padded to a
+				// byte are coded together
+				embedded = int(code.MaxData(int64(room/8))) - (streamBits(nameLength, extLength, compressed)+7)/8
+			}
+			embedded = max(embedded, 0)
+			m.MaxSecret = int(float64(embedded) / ratio)
+			m.MaxSealed = int(float64(service.MaxPlaintext(int64(embedded))) / ratio)
+		}
+		r.Modes = append(r.Modes, m)
+	}
+
+	mc, ok := c.(*cover.MP3)
+	if !ok {
+		n := len(c.Units())
+		for width := 1; width <= 4; width++ {
+			add(CapacityMode{Algorithm: AlgorithmLSB, Width: width, Unit: "sample", Eligible: n, Total: n})
+		}
+		if matrix > 0 {
+			add(CapacityMode{Algorithm: AlgorithmLSB, Width: 1, Matrix: matrix, Unit: "sample", Eligible: n, Total: n})
+		}
+		return r, nil
+	}
+
+	f := mc.File
+	crcModes := []string{""}
+	if f.ValidateCRC().Protected > 0 {
+		crcModes = []string{CRCRecompute, CRCExclude}
+	}
+
+	for _, crc := range crcModes {
+		el := f.Eligibility()
+		if crc == CRCExclude {
+			el.ExcludeProtected()
+		}
+		for width := 1; width <= 4; width++ {
+			add(CapacityMode{Algorithm: AlgorithmLSB, CRC: crc, Width: width, Unit: "byte", Eligible: el.Usable, Total: el.Total})
+		}
+		if matrix > 0 {
+			add(CapacityMode{Algorithm: AlgorithmLSB, CRC: crc, Width: 1, Matrix: matrix, Unit: "byte", Eligible: el.Usable, Total: el.Total})
+		}
+	}
+
+	if md, err := f.DecodeMainData(); err == nil {
+		add(CapacityMode{Algorithm: AlgorithmHuffman, CRC: crcModes[0], Width: 1, Unit: "coefficient", Eligible: len(md.Parities()), Total: len(md.Coefficients())})
+		if matrix > 0 {
+			add(CapacityMode{Algorithm: AlgorithmHuffman, CRC: crcModes[0], Width: 1, Matrix: matrix, Unit: "coefficient", Eligible: len(md.Parities()), Total: len(md.Coefficients())})
+		}
+	}
+
+	bits := 0
+	for _, fr := range f.AudioFrames() {
+		bits += (len(fr.HeaderBytes) + len(fr.Data)) * 8
+	}
+	for _, crc := range crcModes {
+		c := f.Covert(crc != CRCExclude)
+		add(CapacityMode{Algorithm: AlgorithmAncillary, CRC: crc, Width: 1, Unit: "bit", Eligible: c.Len(), Total: bits})
+	}
+
+	tag := id3.New(3)
+	if len(f.ID3v2) > 0 {
+		if tag, err = id3.Parse(f.ID3v2); err != nil {
+			tag = id3.New(3)
+		}
+	}
+	// The carriers grow with the secret, up to the largest tag the
+	// synchsafe size field can describe
+	limit := func(fr *id3.Frame) int {
+		t := *tag
+		if fr != nil {
+			t.Frames = append(append([]*id3.Frame(nil), tag.Frames...), fr)
+		}
+		t.Padding = []byte{0}
+		return max(10+1<<28-1-len(id3.Serialize(&t)), 0) * 8
+	}
+	for _, algorithm := range []string{AlgorithmID3PRIV, AlgorithmID3GEOB, AlgorithmID3Padding} {
+		var fr *id3.Frame
+		switch algorithm {
+		case AlgorithmID3PRIV:
+			fr = tag.NewPRIV(id3Owner, nil)
+		case AlgorithmID3GEOB:
+			fr = tag.NewGEOB("application/octet-stream", id3Owner, nil)
+		}
+		n := limit(fr)
+		add(CapacityMode{Algorithm: algorithm, Width: 1, Unit: "bit", Eligible: n, Total: n, Unlimited: true})
+	}
+	return r, nil
+}
+
+func (m CapacityMode) String() string {
+	s := fmt.Sprintf("%-11s", m.Algorithm)
+	if m.CRC != "" {
+		s += fmt.Sprintf(" crc=%-9s", m.CRC)
+	}
+	s += fmt.Sprintf(" width=%d", m.Width)
+	if m.Matrix > 0 {
+		s += fmt.Sprintf(" matrix=%d", m.Matrix)
+	}
+	s += fmt.Sprintf("  %d of %d %ss eligible, %d bytes, max secret %d bytes (%d with AEAD)", m.Eligible, m.Total, m.Unit, m.Bytes, m.MaxSecret, m.MaxSealed)
+	if m.Algorithm == AlgorithmHuffman {
+		s += " (upper bound, re-encoding may run out of reservoir space)"
+	}
+	if m.Unlimited {
+		s += " (the ID3v2 tag grows with the secret)"
+	}
+	return s
+}
@@ -1,164 +1,816 @@
 package encoder
 
 import (
-    "crypto/sha256"
-    "encoding/binary"
+    "bufio"
+    "bytes"
+    "crypto/ecdh"
+    crand "crypto/rand"
     "fmt"
-    "math/rand"
+    "hash"
+    "io"
+    "math"
     "os"
     "path/filepath"
+    "slices"
+    "strings"
 
     "github.com/rifchzschki/Audio-Steganografi/backend/service"
+    "github.com/rifchzschki/Audio-Steganografi/backend/models/cover"
+    "github.com/rifchzschki/Audio-Steganografi/backend/models/id3"
     "github.com/rifchzschki/Audio-Steganografi/backend/models/meta"
     "github.com/rifchzschki/Audio-Steganografi/backend/models/mp3"
+    "github.com/rifchzschki/Audio-Steganografi/backend/models/pcm"
+    "github.com/rifchzschki/Audio-Steganografi/backend/utils/bitstream"
+    "github.com/rifchzschki/Audio-Steganografi/backend/utils/metrics"
     "github.com/rifchzschki/Audio-Steganografi/backend/utils/payload"
-    "github.com/rifchzschki/Audio-Steganografi/backend/utils/sig"
     "github.com/rifchzschki/Audio-Steganografi/backend/utils/psnr"
+    "github.com/rifchzschki/Audio-Steganografi/backend/utils/rs"
 )
 
-func seedFromKey(key string) int64 { 
-    h := sha256.Sum256([]byte(key))
-    return int64(binary.LittleEndian.Uint64(h[:8])) 
+// Embedding algorithms
+const (
+    AlgorithmLSB        = "lsb"         // n LSBs of samples or MP3 main data bytes
+    AlgorithmHuffman    = "huffman"     // parity of the quantized MP3 coefficients
+    AlgorithmAncillary  = "ancillary"   // MP3 bits decoders ignore, audio stays identical
+    AlgorithmID3PRIV    = "id3-priv"    // ID3v2 private frame
+    AlgorithmID3GEOB    = "id3-geob"    // ID3v2 encapsulated object frame
+    AlgorithmID3Padding = "id3-padding" // ID3v2 tag padding
+)
+
+// id3Owner is the PRIV owner and GEOB description of embedded payloads.
+const id3Owner = "https://github.com/rifchzschki/Audio-Steganografi"
+
+// In the order of the meta.Algorithm values
+var algorithms = []string{AlgorithmLSB, AlgorithmHuffman, AlgorithmAncillary, AlgorithmID3PRIV, AlgorithmID3GEOB, AlgorithmID3Padding}
+
+// Payload encryption
+const (
+    EncryptionNone             = "none"
+    EncryptionVigenere         = "vigenere" // Extended Vigenere, no integrity check
+    EncryptionAES256GCM        = service.CipherAES256GCM
+    EncryptionChaCha20Poly1305 = service.CipherChaCha20Poly1305
+)
+
+var encryptions = []string{EncryptionNone, EncryptionVigenere, EncryptionAES256GCM, EncryptionChaCha20Poly1305}
+
+// CRC handling of protected MP3 frames
+const (
+    CRCRecompute = "recompute" // recompute the CRC of frames that passed before embedding
+    CRCExclude   = "exclude"   // never modify bits covered by the CRC
+)
+
+// Result describes a finished embedding.
+type Result struct {
+    Output   string
+    Format   string            // cover format, detected from the content
+    PSNR     float64           // computed on the embedding units, see Domain
+    Domain   string            // what PSNR was computed on
+    Audio    *psnr.AudioReport // decoded MP3 samples, nil for lossless covers
+    Metrics  *metrics.Report   // quality figures on the decoded samples
+    Quality  string            // from the decoded samples when available
+    Capacity string            // units available to the chosen algorithm
+    CRC      string            // CRC check of protected MP3 frames before and after embedding
+    // Compression the secret was embedded with and its compressed size
+    // over the original, 1 when it was not compressed
+    Compression string
+    Ratio       float64
+    ECC         string // error correction the bit stream was coded with, empty for none
+    // Matrix embedding code, the bits embedded per unit changed and the
+    // PSNR gain in dB over embedding the same bits one per unit
+    Matrix     string
+    Efficiency float64
+    PSNRGain   float64
 }
 
-// EncodeFile embeds a secret file into an MP3 file using steganography
-func EncodeFile(inputMP3, secretFile, outputMP3, key string, width int, encrypt, random bool) (outputfile string, psnrVal float64,audioQuality string,err error) {
+// Options selects how a secret is embedded.
+type Options struct {
+    Key       string
+    Width     int    // LSBs per unit, 1 to 4
+    Algorithm string // AlgorithmLSB when empty
+    CRC       string // CRCRecompute when empty
+    KDF       string // key derivation function, "argon2id" when empty
+    // Encryption picks the cipher; when empty Encrypt chooses between
+    // EncryptionVigenere and EncryptionNone
+    Encryption string
+    Encrypt    bool
+    // Recipients are X25519 public keys to encrypt the payload to instead
+    // of with the key, which then only picks the positions
+    Recipients []string
+    recipients []*ecdh.PublicKey
+    // Signer signs the header and secret when set
+    Signer     *service.SigningKey
+    digest     []byte // of the secret, for the signature
+    // Checksum names the digest of the secret in the header the decoder
+    // checks, "crc32c" when empty
+    Checksum   string
+    checksum   meta.Checksum
+    sum        []byte
+    // Compression names the algorithm the secret is compressed with
+    // before encryption, "none" when empty
+    Compression string
+    compression meta.Compression
+    ratio       float64
+    // ECC is the number of Reed-Solomon parity bytes per codeword of 255
+    // the bit stream is coded with, 0 for none; Interleave the number of
+    // codewords interleaved, 16 when 0
+    ECC        int
+    Interleave int
+    // Matrix embeds Matrix bits in the lowest bits of each block of
+    // 2^Matrix-1 units, changing at most one, instead of one bit per unit;
+    // 0 for none. It needs width 1
+    Matrix     int
+    Random     bool
+    // Quality decodes cover and stego to compare the audio. That needs
+    // both in memory, so the cover is not streamed.
+    Quality bool
+}
+
+// check validates the options and fills in the defaults.
+func (o *Options) check() error {
     // Validate width parameter
-    if width != 1 && width != 2 && width != 4 && width != 3  {
-        return "",0.0,"",fmt.Errorf("width must be 1, 2, 3, or 4")
+    if o.Width != 1 && o.Width != 2 && o.Width != 4 && o.Width != 3  {
+        return fmt.Errorf("width must be 1, 2, 3, or 4")
+    }
+    if o.Algorithm == "" {
+        o.Algorithm = AlgorithmLSB
+    }
+    if !slices.Contains(algorithms, o.Algorithm) {
+        return fmt.Errorf("unknown algorithm %q (must be one of %s)", o.Algorithm, strings.Join(algorithms, ", "))
+    }
+    if o.CRC == "" {
+        o.CRC = CRCRecompute
+    }
+    if o.KDF == "" {
+        o.KDF = meta.KDFArgon2id.String()
+    }
+    if _, ok := o.kdf(); !ok {
+        return fmt.Errorf("unknown key derivation function %q (must be %s or %s)", o.KDF, meta.KDFArgon2id, meta.KDFScrypt)
+    }
+    o.recipients = nil
+    for _, s := range o.Recipients {
+        to, err := service.ParseRecipient(strings.TrimSpace(s))
+        if err != nil {
+            return err
+        }
+        o.recipients = append(o.recipients, to)
+    }
+    if len(o.recipients) > 0 && o.Encryption == "" {
+        o.Encryption = EncryptionChaCha20Poly1305
+    }
+    if o.Encryption == "" {
+        o.Encryption = EncryptionNone
+        if o.Encrypt {
+            o.Encryption = EncryptionVigenere
+        }
+    }
+    if !slices.Contains(encryptions, o.Encryption) {
+        return fmt.Errorf("unknown encryption %q (must be one of %s)", o.Encryption, strings.Join(encryptions, ", "))
+    }
+    if len(o.recipients) > 0 && o.Encryption != EncryptionAES256GCM && o.Encryption != EncryptionChaCha20Poly1305 {
+        return fmt.Errorf("encrypting to recipients needs %s or %s", EncryptionAES256GCM, EncryptionChaCha20Poly1305)
+    }
+    if o.Checksum == "" {
+        o.Checksum = meta.ChecksumCRC32C.String()
+    }
+    var err error
+    if o.checksum, err = choose("checksum", o.Checksum, meta.Checksums); err != nil {
+        return err
+    }
+    if o.Compression == "" {
+        o.Compression = meta.CompressionNone.String()
+    }
+    if o.compression, err = choose("compression", o.Compression, meta.Compressions); err != nil {
+        return err
+    }
+    if o.ECC != 0 {
+        if o.Interleave == 0 {
+            o.Interleave = 16
+        }
+        if err := o.layout().Check(); err != nil {
+            return err
+        }
+    } else if o.Interleave != 0 {
+        return fmt.Errorf("interleaving needs error correction, set the parity")
+    }
+    if o.CRC != CRCRecompute && o.CRC != CRCExclude {
+        return fmt.Errorf("unknown CRC mode %q (must be %s or %s)", o.CRC, CRCRecompute, CRCExclude)
+    }
+    // Repacking rewrites part2_3_length, which the CRC covers
+    if o.Algorithm == AlgorithmHuffman && o.CRC == CRCExclude {
+        return fmt.Errorf("the %s algorithm rewrites the side information, the CRC must be recomputed", o.Algorithm)
+    }
+    // Coefficient parities, covert bits and ID3v2 carriers hold one bit per unit
+    if o.Algorithm != AlgorithmLSB && o.Width != 1 {
+        return fmt.Errorf("the %s algorithm embeds one bit per unit, width must be 1", o.Algorithm)
+    }
+    if o.Matrix != 0 {
+        if o.Matrix < 2 || o.Matrix > meta.MaxMatrix {
+            return fmt.Errorf("matrix embedding needs k between 2 and %d", meta.MaxMatrix)
+        }
+        if o.Width != 1 {
+            return fmt.Errorf("matrix embedding changes the lowest bit only, width must be 1")
+        }
+        // Only changes to audio are worth saving
+        if o.Algorithm != AlgorithmLSB && o.Algorithm != AlgorithmHuffman {
+            return fmt.Errorf("matrix embedding needs the %s or %s algorithm", AlgorithmLSB, AlgorithmHuffman)
+        }
+    }
+    return nil
+}
+
+// choose returns the one of choices called name.
+func choose[T fmt.Stringer](what, name string, choices []T) (T, error) {
+    var names []string
+    for _, c := range choices {
+        if c.String() == name {
+            return c, nil
+        }
+        names = append(names, c.String())
+    }
+    var none T
+    return none, fmt.Errorf("unknown %s %q (must be one of %s)", what, name, strings.Join(names, ", "))
+}
+
+// header builds the metadata header of a secret of size bytes.
+func (o *Options) header(name string, size int64) meta.Header {
+    h := meta.Header{
+        Version:     meta.Version3,
+        Flags:       0,
+        NLSB:        uint8(o.Width),
+        Name:        name,
+        Size:        uint64(size),
+        Ext:         filepath.Ext(name),
+        Compression: o.compression,
     }
+    switch o.Encryption {
+    case EncryptionVigenere:
+        h.Flags |= meta.FlagEncrypted
+    case EncryptionAES256GCM:
+        h.Flags |= meta.FlagAES256GCM
+    case EncryptionChaCha20Poly1305:
+        h.Flags |= meta.FlagChaCha20Poly1305
+    }
+    if len(o.recipients) > 0 {
+        h.Flags |= meta.FlagRecipients
+    }
+    if o.Random {
+        h.Flags |= meta.FlagRandomStart
+    }
+    h.Checksum, h.Digest = o.checksum, o.sum
+    // Signed last, the signature covers every other field
+    if o.Signer != nil {
+        h.Signer = o.Signer.Public()
+        h.Signature = o.Signer.Sign(meta.Signed(h), o.digest)
+    }
+    return h
+}
+
+// kdf returns the default parameters of the chosen key derivation
+// function.
+func (o *Options) kdf() (meta.KDFParams, bool) {
+    for k, p := range meta.DefaultKDF {
+        if k.String() == o.KDF {
+            return p, true
+        }
+    }
+    return meta.KDFParams{}, false
+}
 
-    // Read cover MP3 file
-    coverBytes, err := os.ReadFile(inputMP3)
+// fileKey stretches the key with a new random salt. It returns the key and
+// the salt block that goes in front of the fixed header.
+func (o *Options) fileKey() (meta.FileKey, []byte, error) {
+    p, _ := o.kdf()
+    salt := make([]byte, meta.SaltSize)
+    if _, err := crand.Read(salt); err != nil {
+        return meta.FileKey{}, nil, fmt.Errorf("failed to generate salt: %v", err)
+    }
+    fk, err := meta.KeyV3(o.Key, p, salt)
     if err != nil {
-        return "",0.0,"",fmt.Errorf("failed to read input MP3: %v", err)
+        return meta.FileKey{}, nil, fmt.Errorf("failed to derive key: %v", err)
     }
+    return fk, meta.PackSalt(p, salt), nil
+}
 
-    // Parse MP3
-    f, err := mp3.Parse(coverBytes)
+// hashSecret computes the checksum and the digest the signature covers of
+// the size bytes of secret, both go in the header in front of it. It
+// returns where to read the secret from after that: secret itself rewound
+// when it can seek, else a copy in memory.
+func (o *Options) hashSecret(secret io.Reader, size int64) (io.Reader, error) {
+    var hashes []io.Writer
+    sum := o.checksum.New()
+    if sum != nil {
+        hashes = append(hashes, sum)
+    }
+    var d hash.Hash
+    if o.Signer != nil {
+        d = service.NewDigest()
+        hashes = append(hashes, d)
+    }
+    if len(hashes) == 0 {
+        return secret, nil
+    }
+    done := func() {
+        if sum != nil {
+            o.sum = sum.Sum(nil)
+        }
+        if d != nil {
+            o.digest = d.Sum(nil)
+        }
+    }
+    if rs, ok := secret.(io.ReadSeeker); ok {
+        start, err := rs.Seek(0, io.SeekCurrent)
+        if err == nil {
+            if _, err = io.CopyN(io.MultiWriter(hashes...), rs, size); err == nil {
+                _, err = rs.Seek(start, io.SeekStart)
+            }
+        }
+        if err != nil {
+            return nil, fmt.Errorf("failed to read secret file: %v", err)
+        }
+        done()
+        return secret, nil
+    }
+    var b bytes.Buffer
+    if _, err := io.CopyN(io.MultiWriter(append(hashes, &b)...), secret, size); err != nil {
+        return nil, fmt.Errorf("failed to read secret file: %v", err)
+    }
+    done()
+    return &b, nil
+}
+
+// compress compresses the size bytes of secret with the chosen algorithm
+// ahead of encryption. It returns what is encrypted next and its size. A
+// secret that does not shrink is embedded as it is.
+func (o *Options) compress(secret io.Reader, size int64) (io.Reader, int64, error) {
+    o.ratio = 1
+    if o.compression == meta.CompressionNone {
+        return secret, size, nil
+    }
+    b, err := io.ReadAll(io.LimitReader(secret, size))
     if err != nil {
-        return "",0.0,"",fmt.Errorf("failed to parse MP3: %v", err)
+        return nil, 0, fmt.Errorf("failed to read secret file: %v", err)
+    }
+    if int64(len(b)) != size {
+        return nil, 0, fmt.Errorf("failed to read secret file: %v", io.ErrUnexpectedEOF)
+    }
+    z, err := o.compression.Compress(b)
+    if err != nil {
+        return nil, 0, fmt.Errorf("failed to compress secret: %v", err)
+    }
+    if len(z) >= len(b) {
+        fmt.Printf("Compression: %s does not shrink the secret, embedding it as it is\n", o.compression)
+        o.compression = meta.CompressionNone
+        return bytes.NewReader(b), size, nil
+    }
+    o.ratio = float64(len(z)) / float64(len(b))
+    fmt.Printf("Compression: %s, %d -> %d bytes (ratio %.3f)\n", o.compression, len(b), len(z), o.ratio)
+    return bytes.NewReader(z), int64(len(z)), nil
+}
+
+// encrypt encrypts the size bytes read from r with the chosen cipher. It
+// returns what is embedded and its size.
+func (o *Options) encrypt(fk meta.FileKey, r io.Reader, size int64) (io.Reader, int64, error) {
+    switch o.Encryption {
+    case EncryptionVigenere:
+        return service.NewExtendedVigenereCipher(o.Key).EncryptReader(r), size, nil
+    case EncryptionAES256GCM, EncryptionChaCha20Poly1305:
+        if len(o.recipients) > 0 {
+            r, err := service.EncryptToRecipients(o.Encryption, o.recipients, r, size)
+            if err != nil {
+                return nil, 0, err
+            }
+            return r, service.RecipientsSize(len(o.recipients), size), nil
+        }
+        c, err := service.NewAEADCipher(o.Encryption, fk.PayloadKey())
+        if err != nil {
+            return nil, 0, err
+        }
+        if r, err = c.EncryptReader(r, size); err != nil {
+            return nil, 0, err
+        }
+        return r, service.SealedSize(size), nil
     }
+    return r, size, nil
+}
+
+// fixed builds the fixed header.
+func (o *Options) fixed() meta.Fixed {
+    f := meta.Fixed{
+        Version:   meta.Version3,
+        Width:     uint8(o.Width),
+        Algorithm: meta.Algorithm(slices.Index(algorithms, o.Algorithm)),
+    }
+    if o.Random {
+        f.Mode |= meta.ModeRandom
+    }
+    if o.CRC == CRCExclude {
+        f.Mode |= meta.ModeStrict
+    }
+    if o.ECC > 0 {
+        f.Parity, f.Depth = uint8(o.ECC), uint8(o.Interleave)
+    }
+    f.Matrix = uint8(o.Matrix)
+    return f
+}
 
-    // Read secret file
-    name := filepath.Base(secretFile)
-    ext := filepath.Ext(name)
-    secretBytes, err := os.ReadFile(secretFile)
+// layout returns the Reed-Solomon layout of the bit stream, no parity when
+// it is not coded.
+func (o *Options) layout() rs.Layout {
+    return rs.Layout{Parity: o.ECC, Depth: o.Interleave}
+}
+
+// ecc describes the error correction for the result.
+func (o *Options) ecc() string {
+    if o.ECC == 0 {
+        return ""
+    }
+    return o.layout().String()
+}
+
+// without removes the indices in skip, ascending, from order in place.
+func without(order, skip []int) []int {
+    n := 0
+    for i, v := range order {
+        if len(skip) > 0 && skip[0] == i {
+            skip = skip[1:]
+            continue
+        }
+        order[n] = v
+        n++
+    }
+    return order[:n]
+}
+
+// EncodeFile embeds a secret file into an MP3, WAV, FLAC or AIFF file using steganography
+func EncodeFile(inputFile, secretFile, outputFile string, opts Options) (*Result, error) {
+    in, err := os.Open(inputFile)
     if err != nil {
-        return "",0.0,"",fmt.Errorf("failed to read secret file: %v", err)
+        return nil, fmt.Errorf("failed to read cover audio: %v", err)
+    }
+    defer in.Close()
+    secret, err := os.Open(secretFile)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read secret file: %v", err)
+    }
+    defer secret.Close()
+    st, err := secret.Stat()
+    if err != nil {
+        return nil, fmt.Errorf("failed to read secret file: %v", err)
+    }
+    out, err := os.Create(outputFile)
+    if err != nil {
+        return nil, fmt.Errorf("failed to write output file: %v", err)
     }
 
-	fmt.Printf("Encoding file: %s (%s) - %d bytes\n", name, ext, len(secretBytes))
+    res, err := Encode(out, in, secret, filepath.Base(secretFile), st.Size(), opts)
+    if cerr := out.Close(); err == nil && cerr != nil {
+        err = fmt.Errorf("failed to write output file: %v", cerr)
+    }
+    if err != nil {
+        os.Remove(outputFile)
+        return nil, err
+    }
+    res.Output = outputFile
+    return res, nil
+}
 
-    // Encrypt if requested
-    if encrypt {
-        secretBytes = service.NewExtendedVigenereCipher(key).Encrypt(secretBytes)
+// Encode embeds the secret read from secret, size bytes named name, into
+// the cover read from r and writes the stego file to w. LSB embedding in
+// sequential order into a streamable cover (MP3 or WAV) runs in one pass
+// with bounded memory, unless opts.Quality asks for the decoded audio to be
+// compared or the blocks of matrix embedding may span frames; everything
+// else reads the cover and secret into memory. An io.WriteSeeker lets a
+// streamed MP3 keep its LAME tag CRCs right.
+func Encode(w io.Writer, r io.Reader, secret io.Reader, name string, size int64, opts Options) (*Result, error) {
+    if err := opts.check(); err != nil {
+        return nil, err
     }
 
-    // Create metadata header
-    h := meta.Header{
-        Version: 1,
-        Flags:   0,
-        NLSB:    uint8(width),
-        Name:    name,
-        Size:    uint64(len(secretBytes)),
-        Ext:     ext,
-    }
-    if encrypt {
-        h.Flags |= meta.FlagEncrypted
+	fmt.Printf("Encoding file: %s (%s) - %d bytes\n", name, filepath.Ext(name), size)
+
+    br := bufio.NewReaderSize(r, mp3.StreamBuffer)
+    format, err := cover.DetectStream(br)
+    if err != nil {
+        return nil, err
     }
-    if random {
-        h.Flags |= meta.FlagRandomStart
+    if secret, err = opts.hashSecret(secret, size); err != nil {
+        return nil, err
+    }
+    if secret, size, err = opts.compress(secret, size); err != nil {
+        return nil, err
     }
+    if sf, ok := format.(cover.StreamFormat); ok && opts.Algorithm == AlgorithmLSB && !opts.Random && !opts.Quality && opts.Matrix == 0 {
+        return encodeStream(w, br, sf, secret, name, size, opts)
+    }
+
+    coverBytes, err := io.ReadAll(br)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read cover audio: %v", err)
+    }
+    secretBytes, err := io.ReadAll(io.LimitReader(secret, size))
+    if err != nil {
+        return nil, fmt.Errorf("failed to read secret file: %v", err)
+    }
+    if int64(len(secretBytes)) != size {
+        return nil, fmt.Errorf("failed to read secret file: %v", io.ErrUnexpectedEOF)
+    }
+    return encode(w, coverBytes, secretBytes, name, opts)
+}
 
-    // Pack metadata
-    metaBytes := meta.Pack(h)
+// encode embeds with the whole cover in memory.
+func encode(w io.Writer, coverBytes, secretBytes []byte, name string, opts Options) (*Result, error) {
+    width, algorithm, crcMode, random := opts.Width, opts.Algorithm, opts.CRC, opts.Random
 
-    // Create bit stream with signature, width, metadata, and payload
-    S := sig.Map[width]
-    bits := make([]uint8, 0)
-    bits = append(bits, S.S...)
-    bits = append(bits, sig.WidthByte(width)...)
-    bits = append(bits, payload.ToBits(metaBytes)...)
-    bits = append(bits, payload.ToBits(secretBytes)...)
-    bits = append(bits, S.E...)
+    // Parse cover (lossless covers are embedded per sample, MP3 per frame
+    // byte); the format is picked by content
+    c, format, err := cover.Parse(coverBytes)
+    if err != nil {
+        return nil, err
+    }
+    var f *mp3.File
+    var samples, originalPCM *pcm.Buffer
+    switch c := c.(type) {
+    case *cover.MP3:
+        f = c.File
+        c.Strict = crcMode == CRCExclude
+    case *cover.PCM:
+        samples = c.Buffer
+    }
+    if algorithm != AlgorithmLSB && f == nil {
+        return nil, fmt.Errorf("the %s algorithm needs an MP3 cover, not %s", algorithm, format.Name())
+    }
+    var crcBefore *mp3.CRCReport
+    if f != nil {
+        crcBefore = f.ValidateCRC()
+    }
+
+    // Key stretched with the salt, header positions and the bit stream of
+    // metadata, payload (encrypted if requested) and end marker that fills
+    // the other units
+    fk, salt, err := opts.fileKey()
+    if err != nil {
+        return nil, err
+    }
+    skip := fk.Skip()
+    headerUnits := skip[len(skip)-1] + 1
+    secret, size, err := opts.encrypt(fk, bytes.NewReader(secretBytes), int64(len(secretBytes)))
+    if err != nil {
+        return nil, err
+    }
+    bits := newBitStream(width, opts.header(name, size), secret, opts.layout())
+    if opts.Matrix > 0 {
+        // k bits a block
+        bits.width = opts.Matrix
+    }
 
-    // Extract audio data
+    // Extract audio data. Every sample is eligible; for MP3 only the main
+    // data bytes are, the side information must stay intact. The Huffman
+    // algorithm works on the parities of the non-zero coefficients and the
+    // ancillary one on the bits decoders ignore, one bit per unit. The
+    // ID3v2 carriers hold the bit stream packed into bytes
     var audio []byte
-    for _, fr := range f.Frames {
-        audio = append(audio, fr.Data...)
+    var order []int
+    var md *mp3.MainData
+    var covert *mp3.Covert
+    var tag *id3.Tag
+    var coefficients []int
+    capacity := ""
+    if algorithm != AlgorithmLSB {
+        switch algorithm {
+        case AlgorithmHuffman:
+            md, err = f.DecodeMainData()
+            if err != nil {
+                return nil, fmt.Errorf("failed to decode MP3 main data: %v", err)
+            }
+            audio = md.Parities()
+            coefficients = md.Coefficients()
+            capacity = fmt.Sprintf("%d of %d coefficients usable", len(audio), len(coefficients))
+        case AlgorithmAncillary:
+            covert = f.Covert(crcMode == CRCRecompute)
+            audio = covert.Bits()
+            capacity = covert.String()
+        default:
+            tag = id3.New(3)
+            if len(f.ID3v2) > 0 {
+                if tag, err = id3.Parse(f.ID3v2); err != nil {
+                    return nil, fmt.Errorf("failed to parse ID3v2 tag: %v", err)
+                }
+            }
+            if algorithm == AlgorithmID3Padding {
+                // Existing padding is reused and grown when it is too small.
+                // Its first byte stays zero so readers do not take it for a frame
+                if len(tag.Padding) > 1 {
+                    audio = payload.ToBits(tag.Padding[1:])
+                }
+                capacity = fmt.Sprintf("%d bytes of ID3v2.%d padding", len(audio)/8, tag.Major)
+            } else {
+                capacity = fmt.Sprintf("ID3v2.%d frame, no size limit", tag.Major)
+            }
+            if n := max(headerUnits, len(skip)+bits.total); len(audio) < n {
+                audio = append(audio, make([]byte, (n+7)/8*8-len(audio))...)
+            }
+        }
+        fmt.Printf("Capacity: %s\n", capacity)
+        order = make([]int, len(audio))
+        for i := range audio {
+            order[i] = i
+        }
+    } else {
+        audio = c.Units()
+        if order, capacity = c.Eligible(); order == nil {
+            order = make([]int, len(audio))
+            for i := range audio {
+                order[i] = i
+            }
+        }
+        fmt.Printf("Capacity: %s\n", capacity)
     }
 
 	originalAudio := make([]byte, len(audio))
     copy(originalAudio, audio)
 
-    // Create order array
-    order := make([]int, len(audio))
-    for i := range audio {
-        order[i] = i
-    }
     if len(order) == 0 {
-        return "",0.0,"",fmt.Errorf("no audio bytes found")
+        return nil, fmt.Errorf("no audio bytes found")
     }
 
     // Check capacity
-    capBits := len(order) * width
-    if capBits < len(bits) {
-        return "",0.0,"",fmt.Errorf("capacity too small: need %d bits, have %d", len(bits), capBits)
+    if len(order) < headerUnits {
+        return nil, fmt.Errorf("capacity too small: the header needs %d units, have %d; %s", headerUnits, len(order), capacity)
+    }
+    capBits := (len(order) - len(skip)) * width
+    if opts.Matrix > 0 {
+        capBits = (len(order) - len(skip)) / (1<<uint(opts.Matrix) - 1) * opts.Matrix
     }
+    if capBits < bits.total {
+        return nil, fmt.Errorf("capacity too small: need %d bits, have %d; %s", bits.total, capBits, capacity)
+    }
+
+    // Place the salt block and fixed header, one bit per unit
+    head := bitstream.NewBitReader(append(salt, fk.Seal(opts.fixed())...), len(skip))
+    headerChanges := 0
+    for _, p := range skip {
+        b, _ := head.ReadBits(1)
+        if audio[order[p]]&1 != byte(b) {
+            headerChanges++
+        }
+        audio[order[p]] = audio[order[p]]&^1 | byte(b)
+    }
+    order = without(order, skip)
 
     // Randomize order if requested
     if random {
-        rsrc := rand.New(rand.NewSource(seedFromKey(key)))
-        for i := len(order) - 1; i > 0; i-- {
-            j := rsrc.Intn(i + 1)
-            order[i], order[j] = order[j], order[i]
-        }
+        fk.Shuffle(order)
     }
 
     // Embed bits into audio
+    var matrix *matrixStats
     mask := byte((1 << uint(width)) - 1)
-    bi := 0
-    need := (len(bits) + width - 1) / width
-
-    for t := 0; t < need; t++ {
-        pos := order[t]
-        var pv byte = 0
-        for i := 0; i < width && bi < len(bits); i++ {
-            pv = (pv << 1) | bits[bi]
-            bi++
+    if opts.Matrix > 0 {
+        if matrix, err = embedMatrix(audio, originalAudio, order, bits, opts.Matrix); err != nil {
+            return nil, err
         }
-        if bi%width != 0 {
-            pv <<= uint(width - (bi % width))
+    }
+    for t := 0; !bits.done(); t++ {
+        pos := order[t]
+        pv, err := bits.next()
+        if err != nil {
+            return nil, err
         }
         audio[pos] = (audio[pos] &^ mask) | (pv & mask)
     }
 
-    // Update frames with modified audio data
-    idx := 0
-    for _, fr := range f.Frames {
-        for k := range fr.Data {
-            if idx < len(audio) {
-                fr.Data[k] = audio[idx]
-                idx++
+    // Write modified audio data back and serialize
+    if md != nil {
+        changed := md.SetParities(audio)
+        if err := f.Repack(md); err != nil {
+            return nil, fmt.Errorf("failed to re-encode MP3: %v (try a smaller secret)", err)
+        }
+        fmt.Printf("Changed %d coefficients (reservoir repacked: %v)\n", changed, md.Repacked)
+    } else if covert != nil {
+        fmt.Printf("Changed %d covert bits\n", covert.SetBits(audio))
+    } else if tag != nil {
+        data := payload.BitsToBytes(audio)
+        if algorithm == AlgorithmID3Padding {
+            tag.Padding = append([]byte{0}, data...)
+        } else {
+            // Replace the payload of an earlier run
+            tag.Remove(func(fr *id3.Frame) bool {
+                owner, _, ok := tag.Object(fr)
+                return ok && owner == id3Owner
+            })
+            if algorithm == AlgorithmID3PRIV {
+                tag.Frames = append(tag.Frames, tag.NewPRIV(id3Owner, data))
+            } else {
+                tag.Frames = append(tag.Frames, tag.NewGEOB("application/octet-stream", id3Owner, data))
             }
         }
+        f.ID3v2 = id3.Serialize(tag)
+        fmt.Printf("Stored %d bytes in the ID3v2.%d tag\n", len(data), tag.Major)
+    } else {
+        c.SetUnits(audio)
+    }
+    crc := ""
+    if f != nil {
+        if crcMode == CRCRecompute {
+            f.UpdateCRC(crcBefore.Failed)
+        }
+        crc = fmt.Sprintf("before embedding %s, after %s", crcBefore, f.ValidateCRC())
+        fmt.Printf("CRC: %s\n", crc)
     }
-
-    // Serialize and write output
-    outBytes := mp3.Serialize(f)
-    if err := os.WriteFile(outputMP3, outBytes, 0644); err != nil {
-        return "",0.0,"",fmt.Errorf("failed to write output file: %v", err)
+    outBytes := c.Serialize()
+    if _, err := w.Write(outBytes); err != nil {
+        return nil, fmt.Errorf("failed to write output file: %v", err)
     }
 
-    fmt.Printf("Successfully encoded: bits=%d width=%d file=%s\n", len(bits), width, outputMP3)
-	psnrValue, _, err := psnr.DetectAudioFormat(originalAudio, audio)
+    fmt.Printf("Successfully encoded: bits=%d width=%d format=%s\n", bits.total, width, format.Name())
+    var psnrValue float64
+    domain := ""
+    if samples != nil {
+        // Only the low bytes of the samples changed, the original samples
+        // are rebuilt when float samples or the quality metrics need them
+        if samples.Float || opts.Quality {
+            originalPCM = samples.Clone()
+            originalPCM.SetLowBytes(originalAudio)
+            psnrValue, err = psnr.CalculatePSNRPCM(originalPCM, samples)
+        } else {
+            psnrValue, err = psnr.CalculatePSNRLowBytes(originalAudio, audio, samples.BitDepth)
+        }
+        domain = "samples"
+    } else if md != nil {
+        psnrValue, err = coefficientPSNR(coefficients, md.Coefficients())
+        domain = "quantized MP3 coefficients"
+    } else if covert != nil || tag != nil {
+        // No granule data changed, the decoded audio is identical
+        psnrValue = math.Inf(1)
+        domain = "decoded audio, unchanged by construction"
+    } else {
+        psnrValue, _, err = psnr.DetectAudioFormat(originalAudio, audio)
+        domain = "MP3 frame bytes"
+    }
+    res := &Result{Format: format.Name(), Domain: domain, Capacity: capacity, CRC: crc, Compression: opts.compression.String(), Ratio: opts.ratio, ECC: opts.ecc()}
+    if matrix != nil {
+        matrix.report(res, headerChanges)
+    }
     if err != nil {
         fmt.Printf("Warning: Failed to calculate PSNR: %v\n", err)
-        return outputMP3, 0.0, "Unknown", nil
+        res.Quality = "Unknown"
     } else {
-        qualityStatus := psnr.GetQualityStatus(psnrValue)
-        fmt.Printf("\nQuality Status: %s", qualityStatus)
-        return outputMP3, psnrValue, qualityStatus, nil
+        res.PSNR = psnrValue
+        res.Quality = psnr.GetQualityStatus(psnrValue)
+        fmt.Printf("PSNR (%s): %s\n", domain, psnr.FormatPSNR(psnrValue))
+    }
+
+    // What listeners hear: both files decoded to PCM
+    if f != nil && opts.Quality {
+        original, err := mp3.Parse(coverBytes)
+        if err == nil {
+            f, err = mp3.Parse(outBytes)
+        }
+        if err == nil {
+            res.Audio, err = psnr.CompareMP3(original, f)
+        }
+        if err != nil {
+            fmt.Printf("Warning: Failed to calculate audio PSNR: %v\n", err)
+        } else {
+            res.Quality = psnr.GetQualityStatus(res.Audio.PSNR)
+            fmt.Printf("PSNR (decoded audio): %s\n", res.Audio)
+        }
+    }
+
+    // Quality metrics on the decoded samples
+    original, stego := originalPCM, samples
+    if res.Audio != nil {
+        original, stego = res.Audio.Cover, res.Audio.Stego
+    }
+    if original != nil && opts.Quality {
+        if res.Metrics, err = metrics.Compare(original, stego); err != nil {
+            fmt.Printf("Warning: Failed to calculate quality metrics: %v\n", err)
+        } else {
+            fmt.Printf("%s\n", res.Metrics)
+        }
+    }
+    fmt.Printf("\nQuality Status: %s\n", res.Quality)
+    return res, nil
+}
+
+// coefficientPSNR compares quantized MP3 coefficients, scaled by the
+// largest original magnitude.
+func coefficientPSNR(original, stego []int) (float64, error) {
+    peak := 1
+    for _, v := range original {
+        if v > peak {
+            peak = v
+        } else if -v > peak {
+            peak = -v
+        }
+    }
+    a := make([]float64, len(original))
+    b := make([]float64, len(stego))
+    for i, v := range original {
+        a[i] = float64(v) / float64(peak)
+    }
+    for i, v := range stego {
+        b[i] = float64(v) / float64(peak)
     }
+    return psnr.CalculatePSNRFloat(a, b)
 }
@@ -0,0 +1,92 @@
+package encoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sync"
+	"testing"
+)
+
+// benchCases are the covers and secrets the benchmarks embed. Random
+// positions take the in-memory path, sequential ones are streamed.
+var benchCases = []struct {
+	name   string
+	width  int
+	secret int
+	random bool
+}{
+	{"random/width=1", 1, 1 << 20, true},
+	{"random/width=4", 4, 4 << 20, true},
+	{"sequential/width=1", 1, 1 << 20, false},
+}
+
+// benchCover is a 10 minute 44.1 kHz stereo 16-bit WAV file of a tone with
+// a little noise.
+var benchCover = sync.OnceValue(func() []byte {
+	const rate, channels, frames = 44100, 2, 10 * 60 * 44100
+	size := frames * channels * 2
+	out := make([]byte, 44, 44+size)
+	copy(out[0:], "RIFF")
+	binary.LittleEndian.PutUint32(out[4:], uint32(36+size))
+	copy(out[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(out[16:], 16)
+	binary.LittleEndian.PutUint16(out[20:], 1)
+	binary.LittleEndian.PutUint16(out[22:], channels)
+	binary.LittleEndian.PutUint32(out[24:], rate)
+	binary.LittleEndian.PutUint32(out[28:], rate*channels*2)
+	binary.LittleEndian.PutUint16(out[32:], channels*2)
+	binary.LittleEndian.PutUint16(out[34:], 16)
+	copy(out[36:], "data")
+	binary.LittleEndian.PutUint32(out[40:], uint32(size))
+
+	rsrc := rand.New(rand.NewSource(1))
+	var tmp [2]byte
+	for i := 0; i < frames; i++ {
+		v := 8000*math.Sin(2*math.Pi*440*float64(i)/rate) + rsrc.NormFloat64()*200
+		binary.LittleEndian.PutUint16(tmp[:], uint16(int16(v)))
+		for c := 0; c < channels; c++ {
+			out = append(out, tmp[:]...)
+		}
+	}
+	return out
+})
+
+// quiet runs fn with the reports printed on stdout discarded.
+func quiet(b *testing.B, fn func() error) {
+	b.Helper()
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer devNull.Close()
+	stdout := os.Stdout
+	os.Stdout = devNull
+	defer func() { os.Stdout = stdout }()
+	if err := fn(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	cover := benchCover()
+	for _, bc := range benchCases {
+		b.Run(bc.name, func(b *testing.B) {
+			secret := make([]byte, bc.secret)
+			rand.New(rand.NewSource(1)).Read(secret)
+			opts := Options{Key: "STEGANO", Width: bc.width, Algorithm: AlgorithmLSB, CRC: CRCRecompute, Random: bc.random}
+			b.SetBytes(int64(len(cover)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				quiet(b, func() error {
+					_, err := Encode(io.Discard, bytes.NewReader(cover), bytes.NewReader(secret), "secret.bin", int64(len(secret)), opts)
+					return err
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package encoder
+
+import (
+	"fmt"
+	"math"
+)
+
+// matrixStats counts what matrix embedding changed against embedding the
+// same bits one per unit.
+type matrixStats struct {
+	k       int
+	bits    int // bits embedded
+	changed int // units changed
+	plain   int // units embedding one bit per unit would have changed
+}
+
+// embedMatrix embeds the bits k at a time into the lowest bits of blocks
+// of 2^k-1 units at order with the (1, 2^k-1, k) Hamming code: the bits of
+// a block are the XOR of the one-based indices of its units with the
+// lowest bit set, so flipping the unit at the XOR of that and the bits to
+// embed, none when they match, embeds them. original holds the units
+// before embedding.
+func embedMatrix(audio, original []byte, order []int, bits *bitStream, k int) (*matrixStats, error) {
+	n := 1<<uint(k) - 1
+	st := &matrixStats{k: k, bits: bits.total}
+	for t := 0; !bits.done(); t++ {
+		v, err := bits.next()
+		if err != nil {
+			return nil, err
+		}
+		block := order[t*n : (t+1)*n]
+		s := int(v)
+		for i, pos := range block {
+			if audio[pos]&1 != 0 {
+				s ^= i + 1
+			}
+		}
+		if s != 0 {
+			audio[block[s-1]] ^= 1
+			st.changed++
+		}
+		for j := range k {
+			if i := t*k + j; i < bits.total && original[order[i]]&1 != v>>uint(k-1-j)&1 {
+				st.plain++
+			}
+		}
+	}
+	return st, nil
+}
+
+func (st *matrixStats) String() string {
+	return fmt.Sprintf("Hamming (1, %d, %d)", 1<<uint(st.k)-1, st.k)
+}
+
+// report fills in the matrix embedding figures of res. Both ways of
+// embedding also change headerChanges units for the salt block and fixed
+// header. Every change is one step of a unit, so the PSNR gain is the ratio
+// of the changes.
+func (st *matrixStats) report(res *Result, headerChanges int) {
+	res.Matrix = st.String()
+	res.Efficiency = math.Inf(1)
+	if st.changed > 0 {
+		res.Efficiency = float64(st.bits) / float64(st.changed)
+	}
+	res.PSNRGain = 10 * math.Log10(float64(headerChanges+st.plain)/float64(headerChanges+st.changed))
+	if math.IsNaN(res.PSNRGain) {
+		res.PSNRGain = 0 // nothing changed either way
+	}
+	fmt.Printf("Matrix embedding: %s, %d units changed for %d bits (%.2f bits per change), PSNR %+.2f dB over one bit per unit\n",
+		res.Matrix, st.changed, st.bits, res.Efficiency, res.PSNRGain)
+}
@@ -0,0 +1,119 @@
+package encoder
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/meta"
+	"github.com/rifchzschki/Audio-Steganografi/backend/utils/bitstream"
+)
+
+// rawBits returns a bit stream of the first n bits of msg, k at a time.
+func rawBits(msg []byte, n, k int) *bitStream {
+	return &bitStream{width: k, parts: []*bitstream.BitReader{bitstream.NewBitReader(msg, n)}, total: n}
+}
+
+func TestEmbedMatrix(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for k := 2; k <= meta.MaxMatrix; k++ {
+		n := 1<<uint(k) - 1
+		// The last block only partly filled
+		bits := 20*k + 3
+		blocks := (bits + k - 1) / k
+		msg := make([]byte, (bits+7)/8)
+		rng.Read(msg)
+		original := make([]byte, blocks*n+50)
+		rng.Read(original)
+		order := rng.Perm(len(original))
+
+		audio := bytes.Clone(original)
+		st, err := embedMatrix(audio, original, order, rawBits(msg, bits, k), k)
+		if err != nil {
+			t.Fatalf("k=%d: %v", k, err)
+		}
+
+		// At most one unit of a block changed, in its lowest bit only
+		changed := 0
+		for b := 0; b < len(order)/n; b++ {
+			inBlock := 0
+			for _, pos := range order[b*n : (b+1)*n] {
+				switch audio[pos] ^ original[pos] {
+				case 0:
+				case 1:
+					inBlock++
+				default:
+					t.Fatalf("k=%d: unit %d changed from %#x to %#x", k, pos, original[pos], audio[pos])
+				}
+			}
+			if inBlock > 1 || inBlock > 0 && b >= blocks {
+				t.Fatalf("k=%d: %d units of block %d changed", k, inBlock, b)
+			}
+			changed += inBlock
+		}
+		for _, pos := range order[len(order)/n*n:] {
+			if audio[pos] != original[pos] {
+				t.Fatalf("k=%d: unit %d outside the blocks changed", k, pos)
+			}
+		}
+
+		// The syndromes read back the message
+		r := bitstream.NewMatrixReader(audio, order, nil, k)
+		want := bitstream.NewBitReader(msg, bits)
+		for i := 0; i < bits; i++ {
+			got, err := r.ReadBits(1)
+			if err != nil {
+				t.Fatalf("k=%d: bit %d: %v", k, i, err)
+			}
+			if w, _ := want.ReadBits(1); got != w {
+				t.Fatalf("k=%d: bit %d is %d, want %d", k, i, got, w)
+			}
+		}
+
+		// One bit per unit would have changed the units along order
+		// whose lowest bit differs from the message
+		plain := 0
+		want = bitstream.NewBitReader(msg, bits)
+		for i := 0; i < bits; i++ {
+			if w, _ := want.ReadBits(1); uint64(original[order[i]]&1) != w {
+				plain++
+			}
+		}
+		if st.bits != bits || st.changed != changed || st.plain != plain {
+			t.Fatalf("k=%d: stats %d bits, %d changed, %d plain; want %d, %d, %d", k, st.bits, st.changed, st.plain, bits, changed, plain)
+		}
+	}
+}
+
+func TestMatrixReport(t *testing.T) {
+	// Three blocks of three units, with the lowest bits
+	//   0 0 0 | 1 0 0 | 1 1 0
+	// and syndromes 0, 1 and 3. Embedding 11 01 10 flips the third unit
+	// of the first block and the first of the last, none of the second.
+	// One bit per unit would have flipped units 0, 1 and 4.
+	original := []byte{0x10, 0x20, 0x30, 0x41, 0x50, 0x60, 0x71, 0x81, 0x90}
+	order := []int{0, 1, 2, 3, 4, 5, 6, 7, 8}
+	audio := bytes.Clone(original)
+	st, err := embedMatrix(audio, original, order, rawBits([]byte{0b11011000}, 6, 2), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x10, 0x20, 0x31, 0x41, 0x50, 0x60, 0x70, 0x81, 0x90}
+	if !bytes.Equal(audio, want) {
+		t.Fatalf("embedded % x, want % x", audio, want)
+	}
+
+	var res Result
+	st.report(&res, 5)
+	if res.Matrix != "Hamming (1, 3, 2)" {
+		t.Errorf("code %q", res.Matrix)
+	}
+	if res.Efficiency != 3 {
+		t.Errorf("efficiency %v, want 3 bits per change", res.Efficiency)
+	}
+	// 5 header changes either way, 3 against 2
+	if gain := 10 * math.Log10(8.0/7.0); math.Abs(res.PSNRGain-gain) > 1e-9 {
+		t.Errorf("PSNR gain %v dB, want %v", res.PSNRGain, gain)
+	}
+}
@@ -0,0 +1,176 @@
+package encoder
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/cover"
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/meta"
+	"github.com/rifchzschki/Audio-Steganografi/backend/utils/bitstream"
+	"github.com/rifchzschki/Audio-Steganografi/backend/utils/psnr"
+	"github.com/rifchzschki/Audio-Steganografi/backend/utils/rs"
+	"github.com/rifchzschki/Audio-Steganografi/backend/utils/sig"
+)
+
+// bitStream yields the embedded bits width at a time without building
+// them all: the header, behind a signature and width byte before version
+// 2, the payload as it is read, then the end marker. With parity in code
+// the three are Reed-Solomon coded as one byte stream, the end marker
+// padded to a byte.
+type bitStream struct {
+	width int
+	parts []*bitstream.BitReader
+	total int // bits in the whole stream
+	sent  int
+}
+
+func newBitStream(width int, h meta.Header, r io.Reader, code rs.Layout) *bitStream {
+	S := sig.Map[width]
+	head := bitstream.NewBitWriter(sig.Len + 8 + 8*(8+len(h.Name)+len(h.Ext)+8))
+	if h.Version < meta.Version2 {
+		head.WriteBits(S.S, sig.Len)
+		head.WriteByte(sig.WidthByte(width))
+	}
+	head.Write(meta.Pack(h))
+	end := bitstream.NewBitWriter(sig.Len)
+	end.WriteBits(S.E, sig.Len)
+	if code.Parity > 0 {
+		n := int64(len(head.Bytes())) + int64(h.Size) + int64(len(end.Bytes()))
+		data := io.MultiReader(bytes.NewReader(head.Bytes()), io.LimitReader(r, int64(h.Size)), bytes.NewReader(end.Bytes()))
+		coded := code.EncodedSize(n) * 8
+		return &bitStream{
+			width: width,
+			parts: []*bitstream.BitReader{bitstream.NewBitStreamReader(code.NewEncoder(data, n), coded)},
+			total: int(coded),
+		}
+	}
+	return &bitStream{
+		width: width,
+		parts: []*bitstream.BitReader{
+			bitstream.NewBitReader(head.Bytes(), head.Len()),
+			bitstream.NewBitStreamReader(r, int64(h.Size)*8),
+			bitstream.NewBitReader(end.Bytes(), end.Len()),
+		},
+		total: head.Len() + int(h.Size)*8 + end.Len(),
+	}
+}
+
+func (s *bitStream) done() bool {
+	return s.sent >= s.total
+}
+
+// next returns the next width bits, the last group padded with zeros.
+func (s *bitStream) next() (byte, error) {
+	var pv uint64
+	n := 0
+	for n < s.width && len(s.parts) > 0 {
+		k := int(min(int64(s.width-n), s.parts[0].Remaining()))
+		if k == 0 {
+			s.parts = s.parts[1:]
+			continue
+		}
+		v, err := s.parts[0].ReadBits(k)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read secret file: %v", err)
+		}
+		pv = pv<<uint(k) | v
+		n += k
+	}
+	pv <<= uint(s.width - n)
+	s.sent += n
+	return byte(pv), nil
+}
+
+// encodeStream embeds in sequential order while the cover is copied from r
+// to w, one frame or block of samples at a time.
+func encodeStream(w io.Writer, r io.Reader, format cover.StreamFormat, secret io.Reader, name string, size int64, opts Options) (*Result, error) {
+	fk, salt, err := opts.fileKey()
+	if err != nil {
+		return nil, err
+	}
+	secret, size, err = opts.encrypt(fk, secret, size)
+	if err != nil {
+		return nil, err
+	}
+	bits := newBitStream(opts.Width, opts.header(name, size), secret, opts.layout())
+	skip := fk.Skip()
+	headerUnits, headerBits := skip[len(skip)-1]+1, len(skip)
+	head := bitstream.NewBitReader(append(salt, fk.Seal(opts.fixed())...), headerBits)
+
+	mask := byte((1 << uint(opts.Width)) - 1)
+	var diff psnr.Running
+	var original []byte
+	units := 0
+	info, err := format.Stream(w, r, opts.CRC == CRCExclude, func(u []byte, eligible []bool) error {
+		original = append(original[:0], u...)
+		for k := range u {
+			if eligible != nil && !eligible[k] {
+				continue
+			}
+			units++
+			if len(skip) > 0 && skip[0] == units-1 {
+				b, _ := head.ReadBits(1)
+				u[k] = u[k]&^1 | byte(b)
+				skip = skip[1:]
+				continue
+			}
+			if bits.done() {
+				continue
+			}
+			pv, err := bits.next()
+			if err != nil {
+				return err
+			}
+			u[k] = (u[k] &^ mask) | (pv & mask)
+		}
+		diff.Add(original, u)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Capacity: %s\n", info.Capacity)
+	if units == 0 {
+		return nil, fmt.Errorf("no audio bytes found")
+	}
+	if len(skip) > 0 {
+		return nil, fmt.Errorf("capacity too small: the header needs %d units, have %d; %s", headerUnits, units, info.Capacity)
+	}
+	if !bits.done() {
+		return nil, fmt.Errorf("capacity too small: need %d bits, have %d; %s", bits.total, (units-headerBits)*opts.Width, info.Capacity)
+	}
+
+	res := &Result{Format: format.Name(), Capacity: info.Capacity, Compression: opts.compression.String(), Ratio: opts.ratio, ECC: opts.ecc()}
+	if info.CRCBefore != nil {
+		res.CRC = fmt.Sprintf("before embedding %s, after %s", info.CRCBefore, info.CRCAfter)
+		fmt.Printf("CRC: %s\n", res.CRC)
+	}
+	if info.Warning != "" {
+		fmt.Printf("Warning: %s\n", info.Warning)
+	}
+	fmt.Printf("Successfully encoded: bits=%d width=%d format=%s\n", bits.total, opts.Width, format.Name())
+
+	var psnrValue float64
+	switch {
+	case info.Layout != nil && !info.Layout.Float:
+		psnrValue, err = diff.PCM(info.Layout.BitDepth)
+		res.Domain = "samples"
+	case info.Layout != nil:
+		psnrValue, _, err = diff.Result()
+		res.Domain = "sample low bytes"
+	default:
+		psnrValue, _, err = diff.Result()
+		res.Domain = "MP3 frame bytes"
+	}
+	if err != nil {
+		fmt.Printf("Warning: Failed to calculate PSNR: %v\n", err)
+		res.Quality = "Unknown"
+	} else {
+		res.PSNR = psnrValue
+		res.Quality = psnr.GetQualityStatus(psnrValue)
+		fmt.Printf("PSNR (%s): %s\n", res.Domain, psnr.FormatPSNR(psnrValue))
+	}
+	fmt.Printf("\nQuality Status: %s\n", res.Quality)
+	return res, nil
+}
@@ -1,5 +1,7 @@
 package service
 
+import "io"
+
 type ExtendedVigenereCipher struct {
 	Key []byte
 }
@@ -25,4 +27,46 @@ func (e *ExtendedVigenereCipher) Decrypt(ciphertext []byte) []byte {
 		plaintext[i] = byte((int(ct) - int(k) + 256) % 256)
 	}
 	return plaintext
-}
\ No newline at end of file
+}
+
+// EncryptReader encrypts what is read from r, for payloads too large to
+// hold in memory.
+func (e *ExtendedVigenereCipher) EncryptReader(r io.Reader) io.Reader {
+	return &vigenereStream{key: e.Key, r: r, sign: 1}
+}
+
+// DecryptWriter decrypts what is written to it into w.
+func (e *ExtendedVigenereCipher) DecryptWriter(w io.Writer) io.Writer {
+	return &vigenereStream{key: e.Key, w: w, sign: -1}
+}
+
+type vigenereStream struct {
+	key  []byte
+	r    io.Reader
+	w    io.Writer
+	sign int
+	n    int // bytes processed, the key position
+	buf  []byte
+}
+
+func (s *vigenereStream) apply(dst, src []byte) {
+	for i, b := range src {
+		dst[i] = byte(int(b) + s.sign*int(s.key[s.n%len(s.key)]))
+		s.n++
+	}
+}
+
+func (s *vigenereStream) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.apply(p[:n], p[:n])
+	return n, err
+}
+
+func (s *vigenereStream) Write(p []byte) (int, error) {
+	if cap(s.buf) < len(p) {
+		s.buf = make([]byte, len(p))
+	}
+	b := s.buf[:len(p)]
+	s.apply(b, p)
+	return s.w.Write(b)
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Key files hold one key per line, with comments in lines starting with #.
+
+// writeKeyFile writes the secret key to a new key file at path, readable
+// by its owner only, with the public key in a comment.
+func writeKeyFile(path, public, secret string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write key file: %v", err)
+	}
+	_, err = fmt.Fprintf(f, "# created: %s\n# public key: %s\n%s\n", time.Now().Format(time.RFC3339), public, secret)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write key file: %v", err)
+	}
+	return nil
+}
+
+// keyLines calls parse with every line of text that is not blank or a
+// comment, trimmed, and its line number.
+func keyLines(text string, parse func(line string, n int) error) error {
+	sc := bufio.NewScanner(strings.NewReader(text))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := parse(line, n); err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+	}
+	return sc.Err()
+}
+
+// readKeyFile calls parse with the key lines of the file at path.
+func readKeyFile(path string, parse func(line string, n int) error) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read key file: %v", err)
+	}
+	if err := keyLines(string(b), parse); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
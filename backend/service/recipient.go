@@ -0,0 +1,272 @@
+package service
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Payloads can be encrypted to X25519 recipients instead of with the
+// stego key. A random payload key seals the payload as AEADCipher does and
+// is wrapped once per recipient, in the manner of age: a stanza holds an
+// ephemeral X25519 share and the payload key sealed with ChaCha20-Poly1305
+// under a key agreed between the ephemeral and the recipient key. The
+// payload is a count byte, the stanzas, then the sealed payload.
+const (
+	stanzaSize    = 32 + 32 + chacha20poly1305.Overhead
+	maxRecipients = 255
+	wrapLabel     = "Audio-Steganografi/v3/X25519"
+)
+
+// Keys as text
+const (
+	RecipientPrefix = "stegopub-"
+	IdentityPrefix  = "STEGO-SECRET-KEY-"
+)
+
+var keyEncoding = base64.RawURLEncoding
+
+// ErrNotRecipient is returned when no identity opens a stanza.
+var ErrNotRecipient = errors.New("failed to decrypt the payload: none of the identities is a recipient")
+
+// Identity is an X25519 key pair a payload can be encrypted to.
+type Identity struct {
+	key *ecdh.PrivateKey
+}
+
+// GenerateIdentity creates a new key pair.
+func GenerateIdentity() (*Identity, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+	return &Identity{key}, nil
+}
+
+// ParseIdentity parses a secret key written by Identity.String.
+func ParseIdentity(s string) (*Identity, error) {
+	b, err := keyEncoding.DecodeString(strings.TrimPrefix(s, IdentityPrefix))
+	if err != nil || !strings.HasPrefix(s, IdentityPrefix) {
+		return nil, fmt.Errorf("malformed secret key")
+	}
+	key, err := ecdh.X25519().NewPrivateKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("malformed secret key: %v", err)
+	}
+	return &Identity{key}, nil
+}
+
+// String returns the secret key as text.
+func (id *Identity) String() string {
+	return IdentityPrefix + keyEncoding.EncodeToString(id.key.Bytes())
+}
+
+// Recipient returns the public key as text.
+func (id *Identity) Recipient() string {
+	return RecipientPrefix + keyEncoding.EncodeToString(id.key.PublicKey().Bytes())
+}
+
+// ParseRecipient parses a public key written by Identity.Recipient.
+func ParseRecipient(s string) (*ecdh.PublicKey, error) {
+	b, err := keyEncoding.DecodeString(strings.TrimPrefix(s, RecipientPrefix))
+	if err != nil || !strings.HasPrefix(s, RecipientPrefix) {
+		return nil, fmt.Errorf("malformed public key %q", s)
+	}
+	key, err := ecdh.X25519().NewPublicKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("malformed public key %q: %v", s, err)
+	}
+	return key, nil
+}
+
+// WriteIdentity writes id to a new key file at path.
+func WriteIdentity(path string, id *Identity) error {
+	return writeKeyFile(path, id.Recipient(), id.String())
+}
+
+// ParseIdentities parses the secret keys in text, one per line.
+func ParseIdentities(text string) ([]*Identity, error) {
+	var ids []*Identity
+	err := keyLines(text, func(line string, n int) error {
+		id, err := ParseIdentity(line)
+		ids = append(ids, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// LoadIdentities reads the secret keys of a key file.
+func LoadIdentities(path string) ([]*Identity, error) {
+	var ids []*Identity
+	err := readKeyFile(path, func(line string, n int) error {
+		id, err := ParseIdentity(line)
+		ids = append(ids, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no secret key in %s", path)
+	}
+	return ids, nil
+}
+
+// wrapKey is the key agreed between share and recipient.
+func wrapKey(secret, share, recipient []byte) ([]byte, error) {
+	salt := append(append([]byte{}, share...), recipient...)
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(wrapLabel)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// RecipientsSize returns the size of a payload of n bytes encrypted to
+// count recipients.
+func RecipientsSize(count int, n int64) int64 {
+	return 1 + int64(count)*stanzaSize + SealedSize(n)
+}
+
+// EncryptToRecipients encrypts the size bytes read from r to recipients
+// with the named cipher, RecipientsSize(len(recipients), size) bytes in
+// all.
+func EncryptToRecipients(name string, recipients []*ecdh.PublicKey, r io.Reader, size int64) (io.Reader, error) {
+	if len(recipients) == 0 || len(recipients) > maxRecipients {
+		return nil, fmt.Errorf("between 1 and %d recipients are needed", maxRecipients)
+	}
+	fileKey := make([]byte, 32)
+	if _, err := rand.Read(fileKey); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+	c, err := NewAEADCipher(name, fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	stanzas := []byte{byte(len(recipients))}
+	for _, to := range recipients {
+		eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate key: %v", err)
+		}
+		secret, err := eph.ECDH(to)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt to recipient: %v", err)
+		}
+		share := eph.PublicKey().Bytes()
+		key, err := wrapKey(secret, share, to.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		aead, err := chacha20poly1305.New(key)
+		if err != nil {
+			return nil, err
+		}
+		stanzas = append(stanzas, share...)
+		stanzas = aead.Seal(stanzas, make([]byte, aead.NonceSize()), fileKey, nil)
+	}
+
+	sealed, err := c.EncryptReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	return io.MultiReader(bytes.NewReader(stanzas), sealed), nil
+}
+
+// unwrap returns the payload key of the stanza if id is its recipient.
+func (id *Identity) unwrap(stanza []byte) ([]byte, bool) {
+	share, body := stanza[:32], stanza[32:]
+	eph, err := ecdh.X25519().NewPublicKey(share)
+	if err != nil {
+		return nil, false
+	}
+	secret, err := id.key.ECDH(eph)
+	if err != nil {
+		return nil, false
+	}
+	key, err := wrapKey(secret, share, id.key.PublicKey().Bytes())
+	if err != nil {
+		return nil, false
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, false
+	}
+	fileKey, err := aead.Open(nil, make([]byte, aead.NonceSize()), body, nil)
+	return fileKey, err == nil
+}
+
+// DecryptForWriter decrypts the size bytes of a payload encrypted to
+// recipients into w with the first of ids that is one. Writes fail with
+// ErrNotRecipient when none is, and as AEADCipher.DecryptWriter ones
+// otherwise.
+func DecryptForWriter(name string, ids []*Identity, w io.Writer, size int64) io.Writer {
+	return &recipientWriter{name: name, ids: ids, w: w, left: size}
+}
+
+type recipientWriter struct {
+	name   string
+	ids    []*Identity
+	w      io.Writer
+	left   int64 // bytes not written yet
+	prefix []byte
+	body   io.Writer
+	err    error
+}
+
+func (r *recipientWriter) Write(p []byte) (int, error) {
+	n := 0
+	for r.body == nil && r.err == nil && len(p) > 0 {
+		want := 1
+		if len(r.prefix) > 0 {
+			want += int(r.prefix[0]) * stanzaSize
+		}
+		k := min(want-len(r.prefix), len(p))
+		if int64(k) > r.left {
+			r.err = ErrWrongKey
+			break
+		}
+		r.prefix = append(r.prefix, p[:k]...)
+		p, n, r.left = p[k:], n+k, r.left-int64(k)
+		if len(r.prefix) < want || want == 1 && r.prefix[0] != 0 {
+			continue
+		}
+		if r.prefix[0] == 0 {
+			r.err = ErrWrongKey
+			break
+		}
+		r.err = ErrNotRecipient
+		for i := 1; i < len(r.prefix) && r.err != nil; i += stanzaSize {
+			for _, id := range r.ids {
+				if key, ok := id.unwrap(r.prefix[i : i+stanzaSize]); ok {
+					c, err := NewAEADCipher(r.name, key)
+					if r.err = err; err == nil {
+						r.body = c.DecryptWriter(r.w, r.left)
+					}
+					break
+				}
+			}
+		}
+	}
+	if r.err != nil {
+		return n, r.err
+	}
+	if len(p) == 0 {
+		return n, nil
+	}
+	k, err := r.body.Write(p)
+	return n + k, err
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// identities returns n new identities and their public keys.
+func identities(t *testing.T, n int) ([]*Identity, []*ecdh.PublicKey) {
+	t.Helper()
+	var ids []*Identity
+	var keys []*ecdh.PublicKey
+	for range n {
+		id, err := GenerateIdentity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Through the text forms, as the keys are handed around
+		if id, err = ParseIdentity(id.String()); err != nil {
+			t.Fatal(err)
+		}
+		key, err := ParseRecipient(id.Recipient())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids, keys = append(ids, id), append(keys, key)
+	}
+	return ids, keys
+}
+
+// encryptTo returns plain encrypted to recipients.
+func encryptTo(t *testing.T, recipients []*ecdh.PublicKey, plain []byte) []byte {
+	t.Helper()
+	r, err := EncryptToRecipients(CipherChaCha20Poly1305, recipients, bytes.NewReader(plain), int64(len(plain)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(sealed)) != RecipientsSize(len(recipients), int64(len(plain))) {
+		t.Fatalf("%d bytes encrypted to %d recipients, RecipientsSize says %d", len(sealed), len(recipients), RecipientsSize(len(recipients), int64(len(plain))))
+	}
+	return sealed
+}
+
+// decryptFor writes sealed in pieces of at most piece bytes to a
+// DecryptForWriter with ids and returns what came out.
+func decryptFor(ids []*Identity, sealed []byte, piece int) ([]byte, error) {
+	var out bytes.Buffer
+	w := DecryptForWriter(CipherChaCha20Poly1305, ids, &out, int64(len(sealed)))
+	for len(sealed) > 0 {
+		k := min(piece, len(sealed))
+		if _, err := w.Write(sealed[:k]); err != nil {
+			return out.Bytes(), err
+		}
+		sealed = sealed[k:]
+	}
+	return out.Bytes(), nil
+}
+
+func TestRecipients(t *testing.T) {
+	ids, keys := identities(t, 3)
+	plain := make([]byte, sealChunk+10)
+	rand.New(rand.NewSource(1)).Read(plain)
+	sealed := encryptTo(t, keys, plain)
+
+	// Each recipient on its own, and among identities that are not one
+	strangers, _ := identities(t, 2)
+	for i, id := range ids {
+		for _, piece := range []int{len(sealed), 1, 5000} {
+			got, err := decryptFor([]*Identity{strangers[0], id, strangers[1]}, sealed, piece)
+			if err != nil {
+				t.Fatalf("recipient %d, pieces of %d: %v", i, piece, err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Fatalf("recipient %d, pieces of %d: decrypted payload differs", i, piece)
+			}
+		}
+	}
+
+	_, err := decryptFor(strangers, sealed, 5000)
+	if !errors.Is(err, ErrNotRecipient) {
+		t.Fatalf("decrypt without a recipient's key: %v, want %v", err, ErrNotRecipient)
+	}
+
+	// The payload behind the stanzas is still authenticated
+	damaged := bytes.Clone(sealed)
+	damaged[len(damaged)-1] ^= 1
+	if _, err := decryptFor(ids[:1], damaged, 5000); !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("decrypt of a damaged payload: %v, want %v", err, ErrWrongKey)
+	}
+}
+
+func TestEncryptToRecipientsCount(t *testing.T) {
+	_, keys := identities(t, 1)
+	for _, n := range []int{0, maxRecipients + 1} {
+		recipients := make([]*ecdh.PublicKey, n)
+		for i := range recipients {
+			recipients[i] = keys[0]
+		}
+		if _, err := EncryptToRecipients(CipherAES256GCM, recipients, bytes.NewReader(nil), 0); err == nil {
+			t.Errorf("encrypted to %d recipients", n)
+		}
+	}
+}
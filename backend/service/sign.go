@@ -0,0 +1,188 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// Payloads can be signed with Ed25519. The signature covers the packed
+// metadata header, signer included, and the SHA-512 digest of the secret
+// as it was before encryption, so it can be checked while the payload
+// streams by.
+const (
+	SignerPrefix     = "stegosig-"
+	SigningKeyPrefix = "STEGO-SIGNING-KEY-"
+	signContext      = "Audio-Steganografi/v3/signature\x00"
+)
+
+// Signature statuses
+const (
+	SignatureNone      = "unsigned"
+	SignatureVerified  = "verified"  // valid, by a trusted key
+	SignatureUntrusted = "untrusted" // valid, by a key not trusted
+	SignatureInvalid   = "invalid"   // header or payload changed since signing
+)
+
+// SigningKey is an Ed25519 key payloads are signed with.
+type SigningKey struct {
+	key ed25519.PrivateKey
+}
+
+// GenerateSigningKey creates a new signing key.
+func GenerateSigningKey() (*SigningKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+	return &SigningKey{key}, nil
+}
+
+// ParseSigningKey parses a signing key written by SigningKey.String.
+func ParseSigningKey(s string) (*SigningKey, error) {
+	b, err := keyEncoding.DecodeString(strings.TrimPrefix(s, SigningKeyPrefix))
+	if err != nil || !strings.HasPrefix(s, SigningKeyPrefix) || len(b) != ed25519.SeedSize {
+		return nil, fmt.Errorf("malformed signing key")
+	}
+	return &SigningKey{ed25519.NewKeyFromSeed(b)}, nil
+}
+
+// String returns the signing key as text.
+func (k *SigningKey) String() string {
+	return SigningKeyPrefix + keyEncoding.EncodeToString(k.key.Seed())
+}
+
+// Public returns the public key.
+func (k *SigningKey) Public() []byte {
+	return k.key.Public().(ed25519.PublicKey)
+}
+
+// WriteSigningKey writes k to a new key file at path.
+func WriteSigningKey(path string, k *SigningKey) error {
+	return writeKeyFile(path, FormatSigner(k.Public()), k.String())
+}
+
+// LoadSigningKey reads the signing key of a key file.
+func LoadSigningKey(path string) (*SigningKey, error) {
+	var k *SigningKey
+	err := readKeyFile(path, func(line string, n int) error {
+		if k != nil {
+			return fmt.Errorf("more than one signing key")
+		}
+		var err error
+		k, err = ParseSigningKey(line)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if k == nil {
+		return nil, fmt.Errorf("no signing key in %s", path)
+	}
+	return k, nil
+}
+
+// FormatSigner returns an Ed25519 public key as text.
+func FormatSigner(pub []byte) string {
+	return SignerPrefix + keyEncoding.EncodeToString(pub)
+}
+
+// ParseSigner parses a public key written by FormatSigner.
+func ParseSigner(s string) (ed25519.PublicKey, error) {
+	b, err := keyEncoding.DecodeString(strings.TrimPrefix(s, SignerPrefix))
+	if err != nil || !strings.HasPrefix(s, SignerPrefix) || len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("malformed public key %q", s)
+	}
+	return b, nil
+}
+
+// TrustedKey is a public key whose signatures are accepted.
+type TrustedKey struct {
+	Name string
+	Key  ed25519.PublicKey
+}
+
+// ParseTrusted parses trusted keys, one per line: the public key and
+// optionally the name of its owner after white space.
+func ParseTrusted(text string) ([]TrustedKey, error) {
+	var keys []TrustedKey
+	err := keyLines(text, func(line string, n int) error {
+		s, name, _ := strings.Cut(line, " ")
+		key, err := ParseSigner(s)
+		keys = append(keys, TrustedKey{Name: strings.TrimSpace(name), Key: key})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// LoadTrusted reads the trusted keys of a file in the format of
+// ParseTrusted.
+func LoadTrusted(path string) ([]TrustedKey, error) {
+	var keys []TrustedKey
+	err := readKeyFile(path, func(line string, n int) error {
+		k, err := ParseTrusted(line)
+		keys = append(keys, k...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// NewDigest returns the hash of the secret a signature covers.
+func NewDigest() hash.Hash {
+	return sha512.New()
+}
+
+func signedMessage(header, digest []byte) []byte {
+	return append(append([]byte(signContext), header...), digest...)
+}
+
+// Sign signs the packed header and the digest of the secret.
+func (k *SigningKey) Sign(header, digest []byte) []byte {
+	return ed25519.Sign(k.key, signedMessage(header, digest))
+}
+
+// SignatureReport is the outcome of checking a signature.
+type SignatureReport struct {
+	Status string
+	Signer string // name of the trusted key, empty when unknown
+	Key    string // public key as text
+}
+
+func (r SignatureReport) String() string {
+	switch {
+	case r.Status == SignatureNone:
+		return r.Status
+	case r.Signer != "":
+		return fmt.Sprintf("%s, signed by %s (%s)", r.Status, r.Signer, r.Key)
+	}
+	return fmt.Sprintf("%s, signed by %s", r.Status, r.Key)
+}
+
+// Verify checks the signature sig by pub of the packed header and the
+// digest of the secret against the trusted keys.
+func Verify(pub, sig, header, digest []byte, trusted []TrustedKey) SignatureReport {
+	if len(pub) == 0 && len(sig) == 0 {
+		return SignatureReport{Status: SignatureNone}
+	}
+	r := SignatureReport{Status: SignatureInvalid, Key: FormatSigner(pub)}
+	if len(pub) != ed25519.PublicKeySize || !ed25519.Verify(pub, signedMessage(header, digest), sig) {
+		return r
+	}
+	r.Status = SignatureUntrusted
+	for _, t := range trusted {
+		if t.Key.Equal(ed25519.PublicKey(pub)) {
+			r.Status, r.Signer = SignatureVerified, t.Name
+			break
+		}
+	}
+	return r
+}
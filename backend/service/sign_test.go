@@ -0,0 +1,65 @@
+package service
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	alice, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mallory, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Through the text forms, as the keys are handed around
+	if alice, err = ParseSigningKey(alice.String()); err != nil {
+		t.Fatal(err)
+	}
+	trusted, err := ParseTrusted(FormatSigner(alice.Public()) + " Alice\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := []byte("packed header")
+	d := NewDigest()
+	d.Write([]byte("the secret"))
+	digest := d.Sum(nil)
+	sig := alice.Sign(header, digest)
+	flipped := func(b []byte) []byte {
+		b = bytes.Clone(b)
+		b[0] ^= 1
+		return b
+	}
+
+	tests := []struct {
+		name           string
+		pub, sig       []byte
+		header, digest []byte
+		trusted        []TrustedKey
+		want, signer   string
+	}{
+		{"unsigned", nil, nil, header, digest, trusted, SignatureNone, ""},
+		{"verified", alice.Public(), sig, header, digest, trusted, SignatureVerified, "Alice"},
+		{"untrusted", alice.Public(), sig, header, digest, nil, SignatureUntrusted, ""},
+		{"untrusted by others", mallory.Public(), mallory.Sign(header, digest), header, digest, trusted, SignatureUntrusted, ""},
+		{"header changed", alice.Public(), sig, flipped(header), digest, trusted, SignatureInvalid, ""},
+		{"payload changed", alice.Public(), sig, header, flipped(digest), trusted, SignatureInvalid, ""},
+		{"signature changed", alice.Public(), flipped(sig), header, digest, trusted, SignatureInvalid, ""},
+		{"signer swapped", mallory.Public(), sig, header, digest, trusted, SignatureInvalid, ""},
+		{"signer cut short", alice.Public()[:31], sig, header, digest, trusted, SignatureInvalid, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Verify(tt.pub, tt.sig, tt.header, tt.digest, tt.trusted)
+			if r.Status != tt.want || r.Signer != tt.signer {
+				t.Fatalf("got %s, signer %q; want %s, signer %q", r, r.Signer, tt.want, tt.signer)
+			}
+			if tt.want != SignatureNone && r.Key != FormatSigner(tt.pub) {
+				t.Fatalf("key %s, want %s", r.Key, FormatSigner(tt.pub))
+			}
+		})
+	}
+}
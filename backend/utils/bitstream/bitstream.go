@@ -0,0 +1,270 @@
+// Package bitstream reads and writes bits packed eight to a byte, most
+// significant bit first, the order the payload is embedded in.
+package bitstream
+
+import (
+	"bufio"
+	"io"
+)
+
+// BitWriter packs bits into a byte slice.
+type BitWriter struct {
+	buf []byte
+	n   int // bits written
+}
+
+// NewBitWriter returns a writer with room for size bits.
+func NewBitWriter(size int) *BitWriter {
+	return &BitWriter{buf: make([]byte, 0, (size+7)/8)}
+}
+
+// WriteBits appends the low n bits of v, n at most 64.
+func (w *BitWriter) WriteBits(v uint64, n int) {
+	for n > 0 {
+		free := 8 - w.n%8
+		if free == 8 {
+			w.buf = append(w.buf, 0)
+		}
+		k := min(free, n)
+		n -= k
+		w.buf[len(w.buf)-1] |= byte(v>>uint(n)&(1<<uint(k)-1)) << uint(free-k)
+		w.n += k
+	}
+}
+
+// WriteByte appends the 8 bits of c.
+func (w *BitWriter) WriteByte(c byte) error {
+	w.WriteBits(uint64(c), 8)
+	return nil
+}
+
+// Write appends the bits of p. It never fails.
+func (w *BitWriter) Write(p []byte) (int, error) {
+	if w.n%8 == 0 {
+		w.buf = append(w.buf, p...)
+		w.n += len(p) * 8
+		return len(p), nil
+	}
+	for _, c := range p {
+		w.WriteBits(uint64(c), 8)
+	}
+	return len(p), nil
+}
+
+// Len returns the number of bits written.
+func (w *BitWriter) Len() int {
+	return w.n
+}
+
+// Bytes returns the bits written, the last byte padded with zeros.
+func (w *BitWriter) Bytes() []byte {
+	return w.buf
+}
+
+// BitReader reads a known number of bits from a byte slice or an
+// io.Reader.
+type BitReader struct {
+	buf  []byte
+	src  io.ByteReader // nil when reading buf
+	acc  uint64        // bits taken from the source but not read yet
+	nacc int
+	left int64 // bits not read yet
+}
+
+// NewBitReader reads the first n bits of buf.
+func NewBitReader(buf []byte, n int) *BitReader {
+	return &BitReader{buf: buf, left: int64(min(n, len(buf)*8))}
+}
+
+// NewBitStreamReader reads n bits from r. Reads fail with
+// io.ErrUnexpectedEOF when r ends before them.
+func NewBitStreamReader(r io.Reader, n int64) *BitReader {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &BitReader{src: br, left: n}
+}
+
+// Remaining returns the number of bits not read yet.
+func (r *BitReader) Remaining() int64 {
+	return r.left
+}
+
+func (r *BitReader) next() (byte, error) {
+	if r.src == nil {
+		c := r.buf[0]
+		r.buf = r.buf[1:]
+		return c, nil
+	}
+	c, err := r.src.ReadByte()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return c, err
+}
+
+// ReadBits reads n bits, n at most 64, into the low bits of the result.
+// Asking for more bits than remain reads nothing and returns io.EOF.
+func (r *BitReader) ReadBits(n int) (uint64, error) {
+	if int64(n) > r.left {
+		return 0, io.EOF
+	}
+	if n > 32 {
+		hi, err := r.ReadBits(n - 32)
+		if err != nil {
+			return 0, err
+		}
+		lo, err := r.ReadBits(32)
+		return hi<<32 | lo, err
+	}
+	for r.nacc < n {
+		c, err := r.next()
+		if err != nil {
+			return 0, err
+		}
+		r.acc = r.acc<<8 | uint64(c)
+		r.nacc += 8
+	}
+	r.nacc -= n
+	v := r.acc >> uint(r.nacc)
+	r.acc &= 1<<uint(r.nacc) - 1
+	r.left -= int64(n)
+	return v, nil
+}
+
+// ReadByte reads the next 8 bits.
+func (r *BitReader) ReadByte() (byte, error) {
+	v, err := r.ReadBits(8)
+	return byte(v), err
+}
+
+// Read reads whole bytes into p, io.EOF once fewer than 8 bits remain.
+func (r *BitReader) Read(p []byte) (int, error) {
+	n := int(min(int64(len(p)), r.left/8))
+	if n == 0 && len(p) > 0 {
+		return 0, io.EOF
+	}
+	if r.src == nil && r.nacc == 0 {
+		copy(p, r.buf[:n])
+		r.buf = r.buf[n:]
+		r.left -= int64(n) * 8
+		return n, nil
+	}
+	for i := range p[:n] {
+		c, err := r.ReadByte()
+		if err != nil {
+			return i, err
+		}
+		p[i] = c
+	}
+	return n, nil
+}
+
+// Find skips to just after the first occurrence of the n bit pattern, n at
+// most 64. It reports false when the bits run out first.
+func (r *BitReader) Find(pattern uint64, n int) bool {
+	mask := uint64(1)<<uint(n) - 1
+	if n == 64 {
+		mask = ^uint64(0)
+	}
+	var window uint64
+	for seen := 1; r.left > 0; seen++ {
+		b, err := r.ReadBits(1)
+		if err != nil {
+			return false
+		}
+		if window = (window<<1 | b) & mask; seen >= n && window == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// NewUnitReader reads the low width bits of the units at the positions in
+// order, or of every unit when order is nil: the bits embedded in them.
+// skip lists, ascending, the indices into that sequence to leave out.
+func NewUnitReader(units []byte, order, skip []int, width int) *BitReader {
+	n := len(order)
+	if order == nil {
+		n = len(units)
+	}
+	for len(skip) > 0 && skip[len(skip)-1] >= n {
+		skip = skip[:len(skip)-1]
+	}
+	u := &unitSource{units: units, order: order, skip: skip, width: width, mask: 1<<uint(width) - 1}
+	return &BitReader{src: u, left: int64(n-len(skip)) * int64(width)}
+}
+
+// NewMatrixReader reads the bits matrix embedded with the (1, 2^k-1, k)
+// Hamming code in the units NewUnitReader would read with width 1: k bits
+// per block of 2^k-1 units, the XOR of the one-based indices in the block
+// of the units whose lowest bit is set.
+func NewMatrixReader(units []byte, order, skip []int, k int) *BitReader {
+	r := NewUnitReader(units, order, skip, 1)
+	u := r.src.(*unitSource)
+	u.width, u.block = k, 1<<uint(k)-1
+	r.left = r.left / int64(u.block) * int64(k)
+	return r
+}
+
+type unitSource struct {
+	units []byte
+	order []int
+	skip  []int
+	next  int
+	width int // bits per unit, or per block
+	mask  byte
+	block int // units per block of matrix embedding, 0 without
+	acc   uint
+	nacc  int
+}
+
+// unit returns the next unit. It reports false when there is none.
+func (u *unitSource) unit() (byte, bool) {
+	for len(u.skip) > 0 && u.skip[0] == u.next {
+		u.skip = u.skip[1:]
+		u.next++
+	}
+	pos := u.next
+	if u.order != nil {
+		if pos >= len(u.order) {
+			return 0, false
+		}
+		pos = u.order[pos]
+	} else if pos >= len(u.units) {
+		return 0, false
+	}
+	u.next++
+	return u.units[pos], true
+}
+
+// ReadByte packs the bits of the next units, the last byte padded with
+// zeros.
+func (u *unitSource) ReadByte() (byte, error) {
+	for u.nacc < 8 {
+		var v uint
+		ok := true
+		if u.block == 0 {
+			var c byte
+			c, ok = u.unit()
+			v = uint(c & u.mask)
+		}
+		for i := 1; i <= u.block && ok; i++ {
+			var c byte
+			if c, ok = u.unit(); c&1 != 0 {
+				v ^= uint(i)
+			}
+		}
+		if !ok {
+			u.acc, u.nacc = u.acc<<uint(8-u.nacc), 8
+			break
+		}
+		u.acc = u.acc<<uint(u.width) | v
+		u.nacc += u.width
+	}
+	u.nacc -= 8
+	c := byte(u.acc >> uint(u.nacc))
+	u.acc &= 1<<uint(u.nacc) - 1
+	return c, nil
+}
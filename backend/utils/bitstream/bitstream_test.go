@@ -0,0 +1,200 @@
+package bitstream
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+)
+
+// field is a value written with n bits.
+type field struct {
+	v uint64
+	n int
+}
+
+// fields returns values of every width from 1 to 64 in random order,
+// so that most of them cross byte boundaries.
+func fields(rng *rand.Rand) []field {
+	var fs []field
+	for _, n := range rng.Perm(64) {
+		n++
+		v := rng.Uint64()
+		if n < 64 {
+			v &= 1<<uint(n) - 1
+		}
+		fs = append(fs, field{v, n})
+	}
+	return fs
+}
+
+func TestWriteReadBits(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	fs := fields(rng)
+	w := NewBitWriter(0)
+	total := 0
+	for i, f := range fs {
+		w.WriteBits(f.v, f.n)
+		total += f.n
+		// Whole bytes at whatever offset the bits got to
+		if i%10 == 0 {
+			w.Write([]byte{0xA5, 0x3C})
+			w.WriteByte(0xFF)
+			total += 24
+		}
+	}
+	if w.Len() != total || len(w.Bytes()) != (total+7)/8 {
+		t.Fatalf("wrote %d bits into %d bytes, want %d bits", w.Len(), len(w.Bytes()), total)
+	}
+	if pad := 8 - total%8; pad < 8 && w.Bytes()[len(w.Bytes())-1]&(1<<uint(pad)-1) != 0 {
+		t.Fatal("the last byte is not padded with zeros")
+	}
+
+	readers := map[string]*BitReader{
+		"bytes":     NewBitReader(w.Bytes(), total),
+		"reader":    NewBitStreamReader(bytes.NewReader(w.Bytes()), int64(total)),
+		"one byte":  NewBitStreamReader(iotest.OneByteReader(bytes.NewReader(w.Bytes())), int64(total)),
+		"too short": NewBitReader(w.Bytes(), total+100), // n beyond the bytes is cut
+	}
+	for name, r := range readers {
+		for i, f := range fs {
+			got, err := r.ReadBits(f.n)
+			if err != nil || got != f.v {
+				t.Fatalf("%s: field %d of %d bits: %#x, %v; want %#x", name, i, f.n, got, err, f.v)
+			}
+			if i%10 == 0 {
+				p := make([]byte, 2)
+				if n, err := r.Read(p); n != 2 || err != nil || !bytes.Equal(p, []byte{0xA5, 0x3C}) {
+					t.Fatalf("%s: bytes after field %d: % x, %v", name, i, p[:n], err)
+				}
+				if c, err := r.ReadByte(); c != 0xFF || err != nil {
+					t.Fatalf("%s: byte after field %d: %#x, %v", name, i, c, err)
+				}
+			}
+		}
+		if r.Remaining() != 0 {
+			t.Fatalf("%s: %d bits left", name, r.Remaining())
+		}
+	}
+}
+
+func TestReadPastEnd(t *testing.T) {
+	buf := []byte{0xDE, 0xAD, 0xBE}
+	r := NewBitReader(buf, 20)
+	if _, err := r.ReadBits(21); err != io.EOF {
+		t.Fatalf("read of 21 of 20 bits: %v, want io.EOF", err)
+	}
+	// Nothing was taken
+	if v, err := r.ReadBits(4); v != 0xD || err != nil {
+		t.Fatalf("first 4 bits: %#x, %v", v, err)
+	}
+	p := make([]byte, 4)
+	if n, err := r.Read(p); n != 2 || err != nil || !bytes.Equal(p[:n], []byte{0xEA, 0xDB}) {
+		t.Fatalf("read of whole bytes: % x, %v", p[:n], err)
+	}
+	if n, err := r.Read(p); n != 0 || err != io.EOF {
+		t.Fatalf("read with 0 bits left: %d, %v", n, err)
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Fatalf("byte read with 0 bits left: %v", err)
+	}
+
+	// A source ending before the bits promised
+	s := NewBitStreamReader(bytes.NewReader(buf), 40)
+	if v, err := s.ReadBits(24); v != 0xDEADBE || err != nil {
+		t.Fatalf("first 24 bits: %#x, %v", v, err)
+	}
+	if _, err := s.ReadBits(1); err != io.ErrUnexpectedEOF {
+		t.Fatalf("read past the source: %v, want io.ErrUnexpectedEOF", err)
+	}
+	if _, err := io.ReadAll(NewBitStreamReader(bytes.NewReader(buf), 40)); err != io.ErrUnexpectedEOF {
+		t.Fatalf("reading all of a short source: %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestFind(t *testing.T) {
+	const pattern, n = 0b10110011101, 11
+	for off := 0; off < 24; off++ {
+		w := NewBitWriter(0)
+		w.WriteBits(0, off)
+		w.WriteBits(pattern, n)
+		w.WriteByte(0x5A)
+		for name, r := range map[string]*BitReader{
+			"bytes":  NewBitReader(w.Bytes(), w.Len()),
+			"reader": NewBitStreamReader(bytes.NewReader(w.Bytes()), int64(w.Len())),
+		} {
+			if !r.Find(pattern, n) {
+				t.Fatalf("%s: pattern at bit %d not found", name, off)
+			}
+			if c, err := r.ReadByte(); c != 0x5A || err != nil || r.Remaining() != 0 {
+				t.Fatalf("%s: pattern at bit %d: byte after it %#x, %v, %d bits left", name, off, c, err, r.Remaining())
+			}
+		}
+	}
+
+	// Found when it ends the bits, not when they end within it
+	w := NewBitWriter(0)
+	w.WriteBits(0b111, 3)
+	w.WriteBits(pattern, n)
+	if r := NewBitReader(w.Bytes(), w.Len()); !r.Find(pattern, n) || r.Remaining() != 0 {
+		t.Fatal("pattern ending the bits not found")
+	}
+	if r := NewBitReader(w.Bytes(), w.Len()-1); r.Find(pattern, n) || r.Remaining() != 0 {
+		t.Fatal("pattern cut short found")
+	}
+	if r := NewBitStreamReader(bytes.NewReader(w.Bytes()[:1]), int64(w.Len())); r.Find(pattern, n) {
+		t.Fatal("pattern past the end of the source found")
+	}
+
+	// All 64 bits
+	w = NewBitWriter(0)
+	w.WriteBits(0, 5)
+	w.WriteBits(^uint64(0)>>1, 64)
+	if r := NewBitReader(w.Bytes(), w.Len()); !r.Find(^uint64(0)>>1, 64) || r.Remaining() != 0 {
+		t.Fatal("64 bit pattern not found")
+	}
+}
+
+func TestUnitReader(t *testing.T) {
+	units := []byte{0xF1, 0xE2, 0xD3, 0xC4, 0xB5, 0xA6, 0x97, 0x88, 0x79}
+	tests := []struct {
+		name        string
+		order, skip []int
+		width       int
+		want        []uint64 // width bits each
+	}{
+		{"width 1", nil, nil, 1, []uint64{1, 0, 1, 0, 1, 0, 1, 0, 1}},
+		{"width 3", nil, nil, 3, []uint64{1, 2, 3, 4, 5, 6, 7, 0, 1}},
+		{"width 4 in order", []int{8, 0, 4}, nil, 4, []uint64{9, 1, 5}},
+		{"skipped", nil, []int{0, 2, 3, 20}, 2, []uint64{2, 1, 2, 3, 0, 1}},
+		{"skipped in order", []int{8, 7, 6, 5}, []int{1}, 4, []uint64{9, 7, 6}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewUnitReader(units, tt.order, tt.skip, tt.width)
+			if r.Remaining() != int64(len(tt.want)*tt.width) {
+				t.Fatalf("%d bits, want %d", r.Remaining(), len(tt.want)*tt.width)
+			}
+			for i, v := range tt.want {
+				got, err := r.ReadBits(tt.width)
+				if err != nil || got != v {
+					t.Fatalf("unit %d: %d, %v; want %d", i, got, err, v)
+				}
+			}
+			if _, err := r.ReadBits(1); err != io.EOF {
+				t.Fatalf("read past the units: %v", err)
+			}
+		})
+	}
+
+	// Bytes across units, and the bits of the last ones padded
+	r := NewUnitReader(units, nil, nil, 3)
+	p := make([]byte, 4)
+	if n, err := r.Read(p); n != 3 || err != nil || !bytes.Equal(p[:n], []byte{0b00101001, 0b11001011, 0b10111000}) {
+		t.Fatalf("bytes of 3 bit units: %08b, %v", p[:n], err)
+	}
+	if v, err := r.ReadBits(3); v != 1 || err != nil {
+		t.Fatalf("last unit: %d, %v", v, err)
+	}
+}
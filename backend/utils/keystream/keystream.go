@@ -0,0 +1,63 @@
+// Package keystream draws numbers from a ChaCha20 keystream. The numbers
+// decide where a payload is embedded, so they are part of the file format:
+// the way they are drawn must never change for an existing format version.
+package keystream
+
+import (
+	"math/bits"
+
+	"golang.org/x/crypto/chacha20"
+)
+
+// Source is a deterministic source of uniform numbers keyed by 32 bytes.
+type Source struct {
+	c   *chacha20.Cipher
+	buf [4096]byte
+	n   int // bytes of buf used
+}
+
+// New returns the source for key, which must be 32 bytes long.
+func New(key []byte) *Source {
+	c, err := chacha20.NewUnauthenticatedCipher(key, make([]byte, chacha20.NonceSize))
+	if err != nil {
+		panic(err)
+	}
+	s := &Source{c: c}
+	s.n = len(s.buf)
+	return s
+}
+
+// Uint64 returns the next 8 bytes of the keystream, little-endian.
+func (s *Source) Uint64() uint64 {
+	if s.n+8 > len(s.buf) {
+		clear(s.buf[:])
+		s.c.XORKeyStream(s.buf[:], s.buf[:])
+		s.n = 0
+	}
+	b := s.buf[s.n : s.n+8]
+	s.n += 8
+	return uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24 |
+		uint64(b[4])<<32 | uint64(b[5])<<40 | uint64(b[6])<<48 | uint64(b[7])<<56
+}
+
+// Intn returns a uniform number in [0, n), n > 0, by multiplying and
+// rejecting the low products that would bias the result.
+func (s *Source) Intn(n int) int {
+	bound := uint64(n)
+	hi, lo := bits.Mul64(s.Uint64(), bound)
+	if lo < bound {
+		threshold := -bound % bound
+		for lo < threshold {
+			hi, lo = bits.Mul64(s.Uint64(), bound)
+		}
+	}
+	return int(hi)
+}
+
+// Shuffle permutes order with the Fisher-Yates algorithm, from the end.
+func (s *Source) Shuffle(order []int) {
+	for i := len(order) - 1; i > 0; i-- {
+		j := s.Intn(i + 1)
+		order[i], order[j] = order[j], order[i]
+	}
+}
@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// fft is an in-place radix-2 FFT; len(x) must be a power of two.
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j |= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		w := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			t := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], x[start+k+size/2]*t
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				t *= w
+			}
+		}
+	}
+}
+
+// spectrum holds the windowed power spectra of one channel, frame by frame.
+type spectrum struct {
+	window []float64
+	norm   float64
+	buf    []complex128
+}
+
+func newSpectrum(n int) *spectrum {
+	s := &spectrum{window: make([]float64, n), buf: make([]complex128, n)}
+	var sum float64
+	for i := range s.window {
+		s.window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n))
+		sum += s.window[i]
+	}
+	// A full-scale sine has a peak bin power of 0.25
+	s.norm = 1 / (sum * sum)
+	return s
+}
+
+// power returns the power of bins 0 to n/2 of frame, reusing out.
+func (s *spectrum) power(frame []float64, out []float64) []float64 {
+	for i, v := range frame {
+		s.buf[i] = complex(v*s.window[i], 0)
+	}
+	fft(s.buf)
+	out = out[:0]
+	for k := 0; k <= len(s.buf)/2; k++ {
+		a := cmplx.Abs(s.buf[k])
+		out = append(out, a*a*s.norm)
+	}
+	return out
+}
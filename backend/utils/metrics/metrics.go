@@ -0,0 +1,293 @@
+// Package metrics computes objective quality figures of a stego signal
+// against its cover, both as decoded PCM.
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/pcm"
+)
+
+const (
+	fftSize      = 2048
+	segmentMs    = 20
+	segMin       = -10.0 // segmental SNR clamp, dB
+	segMax       = 35.0
+	silence      = 1e-6  // mean power below which a segment is skipped (-60 dBFS)
+	spectrumEps  = 1e-10 // power floor of a bin (-100 dBFS)
+	maskingFloor = 1e-10 // per bin, keeps quiet bands from dominating the NMR
+)
+
+// Upper edges of the critical bands in Hz.
+var barkEdges = []float64{100, 200, 300, 400, 510, 630, 770, 920, 1080, 1270, 1480, 1720, 2000, 2320, 2700, 3150, 3700, 4400, 5300, 6400, 7700, 9500, 12000, 15500}
+
+// Report holds the quality figures of a stego signal. All channels are
+// evaluated separately and averaged.
+type Report struct {
+	SNR            float64 // dB, +Inf when identical
+	SegmentalSNR   float64 // dB over 20 ms segments, each clamped to [-10, 35]
+	LSD            float64 // log-spectral distance, dB
+	FlatnessCover  float64 // mean spectral flatness, 0 (tonal) to 1 (white)
+	FlatnessStego  float64
+	FlatnessChange float64 // stego minus cover
+	NMR            float64 // noise-to-mask ratio, dB, -Inf when identical
+	ODG            float64 // objective difference grade estimate, 0 to -4
+	MaxDeviation   float64 // largest sample difference in quantization steps (full scale for float)
+	Samples        int     // sample frames compared
+}
+
+// Compare evaluates stego against cover. Both must have the same layout
+// and length.
+func Compare(cover, stego *pcm.Buffer) (*Report, error) {
+	if cover.Channels != stego.Channels || cover.Channels == 0 {
+		return nil, fmt.Errorf("channel counts don't match: cover=%d, stego=%d", cover.Channels, stego.Channels)
+	}
+	if len(cover.Samples) != len(stego.Samples) {
+		return nil, fmt.Errorf("audio lengths don't match: cover=%d, stego=%d", len(cover.Samples), len(stego.Samples))
+	}
+	if len(cover.Samples) == 0 {
+		return nil, fmt.Errorf("empty audio data")
+	}
+	if cover.SampleRate <= 0 {
+		return nil, fmt.Errorf("unknown sample rate")
+	}
+
+	x, y := cover.Normalized(), stego.Normalized()
+	nch := cover.Channels
+	r := &Report{Samples: cover.Frames()}
+
+	scale := 1.0
+	if !cover.Float {
+		scale = float64(int64(1) << uint(cover.BitDepth-1))
+	}
+	for i := range x {
+		r.MaxDeviation = math.Max(r.MaxDeviation, math.Abs(x[i]-y[i])*scale)
+	}
+
+	var signal, noise float64
+	var seg, lsd, fc, fs, nmr float64
+	var segs, frames int
+	for ch := 0; ch < nch; ch++ {
+		a, b := channel(x, ch, nch), channel(y, ch, nch)
+		for i := range a {
+			d := a[i] - b[i]
+			signal += a[i] * a[i]
+			noise += d * d
+		}
+		s, n := segmental(a, b, cover.SampleRate*segmentMs/1000)
+		seg += s
+		segs += n
+		st := spectral(a, b, cover.SampleRate)
+		lsd += st.lsd
+		fc += st.flatCover
+		fs += st.flatStego
+		nmr += st.nmr
+		frames += st.frames
+	}
+
+	r.SNR = ratio(signal, noise)
+	if segs > 0 {
+		r.SegmentalSNR = seg / float64(segs)
+	}
+	if frames > 0 {
+		r.LSD = lsd / float64(frames)
+		r.FlatnessCover = fc / float64(frames)
+		r.FlatnessStego = fs / float64(frames)
+		r.FlatnessChange = r.FlatnessStego - r.FlatnessCover
+		r.NMR = 10 * math.Log10(nmr/float64(frames))
+	} else {
+		r.NMR = math.Inf(-1)
+	}
+	r.ODG = odg(r.NMR)
+	return r, nil
+}
+
+// channel extracts one channel of interleaved samples.
+func channel(x []float64, ch, nch int) []float64 {
+	out := make([]float64, 0, len(x)/nch)
+	for i := ch; i < len(x); i += nch {
+		out = append(out, x[i])
+	}
+	return out
+}
+
+func ratio(signal, noise float64) float64 {
+	if noise == 0 {
+		return math.Inf(1)
+	}
+	if signal == 0 {
+		return math.Inf(-1)
+	}
+	return 10 * math.Log10(signal/noise)
+}
+
+// segmental returns the sum of the clamped SNR of the non-silent segments
+// and their number.
+func segmental(a, b []float64, size int) (float64, int) {
+	size = max(size, 1)
+	var sum float64
+	n := 0
+	for start := 0; start+size <= len(a); start += size {
+		var signal, noise float64
+		for i := start; i < start+size; i++ {
+			d := a[i] - b[i]
+			signal += a[i] * a[i]
+			noise += d * d
+		}
+		if signal/float64(size) < silence {
+			continue
+		}
+		sum += math.Min(math.Max(ratio(signal, noise), segMin), segMax)
+		n++
+	}
+	return sum, n
+}
+
+type spectralStats struct {
+	lsd, flatCover, flatStego, nmr float64
+	frames                         int
+}
+
+// spectral sums the per-frame log-spectral distance, spectral flatness and
+// noise-to-mask ratio over the non-silent frames of one channel.
+func spectral(a, b []float64, rate int) spectralStats {
+	var st spectralStats
+	sp := newSpectrum(fftSize)
+	var p, q, noise []float64
+	diff := make([]float64, fftSize)
+
+	bands := bandBins(rate)
+	for start := 0; start+fftSize <= len(a); start += fftSize {
+		fa, fb := a[start:start+fftSize], b[start:start+fftSize]
+		var energy float64
+		for i := range fa {
+			energy += fa[i] * fa[i]
+			diff[i] = fa[i] - fb[i]
+		}
+		if energy/fftSize < silence {
+			continue
+		}
+		p = sp.power(fa, p)
+		q = sp.power(fb, q)
+		noise = sp.power(diff, noise)
+
+		var d float64
+		for k := range p {
+			l := 10 * math.Log10((p[k]+spectrumEps)/(q[k]+spectrumEps))
+			d += l * l
+		}
+		st.lsd += math.Sqrt(d / float64(len(p)))
+
+		flat := flatness(p)
+		st.flatCover += flat
+		st.flatStego += flatness(q)
+
+		// Johnston's masking offset: tonal frames mask less than noisy ones
+		sfm := 10 * math.Log10(math.Max(flat, 1e-12))
+		alpha := math.Min(sfm/-60, 1)
+		var ratios float64
+		for z, band := range bands {
+			var e, n float64
+			for k := band[0]; k < band[1]; k++ {
+				e += p[k]
+				n += noise[k]
+			}
+			offset := alpha*(14.5+float64(z+1)) + (1-alpha)*5.5
+			mask := math.Max(e*math.Pow(10, -offset/10), maskingFloor*float64(band[1]-band[0]))
+			ratios += n / mask
+		}
+		st.nmr += ratios / float64(len(bands))
+		st.frames++
+	}
+	return st
+}
+
+// bandBins returns the FFT bin range of every critical band below Nyquist.
+func bandBins(rate int) [][2]int {
+	var bands [][2]int
+	lo := 1
+	nyquist := float64(rate) / 2
+	edges := append(append([]float64(nil), barkEdges...), nyquist)
+	for _, edge := range edges {
+		if edge > nyquist {
+			edge = nyquist
+		}
+		hi := min(int(edge*fftSize/float64(rate))+1, fftSize/2+1)
+		if hi > lo {
+			bands = append(bands, [2]int{lo, hi})
+			lo = hi
+		}
+		if edge == nyquist {
+			break
+		}
+	}
+	return bands
+}
+
+// flatness is the geometric over the arithmetic mean of a power spectrum,
+// DC excluded.
+func flatness(p []float64) float64 {
+	var logs, sum float64
+	for _, v := range p[1:] {
+		v += spectrumEps
+		logs += math.Log(v)
+		sum += v
+	}
+	n := float64(len(p) - 1)
+	return math.Exp(logs/n) / (sum / n)
+}
+
+// odg maps the noise-to-mask ratio to the objective difference grade
+// scale of PEAQ: 0 imperceptible, -1 perceptible but not annoying, -2
+// slightly annoying, -3 annoying, -4 very annoying. It is a rough estimate
+// from one model output, not an ITU-R BS.1387 implementation.
+func odg(nmr float64) float64 {
+	if math.IsInf(nmr, -1) {
+		return 0
+	}
+	return -4 / (1 + math.Exp(-(nmr-2)/3))
+}
+
+// Grade describes an ODG value in the words of the impairment scale.
+func Grade(odg float64) string {
+	switch {
+	case odg > -0.5:
+		return "imperceptible"
+	case odg > -1.5:
+		return "perceptible, but not annoying"
+	case odg > -2.5:
+		return "slightly annoying"
+	case odg > -3.5:
+		return "annoying"
+	default:
+		return "very annoying"
+	}
+}
+
+func (r *Report) String() string {
+	db := func(v float64) string {
+		switch {
+		case math.IsInf(v, 1):
+			return "∞ dB"
+		case math.IsInf(v, -1):
+			return "-∞ dB"
+		}
+		return fmt.Sprintf("%.2f dB", v)
+	}
+	grade := r.ODG
+	if grade > -0.005 {
+		grade = 0 // no "-0.00"
+	}
+	lines := []string{
+		fmt.Sprintf("SNR: %s", db(r.SNR)),
+		fmt.Sprintf("Segmental SNR: %s", db(r.SegmentalSNR)),
+		fmt.Sprintf("Log-spectral distance: %.4f dB", r.LSD),
+		fmt.Sprintf("Spectral flatness: %.4f -> %.4f (%+.2e)", r.FlatnessCover, r.FlatnessStego, r.FlatnessChange),
+		fmt.Sprintf("Noise-to-mask ratio: %s", db(r.NMR)),
+		fmt.Sprintf("ODG estimate: %.2f (%s)", grade, Grade(r.ODG)),
+		fmt.Sprintf("Max deviation: %g", r.MaxDeviation),
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,103 @@
+package psnr
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/mp3"
+	"github.com/rifchzschki/Audio-Steganografi/backend/models/pcm"
+)
+
+// AudioReport compares two MP3 files on their decoded samples.
+type AudioReport struct {
+	PSNR     float64 // all channels together, +Inf when identical
+	MSE      float64
+	Channels []Channel // one per channel of the cover
+	Samples  int       // sample frames compared
+
+	// Frames are aligned by index; frames that failed to decode in either
+	// file are left out of the figures
+	CoverFailed []int
+	StegoFailed []int
+	Skipped     int // frames only one of the files has
+
+	// The aligned samples the figures were computed on
+	Cover, Stego *pcm.Buffer
+}
+
+// Channel holds the figures of one channel.
+type Channel struct {
+	PSNR float64
+	MSE  float64
+}
+
+// CompareMP3 decodes the cover and the stego MP3 and computes PSNR and MSE
+// on the 16-bit samples, per channel and overall.
+func CompareMP3(cover, stego *mp3.File) (*AudioReport, error) {
+	var a, b *mp3.PCM
+	var wg sync.WaitGroup
+	wg.Go(func() { a = cover.DecodePCM() })
+	b = stego.DecodePCM()
+	wg.Wait()
+	if a.Channels != b.Channels {
+		return nil, fmt.Errorf("channel counts don't match: cover=%d, stego=%d", a.Channels, b.Channels)
+	}
+	r := &AudioReport{
+		Channels:    make([]Channel, a.Channels),
+		CoverFailed: a.Failed,
+		StegoFailed: b.Failed,
+	}
+	n := min(len(a.Frames), len(b.Frames))
+	r.Skipped = len(a.Frames) + len(b.Frames) - 2*n
+	r.Cover = &pcm.Buffer{SampleRate: a.SampleRate, Channels: a.Channels, BitDepth: 16}
+	r.Stego = &pcm.Buffer{SampleRate: b.SampleRate, Channels: b.Channels, BitDepth: 16}
+
+	sum := make([]float64, a.Channels)
+	for i := 0; i < n; i++ {
+		x, y := a.Frames[i], b.Frames[i]
+		if x == nil || y == nil || len(x) != len(y) {
+			continue
+		}
+		for k := range x {
+			d := float64(x[k]) - float64(y[k])
+			sum[k%a.Channels] += d * d
+			r.Cover.Samples = append(r.Cover.Samples, int32(x[k]))
+			r.Stego.Samples = append(r.Stego.Samples, int32(y[k]))
+		}
+		r.Samples += len(x) / a.Channels
+	}
+	if r.Samples == 0 {
+		return nil, fmt.Errorf("no frames decoded in both files")
+	}
+
+	var total float64
+	for ch := range sum {
+		total += sum[ch]
+		r.Channels[ch].MSE = sum[ch] / float64(r.Samples)
+		r.Channels[ch].PSNR = fromMSE(r.Channels[ch].MSE)
+	}
+	r.MSE = total / float64(r.Samples*a.Channels)
+	r.PSNR = fromMSE(r.MSE)
+	return r, nil
+}
+
+// fromMSE converts a mean squared error of 16-bit samples to PSNR.
+func fromMSE(mse float64) float64 {
+	return fromError(mse, 32767)
+}
+
+func (r *AudioReport) String() string {
+	s := fmt.Sprintf("%s (MSE %.4f) over %d samples", FormatPSNR(r.PSNR), r.MSE, r.Samples)
+	if len(r.Channels) > 1 {
+		for ch, c := range r.Channels {
+			s += fmt.Sprintf(", channel %d %s", ch+1, FormatPSNR(c.PSNR))
+		}
+	}
+	if len(r.CoverFailed) > 0 || len(r.StegoFailed) > 0 {
+		s += fmt.Sprintf(", %d cover and %d stego frames failed to decode", len(r.CoverFailed), len(r.StegoFailed))
+	}
+	if r.Skipped > 0 {
+		s += fmt.Sprintf(", %d unmatched frames", r.Skipped)
+	}
+	return s
+}
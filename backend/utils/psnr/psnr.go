@@ -3,6 +3,8 @@ package psnr
 import (
     "fmt"
     "math"
+
+    "github.com/rifchzschki/Audio-Steganografi/backend/models/pcm"
 )
 
 
@@ -109,6 +111,59 @@ func CalculatePSNRByte(original, stego []byte) (float64, error) {
 }
 
 
+// CalculatePSNRPCM compares two decoded sample buffers using the full scale
+// of their bit depth as the peak value.
+func CalculatePSNRPCM(original, stego *pcm.Buffer) (float64, error) {
+    if original.Float {
+        return CalculatePSNRFloat(original.Normalized(), stego.Normalized())
+    }
+    if len(original.Samples) != len(stego.Samples) {
+        return 0, fmt.Errorf("audio lengths don't match: original=%d, stego=%d", len(original.Samples), len(stego.Samples))
+    }
+    if len(original.Samples) == 0 {
+        return 0, fmt.Errorf("empty audio data")
+    }
+
+    var mse float64
+    for i := range original.Samples {
+        diff := float64(original.Samples[i]) - float64(stego.Samples[i])
+        mse += diff * diff
+    }
+    mse /= float64(len(original.Samples))
+
+    if mse == 0 {
+        return math.Inf(1), nil
+    }
+
+    maxValue := float64(int64(1)<<uint(original.BitDepth-1)) - 1
+    return 10 * math.Log10((maxValue*maxValue)/mse), nil
+}
+
+// CalculatePSNRLowBytes is CalculatePSNRPCM for integer samples that only
+// differ in their low bytes, given before and after.
+func CalculatePSNRLowBytes(original, stego []byte, bitDepth int) (float64, error) {
+    if len(original) != len(stego) {
+        return 0, fmt.Errorf("audio lengths don't match: original=%d, stego=%d", len(original), len(stego))
+    }
+    if len(original) == 0 {
+        return 0, fmt.Errorf("empty audio data")
+    }
+
+    var mse float64
+    for i := range original {
+        diff := float64(int(stego[i]) - int(original[i]))
+        mse += diff * diff
+    }
+    mse /= float64(len(original))
+
+    if mse == 0 {
+        return math.Inf(1), nil
+    }
+
+    maxValue := float64(int64(1)<<uint(bitDepth-1)) - 1
+    return 10 * math.Log10((maxValue*maxValue)/mse), nil
+}
+
 func DetectAudioFormat(original, stego []byte) (float64, string, error) {
     
     if len(original)%2 == 0 && len(original) > 0 {
@@ -0,0 +1,57 @@
+package psnr
+
+import (
+	"fmt"
+	"math"
+)
+
+// Running accumulates the error between two byte streams chunk by chunk,
+// for inputs too large to hold in memory.
+type Running struct {
+	n       int64
+	bytes   float64 // squared error of the bytes
+	pairs   float64 // squared error of the little-endian 16-bit pairs
+	pending [2]byte // first byte of an unfinished pair, original and stego
+}
+
+// Add feeds the next bytes of both streams, which must have equal lengths.
+func (r *Running) Add(original, stego []byte) {
+	for i := range original {
+		a, b := original[i], stego[i]
+		d := float64(a) - float64(b)
+		r.bytes += d * d
+		if r.n%2 == 1 {
+			d = float64(int16(r.pending[0])|int16(a)<<8) - float64(int16(r.pending[1])|int16(b)<<8)
+			r.pairs += d * d
+		}
+		r.pending = [2]byte{a, b}
+		r.n++
+	}
+}
+
+// Result gives what DetectAudioFormat gives on the whole streams.
+func (r *Running) Result() (float64, string, error) {
+	if r.n == 0 {
+		return 0, "", fmt.Errorf("empty audio data")
+	}
+	if r.n%2 == 0 {
+		return fromError(r.pairs/float64(r.n/2), 32767), "16-bit PCM", nil
+	}
+	return fromError(r.bytes/float64(r.n), 255), "8-bit PCM", nil
+}
+
+// PCM gives what CalculatePSNRPCM gives when the streams are the low bytes
+// of integer samples of bitDepth bits and nothing else changed.
+func (r *Running) PCM(bitDepth int) (float64, error) {
+	if r.n == 0 {
+		return 0, fmt.Errorf("empty audio data")
+	}
+	return fromError(r.bytes/float64(r.n), float64(int64(1)<<uint(bitDepth-1))-1), nil
+}
+
+func fromError(mse, peak float64) float64 {
+	if mse == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(peak*peak/mse)
+}
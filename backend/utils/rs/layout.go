@@ -0,0 +1,258 @@
+package rs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// MaxParity keeps more data than parity bytes in a full codeword, a code
+// rate above one half.
+const MaxParity = 127
+
+// lengthSize is the size of the length in front of the data.
+const lengthSize = 4
+
+// Layout is how a stream of bytes is coded: its length first, in a
+// codeword of its own, then the data in codewords of up to 255 bytes with
+// Parity parity bytes each. Depth codewords at a time are interleaved byte
+// by byte, so a burst of up to Depth*Parity/2 wrong bytes leaves none of
+// them with more than it corrects. The last group has as few codewords as
+// hold the rest of the data, shortened evenly.
+type Layout struct {
+	Parity int
+	Depth  int
+}
+
+// Check reports a layout that cannot be used.
+func (l Layout) Check() error {
+	if l.Parity < 2 || l.Parity > MaxParity {
+		return fmt.Errorf("parity must be between 2 and %d bytes per codeword", MaxParity)
+	}
+	if l.Depth < 1 || l.Depth > 255 {
+		return fmt.Errorf("interleaving depth must be between 1 and 255 codewords")
+	}
+	return nil
+}
+
+func (l Layout) String() string {
+	return fmt.Sprintf("RS(255,%d) interleaved %d deep", l.data(), l.Depth)
+}
+
+// data is the number of data bytes in a full codeword.
+func (l Layout) data() int {
+	return 255 - l.Parity
+}
+
+// EncodedSize returns the size of n bytes once coded.
+func (l Layout) EncodedSize(n int64) int64 {
+	k := int64(l.data())
+	return lengthSize + int64(l.Parity) + n + int64(l.Parity)*((n+k-1)/k)
+}
+
+// MaxData returns the most bytes whose coded size is at most n.
+func (l Layout) MaxData(n int64) int64 {
+	rest := n - lengthSize - int64(l.Parity)
+	if rest <= 0 {
+		return 0
+	}
+	return rest/255*int64(l.data()) + max(rest%255-int64(l.Parity), 0)
+}
+
+// group returns the data sizes of the codewords of the next group when
+// left bytes of data are still to come.
+func (l Layout) group(left int64) []int {
+	n := int(min(left, int64(l.Depth*l.data())))
+	c := (n + l.data() - 1) / l.data()
+	sizes := make([]int, c)
+	for j := range sizes {
+		sizes[j] = n / c
+		if j < n%c {
+			sizes[j]++
+		}
+	}
+	return sizes
+}
+
+// groupSize is the coded size of a group.
+func (l Layout) groupSize(sizes []int) int {
+	n := 0
+	for _, s := range sizes {
+		n += s + l.Parity
+	}
+	return n
+}
+
+// NewEncoder returns a reader of the n bytes read from r, coded.
+func (l Layout) NewEncoder(r io.Reader, n int64) io.Reader {
+	return &encoder{l: l, code: NewCode(l.Parity), r: r, left: n}
+}
+
+type encoder struct {
+	l       Layout
+	code    *Code
+	r       io.Reader
+	left    int64 // data bytes still to read
+	started bool
+	out     []byte // coded bytes not read yet
+}
+
+func (e *encoder) Read(p []byte) (int, error) {
+	for len(e.out) == 0 {
+		if !e.started {
+			e.started = true
+			e.out = binary.BigEndian.AppendUint32(nil, uint32(e.left))
+			e.out = append(e.out, e.code.Parity(e.out)...)
+			continue
+		}
+		if e.left == 0 {
+			return 0, io.EOF
+		}
+		if err := e.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, e.out)
+	e.out = e.out[n:]
+	return n, nil
+}
+
+// fill codes the next group.
+func (e *encoder) fill() error {
+	sizes := e.l.group(e.left)
+	codewords := make([][]byte, len(sizes))
+	for j, s := range sizes {
+		cw := make([]byte, s, s+e.l.Parity)
+		if _, err := io.ReadFull(e.r, cw); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		codewords[j] = append(cw, e.code.Parity(cw)...)
+		e.left -= int64(s)
+	}
+	e.out = interleave(e.out[:0], codewords)
+	return nil
+}
+
+// interleave appends the bytes of the codewords column by column.
+func interleave(dst []byte, codewords [][]byte) []byte {
+	for i := 0; i < len(codewords[0]); i++ {
+		for _, cw := range codewords {
+			if i < len(cw) {
+				dst = append(dst, cw[i])
+			}
+		}
+	}
+	return dst
+}
+
+// Decoder corrects and writes out the data of a coded stream written to it.
+// Bytes past the end of the stream are ignored.
+type Decoder struct {
+	l         Layout
+	code      *Code
+	w         io.Writer
+	buf       []byte
+	want      int   // coded size of the block being read
+	left      int64 // data bytes still to come, -1 before the length
+	corrected int
+	err       error
+}
+
+// NewDecoder returns a decoder writing the data to w.
+func (l Layout) NewDecoder(w io.Writer) *Decoder {
+	return &Decoder{l: l, code: NewCode(l.Parity), w: w, want: lengthSize + l.Parity, left: -1}
+}
+
+// Done reports whether all of the data was written.
+func (d *Decoder) Done() bool {
+	return d.left == 0
+}
+
+// Corrected returns the number of wrong bytes corrected so far.
+func (d *Decoder) Corrected() int {
+	return d.corrected
+}
+
+// Write fails with ErrUncorrectable once a codeword cannot be corrected,
+// with the error of the underlying writer as it is.
+func (d *Decoder) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 && !d.Done() {
+		if d.err != nil {
+			return 0, d.err
+		}
+		k := min(len(p), d.want-len(d.buf))
+		d.buf, p = append(d.buf, p[:k]...), p[k:]
+		if len(d.buf) == d.want {
+			if d.err = d.block(); d.err != nil {
+				return 0, d.err
+			}
+		}
+	}
+	return n, nil
+}
+
+// block decodes the length or a group once all of it is in buf.
+func (d *Decoder) block() error {
+	if d.left < 0 {
+		n, err := d.code.Correct(d.buf)
+		if err != nil {
+			return err
+		}
+		d.corrected += n
+		d.left = int64(binary.BigEndian.Uint32(d.buf))
+	} else {
+		sizes := d.l.group(d.left)
+		codewords := make([][]byte, len(sizes))
+		for j, s := range sizes {
+			codewords[j] = make([]byte, 0, s+d.l.Parity)
+		}
+		// Undo interleave, every codeword takes one byte of a column
+		// until it is full
+		for i, k := 0, 0; k < len(d.buf); i++ {
+			for j := range codewords {
+				if i < sizes[j]+d.l.Parity {
+					codewords[j] = append(codewords[j], d.buf[k])
+					k++
+				}
+			}
+		}
+		for j, cw := range codewords {
+			n, err := d.code.Correct(cw)
+			if err != nil {
+				return err
+			}
+			d.corrected += n
+			if _, err := d.w.Write(cw[:sizes[j]]); err != nil {
+				return err
+			}
+			d.left -= int64(sizes[j])
+		}
+	}
+	d.buf = d.buf[:0]
+	if d.left > 0 {
+		d.want = d.l.groupSize(d.l.group(d.left))
+	}
+	return nil
+}
+
+// Decode reads a coded stream from r and returns its data and the number of
+// wrong bytes corrected. A stream cut short fails with
+// io.ErrUnexpectedEOF.
+func (l Layout) Decode(r io.Reader) ([]byte, int, error) {
+	var out bytes.Buffer
+	d := l.NewDecoder(&out)
+	for !d.Done() {
+		if _, err := io.CopyN(d, r, int64(d.want-len(d.buf))); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, d.corrected, err
+		}
+	}
+	return out.Bytes(), d.corrected, nil
+}
@@ -0,0 +1,197 @@
+// Package rs is a Reed-Solomon code over GF(2^8) and the interleaved
+// layout the embedded bit stream is protected with when a single flipped
+// unit must not cost the payload.
+package rs
+
+import (
+	"errors"
+	"slices"
+)
+
+// ErrUncorrectable is returned for a codeword with more wrong bytes than
+// its parity corrects.
+var ErrUncorrectable = errors.New("too many errors to correct")
+
+// GF(2^8) with the polynomial x^8+x^4+x^3+x^2+1 and generator α = 2. exp
+// is doubled so products need no reduction.
+var (
+	exp  [510]byte
+	logt [256]int
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i], exp[i+255] = byte(x), byte(x)
+		logt[x] = i
+		if x <<= 1; x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+}
+
+func mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return exp[logt[a]+logt[b]]
+}
+
+func div(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return exp[logt[a]+255-logt[b]]
+}
+
+// pow returns α^e.
+func pow(e int) byte {
+	return exp[(e%255+255)%255]
+}
+
+// eval evaluates a polynomial stored highest degree first at x.
+func eval(p []byte, x byte) byte {
+	var v byte
+	for _, c := range p {
+		v = mul(v, x) ^ c
+	}
+	return v
+}
+
+// evalLow evaluates a polynomial stored lowest degree first at x.
+func evalLow(p []byte, x byte) byte {
+	var v byte
+	for i := len(p) - 1; i >= 0; i-- {
+		v = mul(v, x) ^ p[i]
+	}
+	return v
+}
+
+// Code adds parity bytes to codewords of up to 255 bytes and corrects up to
+// half as many wrong bytes anywhere in them. Shorter codewords are the
+// longer ones with leading zeros left out.
+type Code struct {
+	parity int
+	gen    []byte // generator polynomial (x-α^0)...(x-α^(parity-1)), highest degree first
+}
+
+// NewCode returns the code with parity parity bytes per codeword.
+func NewCode(parity int) *Code {
+	gen := []byte{1}
+	for i := range parity {
+		next := make([]byte, len(gen)+1)
+		for j, c := range gen {
+			next[j] ^= c
+			next[j+1] ^= mul(c, exp[i])
+		}
+		gen = next
+	}
+	return &Code{parity: parity, gen: gen}
+}
+
+// Parity returns the parity bytes that follow data, at most 255 bytes less
+// the parity, in its codeword.
+func (c *Code) Parity(data []byte) []byte {
+	rem := make([]byte, c.parity)
+	for _, d := range data {
+		f := d ^ rem[0]
+		copy(rem, rem[1:])
+		rem[len(rem)-1] = 0
+		if f != 0 {
+			for j := range rem {
+				rem[j] ^= mul(c.gen[j+1], f)
+			}
+		}
+	}
+	return rem
+}
+
+// Correct corrects the codeword cw, data then parity, in place and returns
+// how many bytes it changed. A codeword it cannot correct is left as it
+// was.
+func (c *Code) Correct(cw []byte) (int, error) {
+	p, n := c.parity, len(cw)
+	synd := make([]byte, p)
+	clean := true
+	for i := range synd {
+		synd[i] = eval(cw, exp[i])
+		clean = clean && synd[i] == 0
+	}
+	if clean {
+		return 0, nil
+	}
+
+	// Berlekamp-Massey finds the error locator, lowest degree first
+	loc := make([]byte, p+1)
+	prev := make([]byte, p+1)
+	loc[0], prev[0] = 1, 1
+	l, m, b := 0, 1, byte(1)
+	for r := range p {
+		d := synd[r]
+		for i := 1; i <= l; i++ {
+			d ^= mul(loc[i], synd[r-i])
+		}
+		if d == 0 {
+			m++
+			continue
+		}
+		t := slices.Clone(loc)
+		f := div(d, b)
+		for i := 0; i+m <= p; i++ {
+			loc[i+m] ^= mul(f, prev[i])
+		}
+		if 2*l <= r {
+			l, prev, b, m = r+1-l, t, d, 1
+		} else {
+			m++
+		}
+	}
+	if 2*l > p {
+		return 0, ErrUncorrectable
+	}
+	loc = loc[:l+1]
+
+	// Chien search: byte a is wrong when the locator has a root at the
+	// inverse of α^(n-1-a)
+	var wrong []int
+	for a := range n {
+		if evalLow(loc, pow(a+1-n)) == 0 {
+			wrong = append(wrong, a)
+		}
+	}
+	if len(wrong) != l {
+		return 0, ErrUncorrectable
+	}
+
+	// Forney: the error values from the evaluator S(x)Λ(x) mod x^p
+	omega := make([]byte, p)
+	for i := range omega {
+		for j := 0; j <= min(i, l); j++ {
+			omega[i] ^= mul(synd[i-j], loc[j])
+		}
+	}
+	fix := make([]byte, len(wrong))
+	for k, a := range wrong {
+		e := n - 1 - a
+		var deriv byte
+		for i := 1; i <= l; i += 2 {
+			deriv ^= mul(loc[i], pow(-e*(i-1)))
+		}
+		if deriv == 0 {
+			return 0, ErrUncorrectable
+		}
+		fix[k] = div(mul(pow(e), evalLow(omega, pow(-e))), deriv)
+	}
+	for k, a := range wrong {
+		cw[a] ^= fix[k]
+	}
+	for i := range synd {
+		if eval(cw, exp[i]) != 0 {
+			for k, a := range wrong {
+				cw[a] ^= fix[k]
+			}
+			return 0, ErrUncorrectable
+		}
+	}
+	return l, nil
+}
@@ -0,0 +1,179 @@
+package rs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// damage changes n distinct bytes of b at random to other values.
+func damage(rng *rand.Rand, b []byte, n int) {
+	for _, i := range rng.Perm(len(b))[:n] {
+		b[i] ^= byte(1 + rng.Intn(255))
+	}
+}
+
+// codeword returns a codeword of n bytes of c, data then parity.
+func codeword(rng *rand.Rand, c *Code, n int) []byte {
+	data := make([]byte, n-c.parity)
+	rng.Read(data)
+	return append(data, c.Parity(data)...)
+}
+
+func TestCorrect(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, parity := range []int{2, 16, 32, MaxParity} {
+		c := NewCode(parity)
+		for _, n := range []int{255, parity + 10} {
+			for errs := 0; errs <= parity/2; errs++ {
+				cw := codeword(rng, c, n)
+				want := bytes.Clone(cw)
+				damage(rng, cw, errs)
+				got, err := c.Correct(cw)
+				if err != nil {
+					t.Fatalf("parity %d, %d bytes, %d errors: %v", parity, n, errs, err)
+				}
+				if got != errs || !bytes.Equal(cw, want) {
+					t.Fatalf("parity %d, %d bytes, %d errors: corrected %d, codeword restored %v", parity, n, errs, got, bytes.Equal(cw, want))
+				}
+			}
+		}
+	}
+}
+
+func TestCorrectTooMany(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	// Few parity bytes can take too many errors for a codeword with
+	// fewer, so the code is only checked where that is unlikely
+	for _, parity := range []int{16, 32, MaxParity} {
+		c := NewCode(parity)
+		for _, errs := range []int{parity/2 + 1, parity} {
+			cw := codeword(rng, c, 255)
+			damage(rng, cw, errs)
+			damaged := bytes.Clone(cw)
+			if _, err := c.Correct(cw); !errors.Is(err, ErrUncorrectable) {
+				t.Fatalf("parity %d, %d errors: %v, want %v", parity, errs, err, ErrUncorrectable)
+			}
+			if !bytes.Equal(cw, damaged) {
+				t.Fatalf("parity %d, %d errors: codeword changed", parity, errs)
+			}
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	for _, l := range []Layout{{2, 1}, {MaxParity, 255}} {
+		if err := l.Check(); err != nil {
+			t.Errorf("%+v: %v", l, err)
+		}
+	}
+	for _, l := range []Layout{{0, 1}, {1, 1}, {MaxParity + 1, 1}, {2, 0}, {2, 256}} {
+		if l.Check() == nil {
+			t.Errorf("%+v passed", l)
+		}
+	}
+	// A full codeword holds more data than parity
+	if l := (Layout{Parity: MaxParity}); l.data() <= l.Parity {
+		t.Errorf("%d data bytes to %d parity", l.data(), l.Parity)
+	}
+}
+
+// encode returns data coded with l.
+func encode(t *testing.T, l Layout, data []byte) []byte {
+	t.Helper()
+	coded, err := io.ReadAll(l.NewEncoder(bytes.NewReader(data), int64(len(data))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(coded)) != l.EncodedSize(int64(len(data))) {
+		t.Fatalf("%s: %d bytes coded into %d, EncodedSize says %d", l, len(data), len(coded), l.EncodedSize(int64(len(data))))
+	}
+	return coded
+}
+
+func TestLayoutRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, l := range []Layout{{Parity: 2, Depth: 1}, {Parity: 16, Depth: 4}, {Parity: 32, Depth: 16}, {Parity: MaxParity, Depth: 3}} {
+		full := l.Depth * l.data()
+		for _, n := range []int{0, 1, l.data(), full, full + 1, 3*full - 7} {
+			data := make([]byte, n)
+			rng.Read(data)
+			coded := encode(t, l, data)
+
+			got, corrected, err := l.Decode(bytes.NewReader(coded))
+			if err != nil || corrected != 0 || !bytes.Equal(got, data) {
+				t.Fatalf("%s, %d bytes: corrected %d, %v", l, n, corrected, err)
+			}
+
+			// Written in pieces, with bytes past the end of the stream
+			var out bytes.Buffer
+			d := l.NewDecoder(&out)
+			rest := append(bytes.Clone(coded), 1, 2, 3)
+			for len(rest) > 0 {
+				k := min(len(rest), 100)
+				if _, err := d.Write(rest[:k]); err != nil {
+					t.Fatalf("%s, %d bytes: write: %v", l, n, err)
+				}
+				rest = rest[k:]
+			}
+			if !d.Done() || !bytes.Equal(out.Bytes(), data) {
+				t.Fatalf("%s, %d bytes: decoder done %v", l, n, d.Done())
+			}
+
+			if _, _, err := l.Decode(bytes.NewReader(coded[:len(coded)-1])); err != io.ErrUnexpectedEOF {
+				t.Fatalf("%s, %d bytes cut short: %v", l, n, err)
+			}
+		}
+	}
+}
+
+func TestLayoutBurst(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	l := Layout{Parity: 16, Depth: 8}
+	data := make([]byte, 3*l.Depth*l.data())
+	rng.Read(data)
+	coded := encode(t, l, data)
+
+	// A burst as long as the interleaving spreads over all codewords
+	// of a group within what they correct, and one byte longer
+	start := lengthSize + l.Parity + 100
+	burst := l.Depth * l.Parity / 2
+	for _, n := range []int{burst, burst + 1} {
+		damaged := bytes.Clone(coded)
+		for i := start; i < start+n; i++ {
+			damaged[i] ^= 0xA5
+		}
+		got, corrected, err := l.Decode(bytes.NewReader(damaged))
+		if n == burst {
+			if err != nil || corrected != burst || !bytes.Equal(got, data) {
+				t.Fatalf("burst of %d: corrected %d, %v", n, corrected, err)
+			}
+		} else if !errors.Is(err, ErrUncorrectable) {
+			t.Fatalf("burst of %d: %v, want %v", n, err, ErrUncorrectable)
+		}
+	}
+
+	// Errors in the length are corrected too
+	damaged := bytes.Clone(coded)
+	damage(rng, damaged[:lengthSize+l.Parity], l.Parity/2)
+	got, corrected, err := l.Decode(bytes.NewReader(damaged))
+	if err != nil || corrected != l.Parity/2 || !bytes.Equal(got, data) {
+		t.Fatalf("damaged length: corrected %d, %v", corrected, err)
+	}
+}
+
+func TestMaxData(t *testing.T) {
+	for _, l := range []Layout{{Parity: 2, Depth: 1}, {Parity: 16, Depth: 16}, {Parity: MaxParity, Depth: 2}} {
+		for n := int64(0); n < 2000; n++ {
+			m := l.MaxData(n)
+			if m > 0 && l.EncodedSize(m) > n {
+				t.Fatalf("%s: MaxData(%d) = %d codes into %d bytes", l, n, m, l.EncodedSize(m))
+			}
+			if l.EncodedSize(m+1) <= n {
+				t.Fatalf("%s: MaxData(%d) = %d, but %d bytes fit", l, n, m, m+1)
+			}
+		}
+	}
+}
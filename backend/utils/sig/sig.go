@@ -1,33 +1,21 @@
 package sig
 
-type Pair struct{ 
-	S, E []uint8 
-}
+// Len is the length of the signatures in bits.
+const Len = 14
 
-func Bits(s string) []uint8 { 
-	r := make([]uint8, len(s))
-	for i, c := range s { 
-		if c=='1' { 
-			r[i]=1 
-		} 
-	} 
-	return r 
+// Pair holds the start and end signatures of one width, Len bits each.
+type Pair struct {
+	S, E uint64
 }
 
-var Map = map[int]Pair{ 
-	1:{Bits("10101010101010"),Bits("10101010101010")}, 
-	2:{Bits("01010101010101"),Bits("01010101010101")}, 
-	3:{Bits("10101010101010"),Bits("01010101010101")},
-	4:{Bits("01010101010101"),Bits("10101010101010")}, 
+var Map = map[int]Pair{
+	1: {0b10101010101010, 0b10101010101010},
+	2: {0b01010101010101, 0b01010101010101},
+	3: {0b10101010101010, 0b01010101010101},
+	4: {0b01010101010101, 0b10101010101010},
 }
 
-func WidthByte(n int) []uint8 { 
-	x:=byte('0'+byte(n))
-	r:=make([]uint8,8)
-	for i:=7;i>=0;i--{ 
-		if (x>>uint(i))&1==1 { 
-			r[7-i]=1 
-		} 
-	} 
-	return r 
+// WidthByte is the byte recording the width after the start signature.
+func WidthByte(n int) byte {
+	return '0' + byte(n)
 }
@@ -77,7 +77,7 @@ export default function ExtractionSection() {
       const fallbackName =
         trimmedOutput ||
         (stegoAudio
-          ? `${stegoAudio.name.replace(/\.(mp3|mpeg)$/i, '')}-extracted.bin`
+          ? `${stegoAudio.name.replace(/\.(mp3|mpeg|wav|flac|aiff?|aifc)$/i, '')}-extracted.bin`
           : 'secret.bin');
 
       const urlFilename = response.secretFileUrl
@@ -148,7 +148,7 @@ export default function ExtractionSection() {
             <Input
               id="stego-audio"
               type="file"
-              accept="audio/mpeg,audio/mp3"
+              accept="audio/mpeg,audio/mp3,audio/wav,audio/x-wav,audio/flac,audio/aiff,audio/x-aiff"
               onChange={handleStegoInput}
             />
             <p className="text-sm text-muted-foreground">
@@ -177,7 +177,7 @@ export default function InsertionSection() {
             <Input
               id="cover-audio"
               type="file"
-              accept="audio/mpeg,audio/mp3"
+              accept="audio/mpeg,audio/mp3,audio/wav,audio/x-wav,audio/flac,audio/aiff,audio/x-aiff"
               onChange={handleCoverInput}
             />
             <p className="text-sm text-muted-foreground">